@@ -9,12 +9,16 @@ import (
 	"time"
 
 	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/i18n"
 )
 
 type dataAdminHome struct {
 	dataPageBase
 
 	Cycle *common.Cycle
+
+	// Recent login lockouts
+	LoginNotices []loginNotice
 }
 
 type dataAdminUserEdit struct {
@@ -28,6 +32,11 @@ type dataAdminUserEdit struct {
 	NotifyError []string
 	UrlKey      *common.UrlKey
 	Host        string
+
+	LoginFailures int
+	LockedUntil   time.Time
+
+	Sessions []*common.Session
 }
 
 func (s *Server) checkAdminRights(w http.ResponseWriter, r *http.Request) bool {
@@ -47,6 +56,17 @@ func (s *Server) checkAdminRights(w http.ResponseWriter, r *http.Request) bool {
 		return false
 	}
 
+	// Sessions that skipped the second factor don't get in, even if the
+	// password was right.
+	if (user.TotpEnabled() || s.twoFactorRequired(user)) && !s.hasTwoFactor(user, r) {
+		s.l.Info("Refusing admin access for %s without two-factor authentication", user.Name)
+		s.doError(
+			http.StatusForbidden,
+			"Two-factor authentication is required for this page.  Set it up on your account page, or log out and log in again with your code.",
+			w, r)
+		return false
+	}
+
 	return true
 }
 
@@ -64,7 +84,8 @@ func (s *Server) handlerAdmin(w http.ResponseWriter, r *http.Request) {
 	data := dataAdminHome{
 		dataPageBase: s.newPageBase("Admin", w, r),
 
-		Cycle: cycle,
+		Cycle:        cycle,
+		LoginNotices: s.loginLimits.getNotices(),
 	}
 
 	if err := s.executeTemplate(w, "adminHome", data); err != nil {
@@ -101,22 +122,31 @@ func (s *Server) handlerAdminUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 // "deletes" a user.  The account will still exist along with the votes, but
-// the name, password, email, and notification settings will all be removed.
+// the name, password, email, two-factor secrets, calendar link, and
+// notification settings will all be removed.
 func (s *Server) adminDeleteUser(w http.ResponseWriter, r *http.Request, user *common.User) {
-	confirm := r.URL.Query().Get("confirm")
-	if confirm == "yes" {
+	if r.Method == "POST" && r.PostFormValue("confirm") == "yes" {
 		s.l.Info("Deleting user %s", user)
 		origName := user.Name
 		user.Name = "[deleted]"
 		user.Password = ""
 		user.PassDate = time.Now()
+		user.OAuthToken = ""
+		user.TotpSecret = ""
+		user.TotpLastStep = 0
+		user.RecoveryCodes = nil
+		user.TwitchName = ""
+		user.CalendarToken = ""
 		user.Email = ""
 		user.NotifyCycleEnd = false
 		user.NotifyVoteSelection = false
 		user.Privilege = 0
 		user.RateLimitOverride = false
 
-		err := s.data.UpdateUser(user)
+		err := s.revokeOidcSubject(user)
+		if err == nil {
+			err = s.data.UpdateUser(user)
+		}
 		if err != nil {
 			s.doError(
 				http.StatusBadRequest,
@@ -125,6 +155,19 @@ func (s *Server) adminDeleteUser(w http.ResponseWriter, r *http.Request, user *c
 			return
 		}
 
+		// Log them out everywhere
+		if err = s.data.DeleteUserSessions(user.Id); err != nil {
+			s.doError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Unable to delete sessions: %v", err),
+				w, r)
+			return
+		}
+
+		if s.twitch != nil {
+			s.twitch.dropLinkCodes(user.Id)
+		}
+
 		data := struct {
 			dataPageBase
 
@@ -160,7 +203,7 @@ func (s *Server) adminDeleteUser(w http.ResponseWriter, r *http.Request, user *c
 		Message:      fmt.Sprintf("Are you sure you want to remove the account of %q?  Its votes will stay intact, but everything else will be cleared.", user.Name),
 		TrueMessage:  "Delete",
 		FalseMessage: "Cancel",
-		TrueLink:     fmt.Sprintf("/admin/user/%d?action=delete&confirm=yes", user.Id),
+		TrueLink:     fmt.Sprintf("/admin/user/%d?action=delete", user.Id),
 		FalseLink:    "/admin/users",
 	}
 
@@ -181,11 +224,13 @@ func (s *Server) adminBanUser(w http.ResponseWriter, r *http.Request, user *comm
 // Purge removes the account entirely, including all of the account's votes.
 // Should this add the user to the banlist?  Maybe add an option?
 func (s *Server) adminPurgeUser(w http.ResponseWriter, r *http.Request, user *common.User) {
-	confirm := r.URL.Query().Get("confirm")
-	if confirm == "yes" {
+	if r.Method == "POST" && r.PostFormValue("confirm") == "yes" {
 		s.l.Info("Purging user %s", user)
 		origName := user.Name
-		err := s.data.PurgeUser(user.Id)
+		err := s.revokeOidcSubject(user)
+		if err == nil {
+			err = s.data.PurgeUser(user.Id)
+		}
 		if err != nil {
 			s.doError(
 				http.StatusBadRequest,
@@ -194,6 +239,10 @@ func (s *Server) adminPurgeUser(w http.ResponseWriter, r *http.Request, user *co
 			return
 		}
 
+		if s.twitch != nil {
+			s.twitch.dropLinkCodes(user.Id)
+		}
+
 		data := struct {
 			dataPageBase
 
@@ -228,7 +277,7 @@ func (s *Server) adminPurgeUser(w http.ResponseWriter, r *http.Request, user *co
 		Message:      fmt.Sprintf("Are you sure you want to PURGE the account of %q?  Votes will be deleted.", user.Name),
 		TrueMessage:  "PURGE",
 		FalseMessage: "Cancel",
-		TrueLink:     fmt.Sprintf("/admin/user/%d?action=purge&confirm=yes", user.Id),
+		TrueLink:     fmt.Sprintf("/admin/user/%d?action=purge", user.Id),
 		FalseLink:    "/admin/users",
 	}
 
@@ -276,6 +325,10 @@ func (s *Server) handlerAdminUserEdit(w http.ResponseWriter, r *http.Request) {
 		s.adminPurgeUser(w, r, user)
 		return
 	case "password":
+		if r.Method != "POST" {
+			break
+		}
+
 		urlKey, err = common.NewPasswordResetKey(user.Id)
 		if err != nil {
 			s.l.Error("Unable to generate UrlKey pair for user password reset: %v", err)
@@ -323,8 +376,67 @@ func (s *Server) handlerAdminUserEdit(w http.ResponseWriter, r *http.Request) {
 		Host:           host,
 	}
 
-	// FIXME: implement this
+	// FIXME: implement the rest of this
 	if r.Method == "POST" {
+		if err = r.ParseForm(); err != nil {
+			s.l.Error("Unable to parse form: %v", err)
+			s.doError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Unable to parse form: %v", err),
+				w, r)
+			return
+		}
+
+		if r.PostFormValue("Form") == "RateLimit" {
+			user.RateLimitOverride = r.PostFormValue("RateLimitOverride") != ""
+			if err = s.data.UpdateUser(user); err != nil {
+				s.doError(
+					http.StatusInternalServerError,
+					fmt.Sprintf("Unable to update user: %v", err),
+					w, r)
+				return
+			}
+		}
+
+		if r.PostFormValue("Form") == "ResetTwoFactor" {
+			user.TotpSecret = ""
+			user.TotpLastStep = 0
+			user.RecoveryCodes = nil
+			if err = s.data.UpdateUser(user); err != nil {
+				s.doError(
+					http.StatusInternalServerError,
+					fmt.Sprintf("Unable to update user: %v", err),
+					w, r)
+				return
+			}
+			s.l.Info("Two-factor authentication for %q reset", user.Name)
+		}
+
+		if r.PostFormValue("Form") == "ForceLogout" {
+			if err = s.data.DeleteUserSessions(user.Id); err != nil {
+				s.doError(
+					http.StatusInternalServerError,
+					fmt.Sprintf("Unable to delete sessions: %v", err),
+					w, r)
+				return
+			}
+			s.l.Info("Logged out %q everywhere", user.Name)
+		}
+
+		if r.PostFormValue("Form") == "ClearLockout" {
+			s.loginLimits.clear(user.Name)
+			s.l.Info("Login lockout for %q cleared", user.Name)
+		}
+	}
+
+	data.Sessions, err = s.getUserSessions(user.Id)
+	if err != nil {
+		s.l.Error("%v", err)
+	}
+
+	data.LoginFailures, data.LockedUntil = s.loginLimits.status(user.Name)
+	if !data.LockedUntil.After(time.Now()) {
+		data.LockedUntil = time.Time{}
 	}
 
 	if err := s.executeTemplate(w, "adminUserEdit", data); err != nil {
@@ -367,6 +479,7 @@ func (s *Server) handlerAdminConfig(w http.ResponseWriter, r *http.Request) {
 		Values: []configValue{
 			configValue{Key: ConfigHostAddress, Default: "", Type: ConfigString},
 			configValue{Key: ConfigNoticeBanner, Default: "", Type: ConfigString},
+			configValue{Key: ConfigTimeZone, Default: DefaultTimeZone, Type: ConfigString},
 
 			configValue{Key: ConfigVotingEnabled, Default: DefaultVotingEnabled, Type: ConfigBool},
 			configValue{Key: ConfigMaxUserVotes, Default: DefaultMaxUserVotes, Type: ConfigInt},
@@ -387,8 +500,50 @@ func (s *Server) handlerAdminConfig(w http.ResponseWriter, r *http.Request) {
 			configValue{Key: ConfigMaxDescriptionLength, Default: DefaultMaxDescriptionLength, Type: ConfigInt},
 			configValue{Key: ConfigMaxLinkLength, Default: DefaultMaxLinkLength, Type: ConfigInt},
 			configValue{Key: ConfigMaxRemarksLength, Default: DefaultMaxRemarksLength, Type: ConfigInt},
+			configValue{Key: ConfigMaxReviewLength, Default: DefaultMaxReviewLength, Type: ConfigInt},
+			configValue{Key: ConfigMaxTags, Default: DefaultMaxTags, Type: ConfigInt},
+			configValue{Key: ConfigMaxTagLength, Default: DefaultMaxTagLength, Type: ConfigInt},
 
 			configValue{Key: ConfigUnlimitedVotes, Default: DefaultUnlimitedVotes, Type: ConfigBool},
+
+			configValue{Key: ConfigPosterStorage, Default: DefaultPosterStorage, Type: ConfigString},
+			configValue{Key: ConfigS3Endpoint, Default: "", Type: ConfigString},
+			configValue{Key: ConfigS3Region, Default: DefaultS3Region, Type: ConfigString},
+			configValue{Key: ConfigS3Bucket, Default: "", Type: ConfigString},
+			configValue{Key: ConfigS3AccessKey, Default: "", Type: ConfigString},
+			configValue{Key: ConfigS3SecretKey, Default: "", Type: ConfigString},
+			configValue{Key: ConfigS3Prefix, Default: DefaultS3Prefix, Type: ConfigString},
+			configValue{Key: ConfigS3PublicUrl, Default: "", Type: ConfigString},
+
+			configValue{Key: ConfigMaxUserMoviesPerCycle, Default: DefaultMaxUserMoviesPerCycle, Type: ConfigInt},
+			configValue{Key: ConfigMovieAddCooldown, Default: DefaultMovieAddCooldown, Type: ConfigInt},
+
+			configValue{Key: ConfigOidcEnabled, Default: DefaultOidcEnabled, Type: ConfigBool},
+			configValue{Key: ConfigOidcName, Default: DefaultOidcName, Type: ConfigString},
+			configValue{Key: ConfigOidcIssuer, Default: "", Type: ConfigString},
+			configValue{Key: ConfigOidcClientId, Default: "", Type: ConfigString},
+			configValue{Key: ConfigOidcClientSecret, Default: "", Type: ConfigString},
+			configValue{Key: ConfigOidcGroupsClaim, Default: DefaultOidcGroupsClaim, Type: ConfigString},
+			configValue{Key: ConfigOidcModGroup, Default: "", Type: ConfigString},
+			configValue{Key: ConfigOidcAdminGroup, Default: "", Type: ConfigString},
+			configValue{Key: ConfigOidcCreateUsers, Default: DefaultOidcCreateUsers, Type: ConfigBool},
+
+			configValue{Key: ConfigLoginMaxFailures, Default: DefaultLoginMaxFailures, Type: ConfigInt},
+			configValue{Key: ConfigLoginLockout, Default: DefaultLoginLockout, Type: ConfigInt},
+			configValue{Key: ConfigTrustedProxies, Default: DefaultTrustedProxies, Type: ConfigString},
+			configValue{Key: ConfigRequireTwoFactor, Default: DefaultRequireTwoFactor, Type: ConfigBool},
+
+			configValue{Key: ConfigOverlayToken, Default: DefaultOverlayToken, Type: ConfigString},
+
+			configValue{Key: ConfigDiscordWebhook, Default: DefaultDiscordWebhook, Type: ConfigString},
+			configValue{Key: ConfigDiscordReminder, Default: DefaultDiscordReminder, Type: ConfigInt},
+
+			configValue{Key: ConfigTwitchEnabled, Default: DefaultTwitchEnabled, Type: ConfigBool},
+			configValue{Key: ConfigTwitchServer, Default: DefaultTwitchServer, Type: ConfigString},
+			configValue{Key: ConfigTwitchTls, Default: DefaultTwitchTls, Type: ConfigBool},
+			configValue{Key: ConfigTwitchNick, Default: "", Type: ConfigString},
+			configValue{Key: ConfigTwitchToken, Default: "", Type: ConfigString},
+			configValue{Key: ConfigTwitchChannel, Default: "", Type: ConfigString},
 		},
 
 		TypeString: ConfigString,
@@ -412,6 +567,15 @@ func (s *Server) handlerAdminConfig(w http.ResponseWriter, r *http.Request) {
 			str := r.PostFormValue(val.Key)
 			switch val.Type {
 			case ConfigString:
+				if val.Key == ConfigTimeZone {
+					if _, err = loadTimeZone(str); err != nil {
+						data.ErrorMessage = append(
+							data.ErrorMessage,
+							fmt.Sprintf("Value for %q is invalid: %v", val.Key, err))
+						continue
+					}
+				}
+
 				err = s.data.SetCfgString(val.Key, str)
 				if err != nil {
 					data.ErrorMessage = append(
@@ -513,10 +677,24 @@ func (s *Server) handlerAdminMovieEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Approve and Deny actions
 	action := r.URL.Query().Get("action")
 	switch action {
 	case "remove":
+		if r.Method != "POST" {
+			s.doError(http.StatusMethodNotAllowed, "Removing a movie must be POSTed", w, r)
+			return
+		}
+
+		// Get it before it's gone for the event
+		movie, err := s.data.GetMovie(mid)
+		if err != nil {
+			s.doError(
+				http.StatusBadRequest,
+				fmt.Sprintf("Cannot get movie: %v", err),
+				w, r)
+			return
+		}
+
 		// TODO: Confirmation before removing
 		err = s.data.RemoveMovie(mid)
 		if err != nil {
@@ -528,10 +706,43 @@ func (s *Server) handlerAdminMovieEdit(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		s.publishMovieChange(EventRemoved, movie)
+		http.Redirect(w, r, "/admin/movies", http.StatusSeeOther)
+		return
+
+	case "approve":
+		if r.Method != "POST" {
+			s.doError(http.StatusMethodNotAllowed, "Approving a movie must be POSTed", w, r)
+			return
+		}
+
+		movie, err := s.data.GetMovie(mid)
+		if err != nil {
+			s.doError(
+				http.StatusBadRequest,
+				fmt.Sprintf("Cannot get movie: %v", err),
+				w, r)
+			return
+		}
+
+		if !movie.Approved {
+			movie.Approved = true
+			if err = s.data.UpdateMovie(movie); err != nil {
+				s.doError(
+					http.StatusInternalServerError,
+					fmt.Sprintf("Unable to update movie: %v", err),
+					w, r)
+				return
+			}
+
+			s.publishMovieChange(EventApproved, movie)
+		}
+
 		http.Redirect(w, r, "/admin/movies", http.StatusSeeOther)
 		return
 	}
 
+	errorMessage := []string{}
 	if r.Method == "POST" {
 		err = r.ParseMultipartForm(4096)
 		if err != nil {
@@ -552,6 +763,7 @@ func (s *Server) handlerAdminMovieEdit(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		old := *movie
 		movie.Name = r.PostFormValue("MovieName")
 		movie.Description = r.PostFormValue("MovieDescr")
 
@@ -584,16 +796,31 @@ func (s *Server) handlerAdminMovieEdit(w http.ResponseWriter, r *http.Request) {
 		}
 		movie.Links = linkstructs
 
-		posterFileName := strings.TrimSpace(r.FormValue("MovieName"))
+		tagNames, problems, err := s.parseTagInput(r.PostFormValue("MovieTags"), i18n.Default)
+		if err != nil {
+			s.l.Error("Unable to check tags: %v", err)
+		}
+
+		// Keep the old tags if the new ones are invalid
+		if len(problems) > 0 {
+			errorMessage = append(errorMessage, problems...)
+		} else if err == nil {
+			tags, err := s.addTags(tagNames)
+			if err != nil {
+				s.l.Error("Unable to add tags: %v", err)
+			} else {
+				movie.Tags = tags
+			}
+		}
+
 		posterFile, _, _ := r.FormFile("PosterFile")
 
 		if posterFile != nil {
-			file, err := s.uploadFile(r, posterFileName)
+			file, err := s.uploadPoster(r)
 
 			if err != nil {
-				//data.ErrPoster = true
-				//errText = append(errText, err.Error())
 				s.l.Error("Unable to upload file: %v", err)
+				errorMessage = append(errorMessage, err.Error())
 			} else {
 				movie.Poster = filepath.Base(file)
 			}
@@ -602,7 +829,10 @@ func (s *Server) handlerAdminMovieEdit(w http.ResponseWriter, r *http.Request) {
 		err = s.data.UpdateMovie(movie)
 		if err != nil {
 			s.l.Error("Unable to update movie: %v", err)
+		} else if user := s.getSessionUser(w, r); user != nil {
+			s.recordRevision(user, &old, movie)
 		}
+		s.search.invalidate()
 	}
 
 	movie, err := s.data.GetMovie(mid)
@@ -619,14 +849,30 @@ func (s *Server) handlerAdminMovieEdit(w http.ResponseWriter, r *http.Request) {
 		linktext = linktext + link.Url + "\n"
 	}
 
+	tagNames := []string{}
+	for _, tag := range movie.Tags {
+		tagNames = append(tagNames, tag.Name)
+	}
+
+	revisions, err := s.movieRevisions(movie.Id)
+	if err != nil {
+		s.l.Error("Unable to get revisions of movie %d: %v", movie.Id, err)
+	}
+
 	data := struct {
 		dataPageBase
-		Movie    *common.Movie
-		LinkText string
+		Movie        *common.Movie
+		LinkText     string
+		TagText      string
+		ErrorMessage []string
+		Revisions    []movieRevision
 	}{
 		dataPageBase: s.newPageBase("Admin - Movies", w, r),
 		Movie:        movie,
 		LinkText:     linktext,
+		TagText:      strings.Join(tagNames, ", "),
+		ErrorMessage: errorMessage,
+		Revisions:    revisions,
 	}
 
 	if err := s.executeTemplate(w, "adminMovieEdit", data); err != nil {
@@ -666,12 +912,18 @@ func (s *Server) handlerAdminMovies(w http.ResponseWriter, r *http.Request) {
 		RequireApproval bool
 	}{
 		dataPageBase: s.newPageBase("Admin - Movies", w, r),
-		Active:       common.SortMoviesByName(active),
-		//Pending:      common.SortMoviesByName(active),
 
 		RequireApproval: approval,
 	}
 
+	for _, movie := range common.SortMoviesByName(active) {
+		if approval && !movie.Approved {
+			data.Pending = append(data.Pending, movie)
+		} else {
+			data.Active = append(data.Active, movie)
+		}
+	}
+
 	if err := s.executeTemplate(w, "adminMovies", data); err != nil {
 		s.l.Error("Error rendering template: %v", err)
 	}
@@ -716,7 +968,7 @@ func (s *Server) handlerAdminCycles_Post(w http.ResponseWriter, r *http.Request)
 			return
 		}
 
-		end, err := time.Parse("2006-01-02", dateStr)
+		end, err := parseInputTime(dateStr, s.serverLocation())
 		if err != nil {
 			s.l.Error(err.Error())
 		} else {
@@ -732,7 +984,7 @@ func (s *Server) handlerAdminCycles_Post(w http.ResponseWriter, r *http.Request)
 		}
 
 	case "create":
-		end, err := time.Parse("2006-01-02", r.PostFormValue("endDate"))
+		end, err := parseInputTime(r.PostFormValue("endDate"), s.serverLocation())
 		if err != nil {
 			s.l.Error(err.Error())
 		} else {
@@ -740,7 +992,7 @@ func (s *Server) handlerAdminCycles_Post(w http.ResponseWriter, r *http.Request)
 			plannedEnd = &t
 		}
 
-		_, err = s.data.AddCycle(plannedEnd)
+		cycleId, err := s.data.AddCycle(plannedEnd)
 		if err != nil {
 			s.l.Error("Unable to add cycle: %v", err)
 			s.doError(http.StatusInternalServerError, fmt.Sprintf("Unable to add cycle: %v", err), w, r)
@@ -753,6 +1005,10 @@ func (s *Server) handlerAdminCycles_Post(w http.ResponseWriter, r *http.Request)
 			s.doError(http.StatusInternalServerError, fmt.Sprintf("Unable to enable voting: %v", err), w, r)
 			return
 		}
+
+		if cycle, err := s.data.GetCycle(cycleId); err == nil {
+			s.publishCycle(cycle, CycleStarted, nil)
+		}
 	}
 
 	http.Redirect(w, r, "/admin/cycles", http.StatusSeeOther)
@@ -770,12 +1026,7 @@ func (s *Server) handlerAdminCycles(w http.ResponseWriter, r *http.Request) {
 		s.l.Debug("POSTed values: %s", r.PostForm)
 	}
 
-	// URL parameters override POST
-	if val := r.URL.Query().Get("action"); val != "" {
-		action = val
-	}
-
-	s.l.Debug("action: %q", r.URL.Query().Get("action"))
+	s.l.Debug("action: %q", action)
 	switch action {
 	case "end":
 		//adminEndCycle(w, r)
@@ -790,6 +1041,10 @@ func (s *Server) handlerAdminCycles(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if cycle, err := s.data.GetCurrentCycle(); err == nil {
+			s.publishCycle(cycle, CycleVoting, nil)
+		}
+
 		r.Method = "GET"
 		http.Redirect(w, r, "/admin/cycles", http.StatusSeeOther)
 		return
@@ -809,11 +1064,16 @@ func (s *Server) handlerAdminCycles(w http.ResponseWriter, r *http.Request) {
 		dataPageBase
 		Cycle *common.Cycle
 		Past  []*common.Cycle
+
+		// Name of the server's time zone
+		TimeZone string
 	}{
 		dataPageBase: s.newPageBase("Admin - Cycles", w, r),
 
 		Cycle: cycle,
 		Past:  []*common.Cycle{},
+
+		TimeZone: s.serverLocation().String(),
 	}
 
 	pastCycles, err := s.data.GetPastCycles(0, 5)
@@ -834,7 +1094,12 @@ func (s *Server) handlerAdminCycles(w http.ResponseWriter, r *http.Request) {
 // display movies to select
 func (s *Server) cycleStage1(w http.ResponseWriter, r *http.Request) {
 	s.l.Debug("cycleStage1")
-	err := s.data.SetCfgBool(ConfigVotingEnabled, false)
+	wasEnabled, err := s.data.GetCfgBool(ConfigVotingEnabled, DefaultVotingEnabled)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigVotingEnabled, err)
+	}
+
+	err = s.data.SetCfgBool(ConfigVotingEnabled, false)
 	if err != nil {
 		s.doError(http.StatusInternalServerError, fmt.Sprintf("Unable to disable voting: %v", err), w, r)
 		return
@@ -864,6 +1129,10 @@ func (s *Server) cycleStage1(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if wasEnabled {
+		s.publishCycle(currentCycle, CycleEnding, nil)
+	}
+
 	data := struct {
 		dataPageBase
 
@@ -947,11 +1216,12 @@ func (s *Server) cycleStage2(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Set movie as "watched" today
+	// Set movie as "watched" today.  Scheduling showings on the showings
+	// page moves this to the first one.
 	watched := time.Now().Local().Round(time.Hour)
 
 	if val := r.PostFormValue("OverrideEndDate"); val != "" {
-		newEnd, err := time.Parse("2006-01-02", r.PostFormValue("NewEndDate"))
+		newEnd, err := parseInputTime(r.PostFormValue("NewEndDate"), s.serverLocation())
 		if err != nil {
 			s.l.Error("Unable to parse new end date: %q: %v", r.PostFormValue("NewEndDate"), err)
 		} else {
@@ -975,6 +1245,8 @@ func (s *Server) cycleStage2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.publishCycle(cycle, CycleEnded, movies)
+
 	// Clear status
 	//err = s.data.SetCfgString("CycleStage", "")
 	//if err != nil {
@@ -982,6 +1254,6 @@ func (s *Server) cycleStage2(w http.ResponseWriter, r *http.Request) {
 	//	return
 	//}
 
-	// Redirect to admin page
-	http.Redirect(w, r, "/admin/cycles", http.StatusSeeOther)
+	// Schedule the watch nights next
+	http.Redirect(w, r, "/admin/showings", http.StatusSeeOther)
 }
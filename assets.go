@@ -0,0 +1,257 @@
+package moviepoll
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	staticDir = "static"
+
+	// Fingerprinted URLs never change content
+	cacheImmutable = "public, max-age=31536000, immutable"
+	// Everything else is checked against its ETag
+	cacheRevalidate = "no-cache"
+	// Posters from before the poster storage, and the placeholder
+	cachePosterLegacy = "public, max-age=86400"
+)
+
+// Only text is worth compressing, fonts and images already are.
+var assetCompressible = map[string]bool{
+	".css":  true,
+	".js":   true,
+	".html": true,
+	".svg":  true,
+	".json": true,
+	".txt":  true,
+}
+
+// url() references in stylesheets, without the query string
+var re_cssUrl = regexp.MustCompile(`url\(\s*['"]?([^'")?#]+)(?:[?#][^'")]*)?['"]?\s*\)`)
+
+// asset is a file from the static directory, held in memory along with its
+// precompressed versions.
+type asset struct {
+	Path    string // URL path, eg "/static/css/site.css"
+	Url     string // fingerprinted URL path, eg "/static/css/site.1a2b3c4d5e.css"
+	Hash    string
+	ModTime time.Time
+
+	Data   []byte
+	Gzip   []byte
+	Brotli []byte
+}
+
+type assetCache struct {
+	byPath map[string]*asset
+	byUrl  map[string]*asset
+}
+
+// fingerprintPath inserts hash before the extension of p.
+func fingerprintPath(p, hash string) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "." + hash + ext
+}
+
+// loadAssets reads and fingerprints every file in the static directory.
+// Stylesheets are loaded last so their url() references can point to the
+// fingerprinted fonts and images.
+func loadAssets(files fs.FS) (*assetCache, error) {
+	cache := &assetCache{
+		byPath: map[string]*asset{},
+		byUrl:  map[string]*asset{},
+	}
+
+	names := []string{}
+	styles := []string{}
+	err := fs.WalkDir(files, staticDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		if path.Ext(name) == ".css" {
+			styles = append(styles, name)
+		} else {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %v", staticDir, err)
+	}
+
+	for _, name := range append(names, styles...) {
+		if err := cache.load(files, name); err != nil {
+			return nil, err
+		}
+	}
+
+	return cache, nil
+}
+
+func (c *assetCache) load(files fs.FS, name string) error {
+	info, err := fs.Stat(files, name)
+	if err != nil {
+		return fmt.Errorf("Unable to read asset %s: %v", name, err)
+	}
+
+	data, err := fs.ReadFile(files, name)
+	if err != nil {
+		return fmt.Errorf("Unable to read asset %s: %v", name, err)
+	}
+
+	a := &asset{
+		Path:    "/" + name,
+		ModTime: info.ModTime(),
+	}
+
+	if path.Ext(name) == ".css" {
+		data = c.rewriteCss(a.Path, data)
+	}
+
+	sum := sha256.Sum256(data)
+	a.Hash = hex.EncodeToString(sum[:5])
+	a.Url = fingerprintPath(a.Path, a.Hash)
+	a.Data = data
+
+	if assetCompressible[path.Ext(name)] {
+		if a.Gzip, err = compressGzip(data); err != nil {
+			return fmt.Errorf("Unable to compress %s: %v", name, err)
+		}
+
+		if a.Brotli, err = compressBrotli(data); err != nil {
+			return fmt.Errorf("Unable to compress %s: %v", name, err)
+		}
+	}
+
+	c.byPath[a.Path] = a
+	c.byUrl[a.Url] = a
+	return nil
+}
+
+// rewriteCss points url() references in the stylesheet at p to the
+// fingerprinted URLs of the files they reference.
+func (c *assetCache) rewriteCss(p string, data []byte) []byte {
+	return re_cssUrl.ReplaceAllFunc(data, func(match []byte) []byte {
+		ref := string(re_cssUrl.FindSubmatch(match)[1])
+		if strings.Contains(ref, ":") {
+			// data: URIs and other sites
+			return match
+		}
+
+		if !strings.HasPrefix(ref, "/") {
+			ref = path.Join(path.Dir(p), ref)
+		}
+
+		if a, ok := c.byPath[ref]; ok {
+			return []byte(fmt.Sprintf("url('%s')", a.Url))
+		}
+		return match
+	})
+}
+
+func compressGzip(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func compressBrotli(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := brotli.NewWriterLevel(buf, brotli.BestCompression)
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// acceptsEncoding checks the Accept-Encoding header for the given coding.
+// Codings with a q-value of zero are refused.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), coding) {
+			continue
+		}
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serve writes the asset with the best encoding the client accepts.
+func (a *asset) serve(w http.ResponseWriter, r *http.Request, cacheControl string) {
+	data := a.Data
+	etag := a.Hash
+
+	if a.Gzip != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if acceptsEncoding(r, "br") && len(a.Brotli) < len(data) {
+			data = a.Brotli
+			etag += "-br"
+			w.Header().Set("Content-Encoding", "br")
+		} else if acceptsEncoding(r, "gzip") && len(a.Gzip) < len(data) {
+			data = a.Gzip
+			etag += "-gz"
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, a.Path, a.ModTime, bytes.NewReader(data))
+}
+
+// assetUrl returns the fingerprinted URL of a file in the static directory.
+// The plain URL is returned for unknown files and while debugging, as files
+// are reloaded from disk then.
+func (s *Server) assetUrl(name string) string {
+	p := "/static/" + strings.TrimLeft(name, "/")
+	if s.debug || s.assets == nil {
+		return p
+	}
+
+	if a, ok := s.assets.byPath[p]; ok {
+		return a.Url
+	}
+	return p
+}
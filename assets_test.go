@@ -0,0 +1,154 @@
+package moviepoll
+
+import (
+	"bytes"
+	"compress/gzip"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+func Test_AssetFingerprints(t *testing.T) {
+	s := newTestServer(t)
+
+	css := s.assetUrl("css/hack/hack.css")
+	if css == "/static/css/hack/hack.css" || !strings.HasSuffix(css, ".css") {
+		t.Fatalf("Stylesheet was not fingerprinted: %q", css)
+	}
+
+	if url := s.assetUrl("css/missing.css"); url != "/static/css/missing.css" {
+		t.Errorf("Unexpected URL for a missing file: %q", url)
+	}
+
+	// Font URLs in the stylesheet point to the fingerprinted fonts
+	font := s.assetUrl("css/hack/fonts/hack-regular.woff2")
+	if !bytes.Contains(s.assets.byUrl[css].Data, []byte("url('"+font+"')")) {
+		t.Errorf("Stylesheet does not reference %q", font)
+	}
+
+	page := httptest.NewRecorder()
+	s.handlerRoot(page, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(page.Body.String(), css) {
+		t.Errorf("Page does not link to %q", css)
+	}
+
+	w := httptest.NewRecorder()
+	s.handlerStatic(w, httptest.NewRequest("GET", font, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("Unexpected response for %q: %d %q", font, w.Code, w.Header().Get("Cache-Control"))
+	}
+
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Fonts should not be compressed")
+	}
+
+	w = httptest.NewRecorder()
+	s.handlerStatic(w, httptest.NewRequest("GET", "/static/css/site.css", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != cacheRevalidate {
+		t.Errorf("Unexpected response for the plain URL: %d %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
+
+func Test_AssetCompression(t *testing.T) {
+	s := newTestServer(t)
+	url := s.assetUrl("css/site.css")
+	plain := s.assets.byUrl[url].Data
+
+	tests := []struct {
+		accept   string
+		encoding string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0, gzip", "gzip"},
+		{"br;q=0.0, gzip;q=0", ""},
+	}
+
+	etags := map[string]bool{}
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Accept-Encoding", tc.accept)
+		w := httptest.NewRecorder()
+		s.handlerStatic(w, r)
+
+		if enc := w.Header().Get("Content-Encoding"); enc != tc.encoding {
+			t.Errorf("Accept-Encoding %q: got encoding %q, expected %q", tc.accept, enc, tc.encoding)
+			continue
+		}
+
+		var body []byte
+		var err error
+		switch tc.encoding {
+		case "gzip":
+			var gz *gzip.Reader
+			if gz, err = gzip.NewReader(w.Body); err == nil {
+				body, err = ioutil.ReadAll(gz)
+			}
+		case "br":
+			body, err = ioutil.ReadAll(brotli.NewReader(w.Body))
+		default:
+			body = w.Body.Bytes()
+		}
+
+		if err != nil || !bytes.Equal(body, plain) {
+			t.Errorf("Accept-Encoding %q: body does not match (%v)", tc.accept, err)
+		}
+
+		etags[w.Header().Get("ETag")] = true
+
+		// Revalidating returns nothing
+		r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+		w = httptest.NewRecorder()
+		s.handlerStatic(w, r)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("Accept-Encoding %q: expected 304, got %d", tc.accept, w.Code)
+		}
+	}
+
+	if len(etags) != 3 {
+		t.Errorf("Expected a different ETag for each encoding, got %v", etags)
+	}
+}
+
+func Test_PosterCaching(t *testing.T) {
+	s := newTestServer(t)
+
+	pngBuf := &bytes.Buffer{}
+	if err := png.Encode(pngBuf, testImage(300, 450)); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := savePoster(s.posters, pngBuf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{name, common.PosterThumb(name)} {
+		w := httptest.NewRecorder()
+		s.handlerPoster(w, httptest.NewRequest("GET", "/posters/"+file, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Cache-Control"), "immutable") || w.Header().Get("ETag") == "" {
+			t.Fatalf("Unexpected response for %q: %d %v", file, w.Code, w.Header())
+		}
+
+		r := httptest.NewRequest("GET", "/posters/"+file, nil)
+		r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+		w = httptest.NewRecorder()
+		s.handlerPoster(w, r)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for %q, got %d", file, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.handlerPoster(w, httptest.NewRequest("GET", "/posters/0123456789abcdef0123456789abcdef.jpg", nil))
+	if w.Code != http.StatusNotFound || w.Header().Get("Cache-Control") != "" {
+		t.Errorf("Missing poster: unexpected response %d %v", w.Code, w.Header())
+	}
+}
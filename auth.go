@@ -129,7 +129,12 @@ func (s *Server) handlerAuth(w http.ResponseWriter, r *http.Request) {
 						return
 					}
 
-					if err = s.login(user, w, r); err != nil {
+					if err = s.data.DeleteUserSessions(user.Id); err != nil {
+						s.l.Error("Unable to delete sessions: %v", err)
+					}
+
+					redirect, err := s.startLogin(user, w, r)
+					if err != nil {
 						s.l.Error("Unable to login to session:", err)
 						s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
 						return
@@ -137,7 +142,7 @@ func (s *Server) handlerAuth(w http.ResponseWriter, r *http.Request) {
 
 					s.l.Info("User %q has reset their password", user.Name)
 					delete(s.urlKeys, key)
-					http.Redirect(w, r, "/", http.StatusSeeOther)
+					http.Redirect(w, r, redirect, http.StatusSeeOther)
 					return
 				}
 			} // if POST
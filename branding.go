@@ -0,0 +1,333 @@
+package moviepoll
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/storage"
+)
+
+const (
+	brandingDir = "branding"
+
+	brandingMaxBytes      int64 = 1 << 20 // 1 MB
+	brandingMaxNameLength int   = 50
+)
+
+// File extensions of the image types accepted for logos and favicons, as
+// returned by http.DetectContentType.  SVG is left out on purpose, it can
+// contain scripts.
+var brandingTypes = map[string]string{
+	"image/png":    ".png",
+	"image/jpeg":   ".jpg",
+	"image/gif":    ".gif",
+	"image/webp":   ".webp",
+	"image/x-icon": ".ico",
+}
+
+var re_brandingColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type brandingLink struct {
+	Name string
+	Url  string
+}
+
+// branding is the look of the site, included in every page.
+type branding struct {
+	SiteName   string
+	LogoUrl    string
+	FaviconUrl string
+
+	AccentColor     string
+	HeaderColor     string
+	BackgroundColor string
+	TextColor       string
+
+	// Admins can only save CSS without HTML in it, see checkCustomCss
+	CustomCss   template.CSS
+	FooterLinks []brandingLink
+}
+
+type dataAdminBranding struct {
+	dataPageBase
+
+	ValCustomCss   string
+	ValFooterLinks string
+
+	ErrorMessage   []string
+	SuccessMessage string
+}
+
+// parseFooterLinks reads one link per line, the name followed by the URL.
+// Lines that don't end in a http(s) URL are returned as problems.
+func parseFooterLinks(text string) ([]brandingLink, []string) {
+	links := []brandingLink{}
+	problems := []string{}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		idx := strings.LastIndexAny(line, " \t")
+		if idx == -1 {
+			problems = append(problems, fmt.Sprintf("Footer link %q needs a name and a URL", line))
+			continue
+		}
+
+		name := strings.TrimSpace(line[:idx])
+		link := line[idx+1:]
+
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("Footer link %q is not a http or https URL", link))
+			continue
+		}
+
+		links = append(links, brandingLink{Name: name, Url: link})
+	}
+
+	return links, problems
+}
+
+// checkCustomCss refuses CSS that could end the style element.
+func checkCustomCss(css string) error {
+	if strings.Contains(css, "<") {
+		return fmt.Errorf("Custom CSS cannot contain \"<\"")
+	}
+	return nil
+}
+
+// getBranding reads the branding from the config.  Invalid values fall back
+// to the defaults.
+func (s *Server) getBranding() branding {
+	b := branding{
+		SiteName:        DefaultSiteName,
+		AccentColor:     DefaultAccentColor,
+		HeaderColor:     DefaultHeaderColor,
+		BackgroundColor: DefaultBackgroundColor,
+		TextColor:       DefaultTextColor,
+	}
+
+	if name, err := s.data.GetCfgString(ConfigSiteName, DefaultSiteName); err == nil && name != "" {
+		b.SiteName = name
+	}
+
+	colors := []struct {
+		key string
+		val *string
+	}{
+		{ConfigAccentColor, &b.AccentColor},
+		{ConfigHeaderColor, &b.HeaderColor},
+		{ConfigBackgroundColor, &b.BackgroundColor},
+		{ConfigTextColor, &b.TextColor},
+	}
+
+	for _, c := range colors {
+		if color, err := s.data.GetCfgString(c.key, *c.val); err == nil && re_brandingColor.MatchString(color) {
+			*c.val = color
+		}
+	}
+
+	if logo, err := s.data.GetCfgString(ConfigSiteLogo, ""); err == nil && logo != "" {
+		b.LogoUrl = "/branding/" + logo
+	}
+
+	if favicon, err := s.data.GetCfgString(ConfigSiteFavicon, ""); err == nil && favicon != "" {
+		b.FaviconUrl = "/branding/" + favicon
+	}
+
+	if css, err := s.data.GetCfgString(ConfigCustomCss, ""); err == nil && checkCustomCss(css) == nil {
+		b.CustomCss = template.CSS(css)
+	}
+
+	if text, err := s.data.GetCfgString(ConfigFooterLinks, ""); err == nil {
+		b.FooterLinks, _ = parseFooterLinks(text)
+	}
+
+	return b
+}
+
+// siteName returns the configured name of the site.
+func (s *Server) siteName() string {
+	name, err := s.data.GetCfgString(ConfigSiteName, DefaultSiteName)
+	if err != nil || name == "" {
+		return DefaultSiteName
+	}
+	return name
+}
+
+// saveBrandingFile saves an uploaded logo or favicon.  Files are named after
+// their content so they can be cached forever.  The name of the file is
+// returned.
+func saveBrandingFile(store storage.Storage, r *http.Request, field string) (string, error) {
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		return "", fmt.Errorf("Unable to retrive the file")
+	}
+	defer file.Close()
+
+	raw, err := readUpload(file, brandingMaxBytes)
+	if err != nil {
+		return "", err
+	}
+
+	ext, ok := brandingTypes[http.DetectContentType(raw)]
+	if !ok {
+		return "", fmt.Errorf("Unsupported image format.  Use a PNG, JPEG, GIF, WebP or ICO image.")
+	}
+
+	sum := sha256.Sum256(raw)
+	name := hex.EncodeToString(sum[:16]) + ext
+
+	if err = store.Put(name, raw, http.DetectContentType(raw)); err != nil {
+		return "", fmt.Errorf("Unable to save %s: %v", field, err)
+	}
+	return name, nil
+}
+
+// handlerBranding serves uploaded logos and favicons.
+func (s *Server) handlerBranding(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+
+	rc, err := s.brandingFiles.Get(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Cache-Control", cacheImmutable)
+	serveReader(w, r, name, rc)
+}
+
+func (s *Server) handlerAdminBranding(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminRights(w, r) {
+		return
+	}
+
+	data := dataAdminBranding{}
+
+	if r.Method == "POST" {
+		if err := r.ParseMultipartForm(brandingMaxBytes); err != nil && err != http.ErrNotMultipart {
+			s.doError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Unable to parse form: %v", err),
+				w, r)
+			return
+		}
+
+		if err := s.adminSaveBranding(r, &data); err != nil {
+			s.l.Error(err.Error())
+			s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+			return
+		}
+	}
+
+	// Built after saving so the page shows the new look
+	data.dataPageBase = s.newPageBase("Admin - Branding", w, r)
+
+	if len(data.ErrorMessage) == 0 {
+		data.ValCustomCss = string(data.Branding.CustomCss)
+		for _, link := range data.Branding.FooterLinks {
+			data.ValFooterLinks += link.Name + " " + link.Url + "\n"
+		}
+	}
+
+	if err := s.executeTemplate(w, "adminBranding", data); err != nil {
+		s.l.Error("Error rendering template: %v", err)
+	}
+}
+
+// adminSaveBranding checks the branding form and saves it.  Nothing is
+// saved if there are problems with the form, they end up in
+// data.ErrorMessage.
+func (s *Server) adminSaveBranding(r *http.Request, data *dataAdminBranding) error {
+	data.ValCustomCss = strings.ReplaceAll(r.FormValue("CustomCss"), "\r", "")
+	data.ValFooterLinks = strings.ReplaceAll(r.FormValue("FooterLinks"), "\r", "")
+
+	name := strings.TrimSpace(r.FormValue("SiteName"))
+	if name == "" {
+		data.ErrorMessage = append(data.ErrorMessage, "Missing site name")
+	} else if common.GetStringLength(name) > brandingMaxNameLength {
+		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Site name too long! Max Length: %d characters", brandingMaxNameLength))
+	}
+
+	colors := map[string]string{}
+	for _, key := range []string{ConfigAccentColor, ConfigHeaderColor, ConfigBackgroundColor, ConfigTextColor} {
+		colors[key] = strings.TrimSpace(r.FormValue(key))
+		if !re_brandingColor.MatchString(colors[key]) {
+			data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("%s is not a color like #2266aa", key))
+		}
+	}
+
+	if err := checkCustomCss(data.ValCustomCss); err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, err.Error())
+	}
+
+	_, problems := parseFooterLinks(data.ValFooterLinks)
+	data.ErrorMessage = append(data.ErrorMessage, problems...)
+
+	// Upload the files last, there's no point if the rest of the form is
+	// wrong.
+	files := map[string]string{}
+	for key, field := range map[string]string{ConfigSiteLogo: "LogoFile", ConfigSiteFavicon: "FaviconFile"} {
+		if len(data.ErrorMessage) > 0 {
+			break
+		}
+
+		if r.FormValue("Remove"+field) != "" {
+			files[key] = ""
+			continue
+		}
+
+		file, _, _ := r.FormFile(field)
+		if file == nil {
+			continue
+		}
+		file.Close()
+
+		name, err := saveBrandingFile(s.brandingFiles, r, field)
+		if err != nil {
+			data.ErrorMessage = append(data.ErrorMessage, err.Error())
+			continue
+		}
+		files[key] = name
+	}
+
+	if len(data.ErrorMessage) > 0 {
+		return nil
+	}
+
+	values := map[string]string{
+		ConfigSiteName:    name,
+		ConfigCustomCss:   data.ValCustomCss,
+		ConfigFooterLinks: data.ValFooterLinks,
+	}
+
+	for key, val := range colors {
+		values[key] = strings.ToLower(val)
+	}
+
+	for key, val := range files {
+		values[key] = val
+	}
+
+	for key, val := range values {
+		if err := s.data.SetCfgString(key, val); err != nil {
+			return fmt.Errorf("Unable to save %q: %v", key, err)
+		}
+	}
+
+	data.SuccessMessage = "Branding saved"
+	return nil
+}
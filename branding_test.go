@@ -0,0 +1,134 @@
+package moviepoll
+
+import (
+	"bytes"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+func postBranding(t *testing.T, s *Server, fields map[string]string, logo []byte, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for key, val := range fields {
+		mw.WriteField(key, val)
+	}
+
+	if logo != nil {
+		fw, err := mw.CreateFormFile("LogoFile", "logo.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(logo)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/admin/branding", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	addCookies(req, cookies)
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", rec.Code)
+	}
+	return rec
+}
+
+func Test_AdminBranding(t *testing.T) {
+	s := newTestServer(t)
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+	cookies := loginCookies(t, s, admin)
+
+	fields := map[string]string{
+		"CsrfToken":       csrfTokenFor(t, s, cookies),
+		"SiteName":        "Friday Films",
+		"AccentColor":     "#AA2266",
+		"HeaderColor":     "#101010",
+		"BackgroundColor": "#000000",
+		"TextColor":       "#ffffff",
+		"CustomCss":       ".voteName { font-size: 2em; }",
+		"FooterLinks":     "Discord https://discord.example.com\nRules page https://example.com/rules",
+	}
+
+	logo := &bytes.Buffer{}
+	if err := png.Encode(logo, testImage(64, 64)); err != nil {
+		t.Fatal(err)
+	}
+
+	body := postBranding(t, s, fields, logo.Bytes(), cookies).Body.String()
+	if !strings.Contains(body, "Branding saved") {
+		t.Fatalf("Branding was not saved:\n%s", body)
+	}
+
+	b := s.getBranding()
+	if b.SiteName != "Friday Films" || b.AccentColor != "#aa2266" || len(b.FooterLinks) != 2 || b.LogoUrl == "" {
+		t.Fatalf("Unexpected branding: %#v", b)
+	}
+
+	if b.FooterLinks[1].Name != "Rules page" {
+		t.Errorf("Unexpected footer link name %q", b.FooterLinks[1].Name)
+	}
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	page := rec.Body.String()
+
+	for _, expected := range []string{
+		"<title>Friday Films - ",
+		"--accent: #aa2266;",
+		".voteName { font-size: 2em; }",
+		`<a href="https://discord.example.com">Discord</a>`,
+		`src="` + b.LogoUrl + `"`,
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Page is missing %q", expected)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest("GET", b.LogoUrl, nil))
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), logo.Bytes()) {
+		t.Errorf("Unable to get the logo: %d", rec.Code)
+	}
+
+	// Nothing is saved if part of the form is wrong
+	fields["SiteName"] = "Changed"
+	fields["AccentColor"] = "red; background: url(evil)"
+	fields["CustomCss"] = "</style><script>alert(1)</script>"
+	fields["FooterLinks"] = "Bad javascript:alert(1)"
+
+	body = postBranding(t, s, fields, nil, cookies).Body.String()
+	for _, expected := range []string{"AccentColor is not a color", "Custom CSS cannot contain", "is not a http or https URL"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Missing error %q", expected)
+		}
+	}
+
+	if name := s.getBranding().SiteName; name != "Friday Films" {
+		t.Errorf("Site name was changed to %q", name)
+	}
+
+	// Removing the logo
+	fields = map[string]string{
+		"CsrfToken":       fields["CsrfToken"],
+		"SiteName":        "Friday Films",
+		"AccentColor":     DefaultAccentColor,
+		"HeaderColor":     DefaultHeaderColor,
+		"BackgroundColor": DefaultBackgroundColor,
+		"TextColor":       DefaultTextColor,
+		"RemoveLogoFile":  "on",
+	}
+	postBranding(t, s, fields, nil, cookies)
+
+	if b = s.getBranding(); b.LogoUrl != "" || b.CustomCss != "" || len(b.FooterLinks) != 0 {
+		t.Errorf("Unexpected branding after reset: %#v", b)
+	}
+}
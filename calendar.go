@@ -0,0 +1,302 @@
+package moviepoll
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// Calendar feeds in the iCalendar format (RFC 5545).  The public feed has
+// the end of every cycle and the showings of the movies watched in them.
+// Private feeds only have the movies the user voted for or RSVPed to.
+
+const (
+	// Movies don't have a reliable duration, so every watch event is this
+	// long.
+	calendarWatchLength = 2 * time.Hour
+
+	// Number of finished cycles in the feeds
+	calendarPastCycles = 10
+
+	calendarTokenLength = 32
+
+	// Lines longer than this are folded
+	calendarLineLength = 75
+)
+
+type calendarEvent struct {
+	Uid   string
+	Start time.Time
+	End   time.Time // zero for events without a duration
+
+	Summary     string
+	Description string
+	Location    string
+	Url         string
+}
+
+// calendarUrl returns the absolute URL of a feed.  The host address from the
+// config is used if it's set, otherwise the host of the request.
+func (s *Server) calendarUrl(r *http.Request, path string) string {
+	if u := s.siteUrl(path); u != "" {
+		return u
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// privateCalendarPath returns the path of the user's private feed, or an
+// empty string if they don't have one.
+func privateCalendarPath(user *common.User) string {
+	if user == nil || user.CalendarToken == "" {
+		return ""
+	}
+	return "/calendar/" + user.CalendarToken + ".ics"
+}
+
+// handlerCalendar serves the public feed on /calendar.ics and private feeds
+// on /calendar/<token>.ics
+func (s *Server) handlerCalendar(w http.ResponseWriter, r *http.Request) {
+	var user *common.User
+
+	if r.URL.Path != "/calendar.ics" {
+		token := strings.TrimPrefix(r.URL.Path, "/calendar/")
+		if !strings.HasSuffix(token, ".ics") || strings.Contains(token, "/") {
+			s.doError(http.StatusNotFound, "Calendar not found", w, r)
+			return
+		}
+		token = strings.TrimSuffix(token, ".ics")
+
+		var err error
+		if token != "" {
+			user, err = s.data.GetUserByCalendarToken(token)
+		}
+
+		if err != nil || user == nil {
+			s.doError(http.StatusNotFound, "Calendar not found", w, r)
+			return
+		}
+	}
+
+	events, err := s.calendarEvents(user)
+	if err != nil {
+		s.l.Error("Unable to get calendar events: %v", err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", cacheRevalidate)
+	if err = writeCalendar(w, s.siteName(), events, time.Now()); err != nil {
+		s.l.Error("Unable to write calendar: %v", err)
+	}
+}
+
+// calendarEvents returns the events of a feed.  Only the movies the user
+// voted for or RSVPed to are included if user isn't nil.  Watched movies
+// without a scheduled showing are at the end of their cycle.
+func (s *Server) calendarEvents(user *common.User) ([]calendarEvent, error) {
+	cycles, err := s.data.GetPastCycles(0, calendarPastCycles)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get past cycles: %v", err)
+	}
+
+	current, err := s.data.GetCurrentCycle()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get current cycle: %v", err)
+	}
+
+	if current != nil {
+		cycles = append([]*common.Cycle{current}, cycles...)
+	}
+
+	var voted map[int]bool
+	if user != nil {
+		votes, err := s.data.GetUserVotes(user.Id)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get votes of user %d: %v", user.Id, err)
+		}
+
+		voted = map[int]bool{}
+		for _, movie := range votes {
+			voted[movie.Id] = true
+		}
+	}
+
+	domain := "moviepolls"
+	if u, err := url.Parse(s.siteUrl("/")); err == nil && u.Hostname() != "" {
+		domain = u.Hostname()
+	}
+
+	events := []calendarEvent{}
+	for _, cycle := range cycles {
+		if cycle.PlannedEnd != nil {
+			events = append(events, calendarEvent{
+				Uid:     fmt.Sprintf("cycle-%d-end@%s", cycle.Id, domain),
+				Start:   *cycle.PlannedEnd,
+				Summary: "Voting closes",
+				Url:     s.siteUrl("/"),
+			})
+		}
+
+		if cycle.Ended == nil {
+			continue
+		}
+
+		showings, err := s.data.GetCycleShowings(cycle.Id)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get showings of cycle %d: %v", cycle.Id, err)
+		}
+
+		for _, movie := range cycle.Watched {
+			if movie == nil {
+				continue
+			}
+
+			movieShowings := []*common.Showing{}
+			attending := false
+			for _, showing := range showings {
+				if showing.MovieId == movie.Id {
+					movieShowings = append(movieShowings, showing)
+					attending = attending || (user != nil && showing.Attending(user.Id))
+				}
+			}
+
+			if voted != nil && !voted[movie.Id] && !attending {
+				continue
+			}
+
+			description := []string{}
+			if movie.Description != "" {
+				description = append(description, movie.Description)
+			}
+			for _, link := range movie.Links {
+				description = append(description, link.Url)
+			}
+
+			movieUrl := s.siteUrl(fmt.Sprintf("/movie/%d", movie.Id))
+			if movieUrl != "" {
+				description = append(description, movieUrl)
+			}
+
+			ev := calendarEvent{
+				Uid:         fmt.Sprintf("cycle-%d-movie-%d@%s", cycle.Id, movie.Id, domain),
+				Start:       *cycle.Ended,
+				End:         cycle.Ended.Add(calendarWatchLength),
+				Summary:     movie.Name,
+				Description: strings.Join(description, "\n\n"),
+				Url:         movieUrl,
+			}
+
+			if len(movieShowings) == 0 {
+				events = append(events, ev)
+				continue
+			}
+
+			for _, showing := range movieShowings {
+				ev.Uid = fmt.Sprintf("showing-%d@%s", showing.Id, domain)
+				ev.Start = showing.Start
+				ev.End = showing.Start.Add(calendarWatchLength)
+				ev.Location = showing.Location
+				events = append(events, ev)
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// writeCalendar writes the events as an iCalendar file.  Now is the time
+// stamp of every event.
+func writeCalendar(w io.Writer, name string, events []calendarEvent, now time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//MoviePolls//MoviePolls//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + escapeCalendarText(name),
+	}
+
+	for _, ev := range events {
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+ev.Uid,
+			"DTSTAMP:"+calendarTime(now),
+			"DTSTART:"+calendarTime(ev.Start),
+		)
+
+		if !ev.End.IsZero() {
+			lines = append(lines, "DTEND:"+calendarTime(ev.End))
+		}
+
+		lines = append(lines, "SUMMARY:"+escapeCalendarText(ev.Summary))
+		if ev.Description != "" {
+			lines = append(lines, "DESCRIPTION:"+escapeCalendarText(ev.Description))
+		}
+		if ev.Location != "" {
+			lines = append(lines, "LOCATION:"+escapeCalendarText(ev.Location))
+		}
+		if ev.Url != "" {
+			lines = append(lines, "URL:"+ev.Url)
+		}
+
+		lines = append(lines, "END:VEVENT")
+	}
+
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, foldCalendarLine(line)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func calendarTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+var calendarEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+	"\r", "",
+)
+
+// escapeCalendarText escapes a TEXT value
+func escapeCalendarText(text string) string {
+	return calendarEscaper.Replace(text)
+}
+
+// foldCalendarLine splits lines longer than 75 bytes.  Continuation lines
+// start with a space.  Multi-byte characters are never split.
+func foldCalendarLine(line string) string {
+	sb := strings.Builder{}
+	length := 0
+
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > calendarLineLength {
+			sb.WriteString("\r\n ")
+			length = 1
+		}
+
+		sb.WriteRune(r)
+		length += size
+	}
+
+	return sb.String()
+}
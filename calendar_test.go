@@ -0,0 +1,181 @@
+package moviepoll
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+func Test_WriteCalendar(t *testing.T) {
+	start := time.Date(2021, time.March, 5, 20, 0, 0, 0, time.FixedZone("CET", 3600))
+	events := []calendarEvent{
+		{
+			Uid:         "cycle-1-movie-2@example.com",
+			Start:       start,
+			End:         start.Add(calendarWatchLength),
+			Summary:     "Movie; with, special\\characters",
+			Description: "First line\nSecond line, with a much longer text that has to be folded somewhere in the middle – with ümlauts",
+			Url:         "https://example.com/movie/2",
+		},
+		{
+			Uid:     "cycle-1-end@example.com",
+			Start:   start,
+			Summary: "Voting closes",
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeCalendar(buf, "Friday Films", events, start); err != nil {
+		t.Fatal(err)
+	}
+	cal := buf.String()
+
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"X-WR-CALNAME:Friday Films\r\n",
+		"DTSTART:20210305T190000Z\r\nDTEND:20210305T210000Z\r\n",
+		`SUMMARY:Movie\; with\, special\\characters` + "\r\n",
+		`DESCRIPTION:First line\nSecond line\, with a much longer text that has to b` + "\r\n e folded",
+		"URL:https://example.com/movie/2\r\n",
+		"DTSTART:20210305T190000Z\r\nSUMMARY:Voting closes\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(cal, expected) {
+			t.Errorf("Calendar is missing %q:\n%s", expected, cal)
+		}
+	}
+
+	for _, line := range strings.Split(cal, "\r\n") {
+		if len(line) > calendarLineLength {
+			t.Errorf("Line is too long: %q", line)
+		}
+	}
+
+	if strings.Count(cal, "BEGIN:VEVENT") != 2 {
+		t.Errorf("Expected two events")
+	}
+}
+
+func Test_CalendarFeeds(t *testing.T) {
+	s := newTestServer(t)
+	s.data.SetCfgString(ConfigHostAddress, "https://movies.example.com")
+
+	end := time.Date(2021, time.March, 5, 19, 0, 0, 0, time.UTC)
+	cycleId, err := s.data.AddCycle(&end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := addTestUser(t, s, "viewer", common.PRIV_USER)
+	ids := []int{}
+	for _, name := range []string{"Voted Movie", "Other Movie"} {
+		id, err := s.data.AddMovie(&common.Movie{Name: name, Description: name + " description", AddedBy: user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err = s.data.AddVote(user.Id, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// End the cycle with both movies watched
+	cycle, err := s.data.GetCycle(cycleId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		movie, err := s.data.GetMovie(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		movie.CycleWatched = cycle
+		if err = s.data.UpdateMovie(movie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watched := end.Add(time.Hour)
+	cycle.Ended = &watched
+	if err = s.data.UpdateCycle(cycle); err != nil {
+		t.Fatal(err)
+	}
+
+	getCalendar := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	code, cal := getCalendar("/calendar.ics")
+	if code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", code)
+	}
+
+	for _, expected := range []string{
+		"SUMMARY:Voting closes\r\n",
+		"SUMMARY:Voted Movie\r\n",
+		"SUMMARY:Other Movie\r\n",
+		"DTSTART:20210305T200000Z\r\nDTEND:20210305T220000Z\r\n",
+		"URL:https://movies.example.com/movie/",
+		"@movies.example.com\r\n",
+	} {
+		if !strings.Contains(cal, expected) {
+			t.Errorf("Public calendar is missing %q:\n%s", expected, cal)
+		}
+	}
+
+	// Private feed
+	cookies := loginCookies(t, s, user)
+	form := url.Values{
+		"CsrfToken": {csrfTokenFor(t, s, cookies)},
+		"Form":      {"CalendarNew"},
+	}
+	postForm(s, "/user", form, cookies)
+
+	user, err = s.data.GetUser(user.Id)
+	if err != nil || user.CalendarToken == "" {
+		t.Fatalf("No calendar token was created: %v", err)
+	}
+	private := privateCalendarPath(user)
+
+	code, cal = getCalendar(private)
+	if code != http.StatusOK {
+		t.Fatalf("Unexpected status %d for the private calendar", code)
+	}
+
+	if !strings.Contains(cal, "SUMMARY:Voted Movie\r\n") || strings.Contains(cal, "Other Movie") {
+		t.Errorf("Private calendar doesn't have only the voted movie:\n%s", cal)
+	}
+
+	// Old links stop working after removing them
+	form.Set("Form", "CalendarRemove")
+	postForm(s, "/user", form, cookies)
+
+	for _, path := range []string{private, "/calendar/.ics", "/calendar/nope.ics", "/calendar/"} {
+		if code, _ = getCalendar(path); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %q, got %d", path, code)
+		}
+	}
+
+	// Deleting the user removes the link too
+	form.Set("Form", "CalendarNew")
+	postForm(s, "/user", form, cookies)
+
+	if user, err = s.data.GetUser(user.Id); err != nil {
+		t.Fatal(err)
+	}
+	private = privateCalendarPath(user)
+
+	deleteTestUser(t, s, user)
+	if code, _ = getCalendar(private); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for the calendar of a deleted user, got %d", code)
+	}
+}
@@ -13,12 +13,19 @@ func main() {
 	var logFile string
 	var logLevel string
 	var debug bool
+	var overrides string
 	flag.StringVar(&logFile, "logfile", "", "File to write logs")
 	flag.StringVar(&logLevel, "loglevel", "debug", "Log verbosity")
 	flag.BoolVar(&debug, "debug", false, "Enable debug code")
+	flag.StringVar(&overrides, "overrides", "", "Directory with templates and static files replacing the built in ones")
 	flag.Parse()
 
-	s, err := moviepoll.NewServer(moviepoll.Options{Debug: debug, LogLevel: common.LogLevel(logLevel), LogFile: logFile})
+	s, err := moviepoll.NewServer(moviepoll.Options{
+		Debug:     debug,
+		LogLevel:  common.LogLevel(logLevel),
+		LogFile:   logFile,
+		Overrides: overrides,
+	})
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
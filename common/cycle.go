@@ -16,20 +16,16 @@ type Cycle struct {
 	Watched []*Movie
 }
 
-func (c Cycle) PlannedEndString() string {
-	if c.PlannedEnd == nil {
-		return ""
+func (c Cycle) String() string {
+	plannedEnd := ""
+	if c.PlannedEnd != nil {
+		plannedEnd = c.PlannedEnd.Format("Mon Jan 2")
 	}
-	return c.PlannedEnd.Format("Mon Jan 2")
-}
 
-func (c Cycle) EndedString() string {
-	if c.Ended == nil {
-		return ""
+	ended := ""
+	if c.Ended != nil {
+		ended = c.Ended.Format("Mon Jan 2, 2006")
 	}
-	return c.Ended.Format("Mon Jan 2, 2006")
-}
 
-func (c Cycle) String() string {
-	return fmt.Sprintf("Cycle{Id:%d PlannedEnd:%s Ended: %s}", c.Id, c.PlannedEndString(), c.EndedString())
+	return fmt.Sprintf("Cycle{Id:%d PlannedEnd:%s Ended: %s}", c.Id, plannedEnd, ended)
 }
@@ -2,8 +2,10 @@ package common
 
 import (
 	"fmt"
+	"regexp"
 	//"time"
 	"sort"
+	"strings"
 )
 
 type Movie struct {
@@ -40,6 +42,30 @@ func (m Movie) UserVoted(userId int) bool {
 	return false
 }
 
+// Posters are stored under a hash of the image.  Older posters, and the
+// placeholder, don't have a thumbnail.
+var re_hashedPoster = regexp.MustCompile(`^[0-9a-f]{32}\.jpg$`)
+
+// PosterThumb returns the file name of the poster's thumbnail, or the poster
+// itself if it doesn't have one.
+func PosterThumb(poster string) string {
+	if !re_hashedPoster.MatchString(poster) {
+		return poster
+	}
+	return strings.TrimSuffix(poster, ".jpg") + "-thumb.jpg"
+}
+
+// StoredPoster returns true for posters and thumbnails saved through the
+// poster storage.  Older posters and the placeholder live in the posters
+// directory.
+func StoredPoster(poster string) bool {
+	return re_hashedPoster.MatchString(strings.Replace(poster, "-thumb.jpg", ".jpg", 1))
+}
+
+func (m Movie) PosterThumb() string {
+	return PosterThumb(m.Poster)
+}
+
 func (m Movie) String() string {
 	votes := []string{}
 	for _, v := range m.Votes {
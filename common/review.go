@@ -0,0 +1,45 @@
+package common
+
+import "time"
+
+// Range of the ratings users give watched movies.  Not to be confused with
+// Movie.Rating, which comes from IMDb/MAL.
+const (
+	ReviewMinRating int = 1
+	ReviewMaxRating int = 10
+)
+
+// Review is a user's rating of a watched movie, with an optional text.
+// Every user has at most one review per movie.
+type Review struct {
+	Id      int
+	MovieId int
+	UserId  int
+
+	Rating int
+	Text   string
+
+	Created time.Time
+	Updated time.Time
+}
+
+// RatingSummary is the community rating of a movie.
+type RatingSummary struct {
+	Count   int
+	Average float64
+}
+
+// SummarizeReviews returns the average rating of the reviews.
+func SummarizeReviews(reviews []*Review) RatingSummary {
+	sum := RatingSummary{Count: len(reviews)}
+	if sum.Count == 0 {
+		return sum
+	}
+
+	total := 0
+	for _, review := range reviews {
+		total += review.Rating
+	}
+	sum.Average = float64(total) / float64(sum.Count)
+	return sum
+}
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"strings"
+	"time"
+)
+
+// MovieRevision records an edit of a movie by its submitter or an admin.
+type MovieRevision struct {
+	Id      int
+	MovieId int
+	UserId  int // who made the edit
+	Time    time.Time
+	Changes []MovieChange
+}
+
+type MovieChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// DiffMovies returns the fields that differ between two versions of a
+// movie.  Links and tags are compared as a whole.
+func DiffMovies(old, new *Movie) []MovieChange {
+	changes := []MovieChange{}
+	add := func(field, o, n string) {
+		if o != n {
+			changes = append(changes, MovieChange{Field: field, Old: o, New: n})
+		}
+	}
+
+	add("Name", old.Name, new.Name)
+	add("Description", old.Description, new.Description)
+	add("Remarks", old.Remarks, new.Remarks)
+	add("Links", movieLinkText(old), movieLinkText(new))
+	add("Tags", movieTagText(old), movieTagText(new))
+	add("Poster", old.Poster, new.Poster)
+
+	return changes
+}
+
+func movieLinkText(movie *Movie) string {
+	links := []string{}
+	for _, link := range movie.Links {
+		links = append(links, link.Url)
+	}
+	return strings.Join(links, "\n")
+}
+
+func movieTagText(movie *Movie) string {
+	tags := []string{}
+	for _, tag := range movie.Tags {
+		tags = append(tags, tag.Name)
+	}
+	return strings.Join(tags, ", ")
+}
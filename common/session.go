@@ -0,0 +1,104 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Session is a login on a single device.  The ID is stored in the session
+// cookie and the record is kept on the server so sessions can be listed and
+// revoked.
+type Session struct {
+	Id       string
+	UserId   int
+	Created  time.Time
+	LastSeen time.Time
+
+	// Client address and user agent, as last seen
+	Address   string
+	UserAgent string
+}
+
+func NewSession(userId int, address, userAgent string) (*Session, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("Unable to generate session ID: %v", err)
+	}
+
+	now := time.Now()
+	return &Session{
+		Id:        hex.EncodeToString(raw),
+		UserId:    userId,
+		Created:   now,
+		LastSeen:  now,
+		Address:   address,
+		UserAgent: userAgent,
+	}, nil
+}
+
+// Device returns a short description of the browser and OS from the user
+// agent, eg "Firefox on Linux".
+func (s Session) Device() string {
+	ua := s.UserAgent
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		// Order matters, most browsers claim to be Safari and Chrome too.
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	os := ""
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			os = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	}
+
+	if len(ua) > 40 {
+		return ua[:40] + "..."
+	}
+	return ua
+}
+
+func (s Session) String() string {
+	return fmt.Sprintf(
+		"Session{UserId:%d Created:%s LastSeen:%s Address:%q}",
+		s.UserId,
+		s.Created,
+		s.LastSeen,
+		s.Address,
+	)
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Showing is a scheduled watch night of a movie selected at the end of a
+// cycle.
+type Showing struct {
+	Id      int
+	MovieId int
+	CycleId int
+
+	Start time.Time
+	// Where to watch, eg an address or a link to the stream.
+	Location string
+
+	// IDs of the users that RSVPed
+	Attendees []int
+}
+
+// Attending returns true if the user RSVPed to the showing.
+func (s Showing) Attending(userId int) bool {
+	for _, id := range s.Attendees {
+		if id == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// LocationIsUrl returns true if the location is a http or https link that
+// can be shown as one.
+func (s Showing) LocationIsUrl() bool {
+	u, err := url.Parse(strings.TrimSpace(s.Location))
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
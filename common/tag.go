@@ -1,6 +1,28 @@
 package common
 
+import "strings"
+
 type Tag struct {
 	Id   int
 	Name string
+
+	// Other names that map onto this tag, eg "Sci-Fi" for "Science
+	// Fiction".
+	Aliases []string
+}
+
+// Matches returns true if name is the tag's name or one of its aliases,
+// ignoring case and surrounding spaces.
+func (t *Tag) Matches(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if strings.ToLower(t.Name) == name {
+		return true
+	}
+
+	for _, alias := range t.Aliases {
+		if strings.ToLower(alias) == name {
+			return true
+		}
+	}
+	return false
 }
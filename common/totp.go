@@ -0,0 +1,104 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP as described in RFC 6238, using the defaults every authenticator app
+// understands: HMAC-SHA1, six digits, and a 30 second step.
+const (
+	TotpDigits int   = 6
+	TotpPeriod int64 = 30
+
+	// Number of steps before and after the current one that are accepted to
+	// allow for clock drift.
+	TotpSkew int64 = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTotpSecret returns a new random base32 encoded secret.
+func NewTotpSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("Unable to generate TOTP secret: %v", err)
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TotpUrl returns the otpauth:// URL used to provision authenticator apps.
+func TotpUrl(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("digits", fmt.Sprintf("%d", TotpDigits))
+	params.Set("period", fmt.Sprintf("%d", TotpPeriod))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TotpCode returns the code for the given secret at the given step.
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("Invalid TOTP secret: %v", err)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0F
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7FFFFFFF
+
+	mod := uint32(1)
+	for i := 0; i < TotpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TotpDigits, value%mod), nil
+}
+
+// TotpStep returns the step for the given time.
+func TotpStep(t time.Time) int64 {
+	return t.Unix() / TotpPeriod
+}
+
+// ValidateTotp checks a code against the secret at the given time.  Steps at
+// or before lastStep are rejected so a code can't be used twice.  Returns the
+// matched step.
+func ValidateTotp(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != TotpDigits {
+		return 0, false
+	}
+
+	current := TotpStep(t)
+	for step := current - TotpSkew; step <= current+TotpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := TotpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
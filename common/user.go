@@ -20,6 +20,14 @@ type User struct {
 	OAuthToken string
 	Email      string // nil if user didn't opt-in.
 
+	// Subject identifier of a linked OpenID Connect account.  Empty if the
+	// account isn't linked.
+	OidcSubject string
+	// Privilege was raised by the OIDC group claims from LocalPrivilege.
+	// Leaving the groups never lowers it below LocalPrivilege.
+	OidcPrivilege  bool
+	LocalPrivilege PrivilegeLevel
+
 	NotifyCycleEnd      bool
 	NotifyVoteSelection bool
 	Privilege           PrivilegeLevel
@@ -29,6 +37,32 @@ type User struct {
 	// Does this user ignore rate limit? (default true for mod/admin)
 	RateLimitOverride bool
 	LastMovieAdd      time.Time
+	// IDs of the cycles the user withdrew suggestions in, once per
+	// suggestion.  Withdrawn suggestions still count towards the limit.
+	WithdrawnIn []int
+
+	// Base32 encoded TOTP secret.  Empty if two-factor authentication isn't
+	// enabled.
+	TotpSecret string
+	// Last TOTP step that was used.  Codes can't be reused.
+	TotpLastStep int64
+	// Hashes of unused recovery codes.
+	RecoveryCodes []string
+
+	// Lowercase Twitch login linked through the chat bot.  Empty if the
+	// account isn't linked.
+	TwitchName string
+
+	// Language tag of the web interface, eg "de".  Empty to use the
+	// browser's language.
+	Locale string
+	// IANA time zone name, eg "Europe/Berlin".  Empty to use the server's
+	// time zone.
+	TimeZone string
+
+	// Secret part of the URL of the user's private calendar feed.  Empty if
+	// the user doesn't have one.
+	CalendarToken string
 }
 
 func (u User) CheckPriv(lvl string) bool {
@@ -50,6 +84,11 @@ func (u User) IsMod() bool {
 	return u.Privilege >= PRIV_ADMIN
 }
 
+// TotpEnabled returns true if the user has two-factor authentication set up.
+func (u User) TotpEnabled() bool {
+	return u.TotpSecret != ""
+}
+
 func (u User) String() string {
 	return fmt.Sprintf(
 		"User{Id:%d Name:%q Email:%q NotifyCycleEnd:%t NotifyVoteSelection:%t Privilege:%d PassDate:%s}",
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+)
+
+// Events that can be sent to webhooks
+const (
+	WebhookMovieAdded    string = "movie.added"
+	WebhookMovieApproved string = "movie.approved"
+	WebhookMovieRemoved  string = "movie.removed"
+	WebhookCycleStarted  string = "cycle.started"
+	WebhookVotingClosed  string = "cycle.voting_closed"
+	WebhookCycleEnded    string = "cycle.ended"
+)
+
+var WebhookEvents []string = []string{
+	WebhookMovieAdded,
+	WebhookMovieApproved,
+	WebhookMovieRemoved,
+	WebhookCycleStarted,
+	WebhookVotingClosed,
+	WebhookCycleEnded,
+}
+
+// Webhook is a URL that event payloads are POSTed to.
+type Webhook struct {
+	Id      int
+	Url     string
+	Enabled bool
+
+	// Used to sign the payloads.  Receivers should verify the signature.
+	Secret string
+
+	// Events sent to this webhook.  Empty means all events.
+	Events []string
+}
+
+func (w *Webhook) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the payload using the
+// webhook's secret.
+func (w *Webhook) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateWebhookUrl returns an error if the URL can't be used for a webhook.
+func ValidateWebhookUrl(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("Invalid URL: %v", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Webhook URLs must use http or https")
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("Webhook URL is missing a host")
+	}
+
+	return nil
+}
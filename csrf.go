@@ -0,0 +1,70 @@
+package moviepoll
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Name of the form field and header carrying the CSRF token.
+const (
+	csrfFormField = "CsrfToken"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// csrfToken returns the CSRF token of the current session, creating a new
+// one if the session doesn't have one yet.
+func (s *Server) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	session, err := s.cookies.Get(r, SessionName)
+	if err != nil {
+		s.l.Error("Unable to get session from store: %v", err)
+	}
+
+	if token, ok := session.Values["CsrfToken"].(string); ok && token != "" {
+		return token
+	}
+
+	token := getCryptRandKey(32)
+	session.Values["CsrfToken"] = token
+	if err = session.Save(r, w); err != nil {
+		s.l.Error("Unable to save CSRF token: %v", err)
+	}
+	return token
+}
+
+// csrfProtect rejects any request that can change state unless it carries
+// the CSRF token of the session, either in a form field or in a header.
+func (s *Server) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS", "TRACE":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The API doesn't use session cookies.
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var expected string
+		session, err := s.cookies.Get(r, SessionName)
+		if err == nil {
+			expected, _ = session.Values["CsrfToken"].(string)
+		}
+
+		token := r.Header.Get(csrfHeader)
+		if token == "" {
+			token = r.PostFormValue(csrfFormField)
+		}
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			s.l.Info("Rejected %s %s from %s: invalid CSRF token", r.Method, r.URL.Path, s.clientAddr(r))
+			s.doError(http.StatusForbidden, "Invalid or missing form token.  Reload the page and try again.", w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,140 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// postForm sends a form through the full router, including the CSRF
+// middleware.
+func postForm(s *Server, path string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	addCookies(req, cookies)
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func setupVoteTest(t *testing.T) (*Server, *common.User, int) {
+	s := newTestServer(t)
+	if _, err := s.data.AddCycle(nil); err != nil {
+		t.Fatal(err)
+	}
+	s.data.SetCfgBool(ConfigVotingEnabled, true)
+
+	user := addTestUser(t, s, "voter", common.PRIV_USER)
+	id, err := s.data.AddMovie(&common.Movie{Name: "CSRF Test", AddedBy: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, user, id
+}
+
+func Test_CsrfVote(t *testing.T) {
+	s, user, movieId := setupVoteTest(t)
+	cookies := loginCookies(t, s, user)
+	path := fmt.Sprintf("/vote/%d", movieId)
+
+	// A cross-site form post carries the cookies but not the token.
+	rec := postForm(s, path, url.Values{}, cookies)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected vote without token to be rejected, got %d", rec.Code)
+	}
+
+	// Token from a different session
+	other := loginCookies(t, s, addTestUser(t, s, "other", common.PRIV_USER))
+	rec = postForm(s, path, url.Values{"CsrfToken": {csrfTokenFor(t, s, other)}}, cookies)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected vote with foreign token to be rejected, got %d", rec.Code)
+	}
+
+	// Votes can't be cast with a plain link anymore.
+	req := addCookies(httptest.NewRequest("GET", path, nil), cookies)
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET vote to be rejected, got %d", rec.Code)
+	}
+
+	if voted, _ := s.data.UserVotedForMovie(user.Id, movieId); voted {
+		t.Fatalf("Rejected requests cast a vote")
+	}
+
+	rec = postForm(s, path, url.Values{"CsrfToken": {csrfTokenFor(t, s, cookies)}}, cookies)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected redirect after vote, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if voted, _ := s.data.UserVotedForMovie(user.Id, movieId); !voted {
+		t.Errorf("Vote with a valid token was not cast")
+	}
+
+	// The header works as well
+	req = httptest.NewRequest("POST", path, nil)
+	req.Header.Set("X-CSRF-Token", csrfTokenFor(t, s, cookies))
+	addCookies(req, cookies)
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Errorf("Expected token header to be accepted, got %d", rec.Code)
+	}
+}
+
+func Test_CsrfAdminPurge(t *testing.T) {
+	s := newTestServer(t)
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+	victim := addTestUser(t, s, "victim", common.PRIV_USER)
+	cookies := loginCookies(t, s, admin)
+	path := fmt.Sprintf("/admin/user/%d?action=purge", victim.Id)
+
+	// The old confirmation link only shows the confirmation page now.
+	req := addCookies(httptest.NewRequest("GET", path+"&confirm=yes", nil), cookies)
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected confirmation page, got %d", rec.Code)
+	}
+
+	rec = postForm(s, path, url.Values{"confirm": {"yes"}}, cookies)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected purge without token to be rejected, got %d", rec.Code)
+	}
+
+	if user, err := s.data.GetUser(victim.Id); err != nil || user == nil {
+		t.Fatalf("User was purged by a rejected request")
+	}
+
+	rec = postForm(s, path, url.Values{"confirm": {"yes"}, "CsrfToken": {csrfTokenFor(t, s, cookies)}}, cookies)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected purge notice, got %d", rec.Code)
+	}
+
+	if user, err := s.data.GetUser(victim.Id); err == nil && user != nil {
+		t.Errorf("User was not purged")
+	}
+}
+
+func Test_CsrfLogout(t *testing.T) {
+	s := newTestServer(t)
+	user := addTestUser(t, s, "logout", common.PRIV_USER)
+	cookies := loginCookies(t, s, user)
+
+	rec := postForm(s, "/user/logout", url.Values{}, cookies)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected logout without token to be rejected, got %d", rec.Code)
+	}
+
+	rec = postForm(s, "/user/logout", url.Values{"CsrfToken": {csrfTokenFor(t, s, cookies)}}, cookies)
+	if rec.Code != http.StatusFound {
+		t.Errorf("Expected redirect after logout, got %d", rec.Code)
+	}
+}
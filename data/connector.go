@@ -34,11 +34,28 @@ type DataConnector interface {
 	GetCycle(id int) (*common.Cycle, error)
 	GetMovie(id int) (*common.Movie, error)
 	GetUser(id int) (*common.User, error)
+	// Return the user linked to the given OpenID Connect subject, or nil if
+	// no user is linked to it.
+	GetUserByOidcSubject(subject string) (*common.User, error)
+	// Refuse future logins with an OpenID Connect subject.  Used when the
+	// account it was linked to is deleted or purged.
+	RevokeOidcSubject(subject string) error
+	OidcSubjectRevoked(subject string) (bool, error)
+	// Return the user linked to the given Twitch login, or nil if no user is
+	// linked to it.
+	GetUserByTwitchName(name string) (*common.User, error)
+	// Return the user with the given private calendar feed token, or nil if
+	// no user has it.
+	GetUserByCalendarToken(token string) (*common.User, error)
 	GetActiveMovies() ([]*common.Movie, error)
+	// All movies, including watched ones.
+	GetMovies() ([]*common.Movie, error)
 	GetTag(id int) *common.Tag
+	GetTags() ([]*common.Tag, error)
 	GetLink(id int) *common.Link
 
 	SearchMovieTitles(query string) ([]*common.Movie, error)
+	// Find a tag by its name or one of its aliases.
 	FindTag(name string) (int, error)
 	FindLink(url string) (int, error)
 
@@ -75,6 +92,16 @@ type DataConnector interface {
 	UpdateUser(user *common.User) error
 	UpdateMovie(movie *common.Movie) error
 	UpdateCycle(cycle *common.Cycle) error
+	// Update a tag's name and aliases.  Fails if the name or an alias is
+	// already used by another tag.
+	UpdateTag(tag *common.Tag) error
+	// Replace the tag fromId with intoId on every movie.  The name and
+	// aliases of fromId become aliases of intoId, then fromId is deleted.
+	MergeTags(fromId, intoId int) error
+
+	AddMovieRevision(rev *common.MovieRevision) (int, error)
+	// Revisions of a movie, oldest first.
+	GetMovieRevisions(movieId int) ([]*common.MovieRevision, error)
 
 	CheckMovieExists(title string) (bool, error)
 	CheckUserExists(name string) (bool, error)
@@ -100,6 +127,47 @@ type DataConnector interface {
 	SetCfgBool(key string, value bool) error
 
 	DeleteCfgKey(key string) error
+
+	// Server side sessions
+	AddSession(session *common.Session) error
+	// Return nil if no session with the given ID exists.
+	GetSession(id string) (*common.Session, error)
+	GetUserSessions(userId int) ([]*common.Session, error)
+	UpdateSession(session *common.Session) error
+	DeleteSession(id string) error
+	// Delete all of a user's sessions, logging them out everywhere.
+	DeleteUserSessions(userId int) error
+
+	// Outgoing webhooks
+	AddWebhook(hook *common.Webhook) (int, error)
+	GetWebhook(id int) (*common.Webhook, error)
+	GetWebhooks() ([]*common.Webhook, error)
+	UpdateWebhook(hook *common.Webhook) error
+	DeleteWebhook(id int) error
+
+	// Scheduled watch nights
+	AddShowing(showing *common.Showing) (int, error)
+	GetShowing(id int) (*common.Showing, error)
+	// Showings of a cycle's movies, ordered by start time.
+	GetCycleShowings(cycleId int) ([]*common.Showing, error)
+	// Showings of a movie, ordered by start time.
+	GetMovieShowings(movieId int) ([]*common.Showing, error)
+	// Update the start and location of a showing.  Attendees are changed
+	// with SetRsvp().
+	UpdateShowing(showing *common.Showing) error
+	DeleteShowing(id int) error
+	SetRsvp(showingId, userId int, attending bool) error
+
+	// Ratings and reviews of watched movies
+	// Add the user's review of a movie, or replace their existing one.
+	SetReview(review *common.Review) (int, error)
+	// Return nil if the user didn't review the movie.
+	GetUserReview(userId, movieId int) (*common.Review, error)
+	// Reviews of a movie, newest first.
+	GetMovieReviews(movieId int) ([]*common.Review, error)
+	// Reviews by a user, newest first.
+	GetUserReviews(userId int) ([]*common.Review, error)
+	DeleteReview(userId, movieId int) error
 }
 
 type TestableDataConnector interface {
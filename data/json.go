@@ -146,6 +146,15 @@ type jsonConnector struct {
 	Tags   map[int]*common.Tag
 	Links  map[int]*common.Link
 
+	Sessions  map[string]*common.Session
+	Webhooks  map[int]*common.Webhook
+	Revisions []*common.MovieRevision
+	Showings  map[int]*common.Showing
+	Reviews   map[int]*common.Review
+
+	// OIDC subjects of deleted and purged accounts
+	RevokedSubjects []string
+
 	//Settings Configurator
 	Settings map[string]configValue
 
@@ -184,6 +193,14 @@ func newJsonConnector(filename string, l *common.Logger) (*jsonConnector, error)
 		Tags:   map[int]*common.Tag{},
 		Links:  map[int]*common.Link{},
 		l:      l,
+
+		Sessions:  map[string]*common.Session{},
+		Webhooks:  map[int]*common.Webhook{},
+		Revisions: []*common.MovieRevision{},
+		Showings:  map[int]*common.Showing{},
+		Reviews:   map[int]*common.Review{},
+
+		RevokedSubjects: []string{},
 	}
 
 	return j, j.save()
@@ -233,6 +250,30 @@ func loadJson(filename string, l *common.Logger) (*jsonConnector, error) {
 		data.Links = make(map[int]*common.Link)
 	}
 
+	if data.Sessions == nil {
+		data.Sessions = make(map[string]*common.Session)
+	}
+
+	if data.Webhooks == nil {
+		data.Webhooks = make(map[int]*common.Webhook)
+	}
+
+	if data.RevokedSubjects == nil {
+		data.RevokedSubjects = []string{}
+	}
+
+	if data.Revisions == nil {
+		data.Revisions = []*common.MovieRevision{}
+	}
+
+	if data.Showings == nil {
+		data.Showings = make(map[int]*common.Showing)
+	}
+
+	if data.Reviews == nil {
+		data.Reviews = make(map[int]*common.Review)
+	}
+
 	return data, nil
 }
 
@@ -466,6 +507,21 @@ func (j *jsonConnector) GetActiveMovies() ([]*common.Movie, error) {
 	return movies, nil
 }
 
+func (j *jsonConnector) GetMovies() ([]*common.Movie, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	movies := []*common.Movie{}
+	for _, m := range j.Movies {
+		if mov := j.findMovie(m.Id); mov != nil {
+			mov.Votes = j.findVotes(mov)
+			movies = append(movies, mov)
+		}
+	}
+
+	return movies, nil
+}
+
 type sortableCycle []jsonCycle
 
 func (s sortableCycle) Len() int { return len(s) }
@@ -637,6 +693,85 @@ func (j *jsonConnector) GetUser(userId int) (*common.User, error) {
 	return u, nil
 }
 
+func (j *jsonConnector) GetUserByOidcSubject(subject string) (*common.User, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	if subject == "" {
+		return nil, fmt.Errorf("Subject cannot be empty")
+	}
+
+	for _, u := range j.Users {
+		if u.OidcSubject == subject {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (j *jsonConnector) RevokeOidcSubject(subject string) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if subject == "" {
+		return fmt.Errorf("Subject cannot be empty")
+	}
+
+	for _, s := range j.RevokedSubjects {
+		if s == subject {
+			return nil
+		}
+	}
+
+	j.RevokedSubjects = append(j.RevokedSubjects, subject)
+	return j.save()
+}
+
+func (j *jsonConnector) OidcSubjectRevoked(subject string) (bool, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	for _, s := range j.RevokedSubjects {
+		if s == subject {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (j *jsonConnector) GetUserByTwitchName(name string) (*common.User, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	if name == "" {
+		return nil, fmt.Errorf("Twitch name cannot be empty")
+	}
+
+	name = strings.ToLower(name)
+	for _, u := range j.Users {
+		if u.TwitchName == name {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (j *jsonConnector) GetUserByCalendarToken(token string) (*common.User, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	if token == "" {
+		return nil, fmt.Errorf("Calendar token cannot be empty")
+	}
+
+	for _, u := range j.Users {
+		if u.CalendarToken == token {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
 func (j *jsonConnector) GetUserVotes(userId int) ([]*common.Movie, error) {
 	j.lock.RLock()
 	defer j.lock.RUnlock()
@@ -799,16 +934,15 @@ func (j *jsonConnector) AddTag(tag *common.Tag) (int, error) {
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
+	tag.Name = strings.TrimSpace(tag.Name)
 	if tag.Name == "" {
 		return 0, fmt.Errorf("Name cannot be empty")
 	}
 
-	//duplicate check
-	for id, jtag := range j.Tags {
-		if strings.ToLower(tag.Name) == strings.ToLower(jtag.Name) {
-			j.l.Debug("Tag '%v' is already in the database with id: %v", tag.Name, id)
-			return id, nil
-		}
+	//duplicate check, including aliases
+	if id := j.findTagByName(tag.Name); id != 0 {
+		j.l.Debug("Tag '%v' is already in the database with id: %v", tag.Name, id)
+		return id, nil
 	}
 
 	id := j.nextTagId()
@@ -819,16 +953,22 @@ func (j *jsonConnector) AddTag(tag *common.Tag) (int, error) {
 	return id, j.save()
 }
 
+// Must be called with the lock held.
+func (j *jsonConnector) findTagByName(name string) int {
+	for id, tag := range j.Tags {
+		if tag.Matches(name) {
+			return id
+		}
+	}
+	return 0
+}
+
 func (j *jsonConnector) FindTag(name string) (int, error) {
 	j.lock.RLock()
 	defer j.lock.RUnlock()
 
-	name = strings.ToLower(name)
-
-	for id, tag := range j.Tags {
-		if strings.ToLower(tag.Name) == name {
-			return id, nil
-		}
+	if id := j.findTagByName(name); id != 0 {
+		return id, nil
 	}
 	return 0, fmt.Errorf("No tag found with name: %s", name)
 }
@@ -839,11 +979,102 @@ func (j *jsonConnector) GetTag(id int) *common.Tag {
 	return j.Tags[id]
 }
 
+func (j *jsonConnector) GetTags() ([]*common.Tag, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	tags := []*common.Tag{}
+	for _, tag := range j.Tags {
+		t := *tag
+		tags = append(tags, &t)
+	}
+
+	sort.Slice(tags, func(i, k int) bool {
+		return strings.ToLower(tags[i].Name) < strings.ToLower(tags[k].Name)
+	})
+	return tags, nil
+}
+
+func (j *jsonConnector) UpdateTag(tag *common.Tag) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	current, ok := j.Tags[tag.Id]
+	if !ok {
+		return fmt.Errorf("Tag with ID %d not found", tag.Id)
+	}
+
+	tag.Name = strings.TrimSpace(tag.Name)
+	if tag.Name == "" {
+		return fmt.Errorf("Name cannot be empty")
+	}
+
+	names := append([]string{tag.Name}, tag.Aliases...)
+	for _, name := range names {
+		if id := j.findTagByName(name); id != 0 && id != tag.Id {
+			return fmt.Errorf("%q is already used by the tag %q", name, j.Tags[id].Name)
+		}
+	}
+
+	current.Name = tag.Name
+	current.Aliases = tag.Aliases
+	return j.save()
+}
+
+func (j *jsonConnector) MergeTags(fromId, intoId int) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if fromId == intoId {
+		return fmt.Errorf("Cannot merge a tag into itself")
+	}
+
+	from, ok := j.Tags[fromId]
+	if !ok {
+		return fmt.Errorf("Tag with ID %d not found", fromId)
+	}
+
+	into, ok := j.Tags[intoId]
+	if !ok {
+		return fmt.Errorf("Tag with ID %d not found", intoId)
+	}
+
+	for id, movie := range j.Movies {
+		found := false
+		tags := []int{}
+		for _, t := range movie.Tags {
+			if t == fromId || t == intoId {
+				if found {
+					continue
+				}
+				found = true
+				t = intoId
+			}
+			tags = append(tags, t)
+		}
+
+		movie.Tags = tags
+		j.Movies[id] = movie
+	}
+
+	for _, name := range append([]string{from.Name}, from.Aliases...) {
+		if !into.Matches(name) {
+			into.Aliases = append(into.Aliases, name)
+		}
+	}
+
+	delete(j.Tags, fromId)
+	return j.save()
+}
+
 func (j *jsonConnector) DeleteTag(id int) {
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
 	delete(j.Tags, id)
+	if err := j.save(); err != nil {
+		j.l.Error("Unable to save after deleting tag %d: %v", id, err)
+	}
 }
 
 func (j *jsonConnector) nextTagId() int {
@@ -1241,6 +1472,9 @@ func (j *jsonConnector) DeleteUser(userId int) error {
 		return fmt.Errorf("User with ID %d does not exist", userId)
 	}
 
+	j.deleteUserReviews(userId)
+	j.deleteUserSessions(userId)
+	j.deleteUserRsvps(userId)
 	delete(j.Users, userId)
 	return j.save()
 }
@@ -1261,10 +1495,417 @@ func (j *jsonConnector) PurgeUser(userId int) error {
 	j.Votes = newVotes
 	j.l.Info("Purged %d votes", count)
 
+	j.deleteUserReviews(userId)
+	j.deleteUserSessions(userId)
+	j.deleteUserRsvps(userId)
 	delete(j.Users, userId)
 	return j.save()
 }
 
+func (j *jsonConnector) AddSession(session *common.Session) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if session.Id == "" {
+		return fmt.Errorf("Session ID cannot be empty")
+	}
+
+	if _, exists := j.Sessions[session.Id]; exists {
+		return fmt.Errorf("Session already exists")
+	}
+
+	j.Sessions[session.Id] = session
+	return j.save()
+}
+
+func (j *jsonConnector) GetSession(id string) (*common.Session, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	session, ok := j.Sessions[id]
+	if !ok {
+		return nil, nil
+	}
+
+	s := *session
+	return &s, nil
+}
+
+func (j *jsonConnector) GetUserSessions(userId int) ([]*common.Session, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	found := []*common.Session{}
+	for _, session := range j.Sessions {
+		if session.UserId == userId {
+			s := *session
+			found = append(found, &s)
+		}
+	}
+
+	sort.Slice(found, func(i, k int) bool { return found[i].LastSeen.After(found[k].LastSeen) })
+	return found, nil
+}
+
+func (j *jsonConnector) UpdateSession(session *common.Session) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if _, exists := j.Sessions[session.Id]; !exists {
+		return fmt.Errorf("Session does not exist")
+	}
+
+	s := *session
+	j.Sessions[session.Id] = &s
+	return j.save()
+}
+
+func (j *jsonConnector) DeleteSession(id string) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	delete(j.Sessions, id)
+	return j.save()
+}
+
+func (j *jsonConnector) DeleteUserSessions(userId int) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.deleteUserSessions(userId)
+	return j.save()
+}
+
+// Must be called with the lock held.
+func (j *jsonConnector) deleteUserSessions(userId int) {
+	for id, session := range j.Sessions {
+		if session.UserId == userId {
+			delete(j.Sessions, id)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (j *jsonConnector) deleteUserReviews(userId int) {
+	for id, review := range j.Reviews {
+		if review.UserId == userId {
+			delete(j.Reviews, id)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (j *jsonConnector) deleteUserRsvps(userId int) {
+	for _, showing := range j.Showings {
+		attendees := []int{}
+		for _, id := range showing.Attendees {
+			if id != userId {
+				attendees = append(attendees, id)
+			}
+		}
+		showing.Attendees = attendees
+	}
+}
+
+func (j *jsonConnector) nextWebhookId() int {
+	highest := 0
+	for _, w := range j.Webhooks {
+		if w.Id > highest {
+			highest = w.Id
+		}
+	}
+	return highest + 1
+}
+
+func (j *jsonConnector) AddWebhook(hook *common.Webhook) (int, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	w := *hook
+	w.Id = j.nextWebhookId()
+	j.Webhooks[w.Id] = &w
+	return w.Id, j.save()
+}
+
+func (j *jsonConnector) GetWebhook(id int) (*common.Webhook, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	hook, ok := j.Webhooks[id]
+	if !ok {
+		return nil, fmt.Errorf("Webhook with ID %d not found", id)
+	}
+
+	w := *hook
+	return &w, nil
+}
+
+func (j *jsonConnector) GetWebhooks() ([]*common.Webhook, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	hooks := []*common.Webhook{}
+	for _, hook := range j.Webhooks {
+		w := *hook
+		hooks = append(hooks, &w)
+	}
+
+	sort.Slice(hooks, func(i, k int) bool { return hooks[i].Id < hooks[k].Id })
+	return hooks, nil
+}
+
+func (j *jsonConnector) UpdateWebhook(hook *common.Webhook) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if _, ok := j.Webhooks[hook.Id]; !ok {
+		return fmt.Errorf("Webhook with ID %d not found", hook.Id)
+	}
+
+	w := *hook
+	j.Webhooks[w.Id] = &w
+	return j.save()
+}
+
+func (j *jsonConnector) DeleteWebhook(id int) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	delete(j.Webhooks, id)
+	return j.save()
+}
+
+func (j *jsonConnector) AddMovieRevision(rev *common.MovieRevision) (int, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	highest := 0
+	for _, r := range j.Revisions {
+		if r.Id > highest {
+			highest = r.Id
+		}
+	}
+
+	r := *rev
+	r.Id = highest + 1
+	j.Revisions = append(j.Revisions, &r)
+	return r.Id, j.save()
+}
+
+func (j *jsonConnector) GetMovieRevisions(movieId int) ([]*common.MovieRevision, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	revisions := []*common.MovieRevision{}
+	for _, rev := range j.Revisions {
+		if rev.MovieId == movieId {
+			r := *rev
+			revisions = append(revisions, &r)
+		}
+	}
+	return revisions, nil
+}
+
+func copyShowing(showing *common.Showing) *common.Showing {
+	s := *showing
+	s.Attendees = append([]int{}, showing.Attendees...)
+	return &s
+}
+
+// findShowings returns copies of the showings matching the filter, ordered
+// by start time.
+func (j *jsonConnector) findShowings(filter func(*common.Showing) bool) []*common.Showing {
+	showings := []*common.Showing{}
+	for _, showing := range j.Showings {
+		if filter(showing) {
+			showings = append(showings, copyShowing(showing))
+		}
+	}
+
+	sort.Slice(showings, func(i, k int) bool {
+		if showings[i].Start.Equal(showings[k].Start) {
+			return showings[i].Id < showings[k].Id
+		}
+		return showings[i].Start.Before(showings[k].Start)
+	})
+	return showings
+}
+
+func (j *jsonConnector) AddShowing(showing *common.Showing) (int, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	highest := 0
+	for _, s := range j.Showings {
+		if s.Id > highest {
+			highest = s.Id
+		}
+	}
+
+	s := copyShowing(showing)
+	s.Id = highest + 1
+	s.Start = s.Start.Round(time.Second)
+	j.Showings[s.Id] = s
+	return s.Id, j.save()
+}
+
+func (j *jsonConnector) GetShowing(id int) (*common.Showing, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	showing, ok := j.Showings[id]
+	if !ok {
+		return nil, fmt.Errorf("Showing with ID %d not found", id)
+	}
+	return copyShowing(showing), nil
+}
+
+func (j *jsonConnector) GetCycleShowings(cycleId int) ([]*common.Showing, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	return j.findShowings(func(s *common.Showing) bool { return s.CycleId == cycleId }), nil
+}
+
+func (j *jsonConnector) GetMovieShowings(movieId int) ([]*common.Showing, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	return j.findShowings(func(s *common.Showing) bool { return s.MovieId == movieId }), nil
+}
+
+func (j *jsonConnector) UpdateShowing(showing *common.Showing) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	s, ok := j.Showings[showing.Id]
+	if !ok {
+		return fmt.Errorf("Showing with ID %d not found", showing.Id)
+	}
+
+	s.Start = showing.Start.Round(time.Second)
+	s.Location = showing.Location
+	return j.save()
+}
+
+func (j *jsonConnector) DeleteShowing(id int) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	delete(j.Showings, id)
+	return j.save()
+}
+
+func (j *jsonConnector) SetRsvp(showingId, userId int, attending bool) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	s, ok := j.Showings[showingId]
+	if !ok {
+		return fmt.Errorf("Showing with ID %d not found", showingId)
+	}
+
+	attendees := []int{}
+	for _, id := range s.Attendees {
+		if id != userId {
+			attendees = append(attendees, id)
+		}
+	}
+
+	if attending {
+		attendees = append(attendees, userId)
+	}
+
+	s.Attendees = attendees
+	return j.save()
+}
+
+// findReviews returns copies of the reviews matching the filter, newest
+// first.
+func (j *jsonConnector) findReviews(filter func(*common.Review) bool) []*common.Review {
+	reviews := []*common.Review{}
+	for _, review := range j.Reviews {
+		if filter(review) {
+			r := *review
+			reviews = append(reviews, &r)
+		}
+	}
+
+	sort.Slice(reviews, func(i, k int) bool {
+		if reviews[i].Updated.Equal(reviews[k].Updated) {
+			return reviews[i].Id > reviews[k].Id
+		}
+		return reviews[i].Updated.After(reviews[k].Updated)
+	})
+	return reviews
+}
+
+func (j *jsonConnector) SetReview(review *common.Review) (int, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	now := time.Now().Round(time.Second)
+	highest := 0
+	for _, r := range j.Reviews {
+		if r.UserId == review.UserId && r.MovieId == review.MovieId {
+			r.Rating = review.Rating
+			r.Text = review.Text
+			r.Updated = now
+			return r.Id, j.save()
+		}
+
+		if r.Id > highest {
+			highest = r.Id
+		}
+	}
+
+	r := *review
+	r.Id = highest + 1
+	r.Created = now
+	r.Updated = now
+	j.Reviews[r.Id] = &r
+	return r.Id, j.save()
+}
+
+func (j *jsonConnector) GetUserReview(userId, movieId int) (*common.Review, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	for _, review := range j.Reviews {
+		if review.UserId == userId && review.MovieId == movieId {
+			r := *review
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (j *jsonConnector) GetMovieReviews(movieId int) ([]*common.Review, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	return j.findReviews(func(r *common.Review) bool { return r.MovieId == movieId }), nil
+}
+
+func (j *jsonConnector) GetUserReviews(userId int) ([]*common.Review, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	return j.findReviews(func(r *common.Review) bool { return r.UserId == userId }), nil
+}
+
+func (j *jsonConnector) DeleteReview(userId, movieId int) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	for id, review := range j.Reviews {
+		if review.UserId == userId && review.MovieId == movieId {
+			delete(j.Reviews, id)
+		}
+	}
+	return j.save()
+}
+
 func (j *jsonConnector) SearchMovieTitles(query string) ([]*common.Movie, error) {
 	j.lock.RLock()
 	defer j.lock.RUnlock()
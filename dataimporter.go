@@ -6,14 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"image/jpeg"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strings"
 
-	"github.com/nfnt/resize"
 	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/storage"
 )
 
 type dataapi interface {
@@ -27,14 +25,16 @@ type dataapi interface {
 }
 
 type tmdb struct {
-	l     *common.Logger
-	id    string
-	token string
-	resp  *map[string]interface{}
+	l       *common.Logger
+	posters storage.Storage
+	id      string
+	token   string
+	resp    *map[string]interface{}
 }
 
 type jikan struct {
 	l             *common.Logger
+	posters       storage.Storage
 	id            string
 	excludedTypes []string
 	resp          *map[string]interface{}
@@ -178,9 +178,7 @@ func (t *tmdb) getPoster() (string, error) {
 
 	fileurl := "https://image.tmdb.org/t/p/original" + external_path
 
-	path := "posters/" + t.id + ".jpg"
-
-	err := DownloadFile(path, fileurl)
+	path, err := downloadPoster(t.posters, fileurl)
 
 	if err != nil {
 		return "unknown.jpg", errors.New("Error while downloading file, using unknown.jpg")
@@ -308,8 +306,7 @@ func (j *jikan) getPoster() (string, error) {
 		return "", nil
 	}
 
-	path := "posters/" + j.id + ".jpg"
-	err := DownloadFile(path, fileurl)
+	path, err := downloadPoster(j.posters, fileurl)
 
 	if !(err == nil) {
 		return "unknown.jpg", errors.New("Error while downloading file, using unknown.jpg")
@@ -358,34 +355,3 @@ func (j *jikan) getTags() (string, error) {
 	// to the caller
 	return strings.Join(tags, ","), nil
 }
-
-func DownloadFile(filepath string, url string) error {
-
-	// Download image data
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	// Create the file
-	file, err := os.Create(filepath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Decode image data
-	image, err := jpeg.Decode(resp.Body)
-
-	// Resize the raw image
-	resized := resize.Resize(200, 0, image, resize.NearestNeighbor)
-
-	// Reencode the image data
-	err = jpeg.Encode(file, resized, nil)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
@@ -0,0 +1,281 @@
+package moviepoll
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Colour of the bar on the side of embeds
+const discordColor int = 0x6C3483
+
+// How often the planned end of the cycle is checked for the reminder.
+const discordReminderInterval time.Duration = time.Minute
+
+// Stores the ID of the last cycle a reminder was sent for so restarts don't
+// send it again.
+const discordRemindedKey string = "DiscordRemindedCycle"
+
+type discordMessage struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []discordEmbed `json:"embeds,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Url         string         `json:"url,omitempty"`
+	Color       int            `json:"color,omitempty"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Image       *discordImage  `json:"image,omitempty"`
+	Thumbnail   *discordImage  `json:"thumbnail,omitempty"`
+	Footer      *discordFooter `json:"footer,omitempty"`
+	Timestamp   *time.Time     `json:"timestamp,omitempty"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type discordImage struct {
+	Url string `json:"url"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+// startDiscord announces events in Discord and sends the reminder before the
+// end of a cycle.  Nothing is sent unless a Discord webhook URL is set.
+// Events are queued while a post waits for Discord's rate limit, so none
+// are lost.
+func (s *Server) startDiscord() {
+	ch := s.events.subscribeQueued(EventMovie, EventApproved, EventCycle)
+	go func() {
+		for ev := range ch {
+			s.announceDiscord(ev)
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(discordReminderInterval)
+		for now := range ticker.C {
+			s.checkDiscordReminder(now)
+		}
+	}()
+}
+
+// discordUrl returns the configured Discord webhook URL, or an empty string
+// if the announcer is disabled.
+func (s *Server) discordUrl() string {
+	url, err := s.data.GetCfgString(ConfigDiscordWebhook, DefaultDiscordWebhook)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigDiscordWebhook, err)
+		return ""
+	}
+	return strings.TrimSpace(url)
+}
+
+// siteUrl returns an absolute URL for the given path, or an empty string if
+// the host address isn't configured.
+func (s *Server) siteUrl(path string) string {
+	host, err := s.data.GetCfgString(ConfigHostAddress, "")
+	if err != nil || host == "" {
+		return ""
+	}
+
+	if !strings.HasPrefix(strings.ToLower(host), "http") {
+		host = "http://" + host
+	}
+	return strings.TrimRight(host, "/") + path
+}
+
+func (s *Server) announceDiscord(ev event) {
+	hookUrl := s.discordUrl()
+	if hookUrl == "" {
+		return
+	}
+
+	var msg *discordMessage
+	switch ev.Type {
+	case EventMovie, EventApproved:
+		// With approval enabled suggestions are announced once approved.
+		if movie, ok := ev.Data.(movieEvent); ok {
+			msg = s.discordSuggestion(movie)
+		}
+
+	case EventCycle:
+		if cycle, ok := ev.Data.(cycleEvent); ok && cycle.State == CycleEnded {
+			msg = s.discordWinners(cycle)
+		}
+	}
+
+	if msg == nil {
+		return
+	}
+
+	if err := postDiscord(hookUrl, msg); err != nil {
+		s.l.Error("Unable to post %s event to Discord: %v", ev.Type, err)
+	}
+}
+
+func (s *Server) discordMovieEmbed(movie movieEvent) discordEmbed {
+	embed := discordEmbed{
+		Title: movie.Name,
+		Url:   s.siteUrl(fmt.Sprintf("/movie/%d", movie.MovieId)),
+		Color: discordColor,
+	}
+
+	if movie.Poster != "" {
+		// Posters in external storage already have a full URL
+		poster := s.posterUrl(movie.Poster)
+		if strings.HasPrefix(poster, "/") {
+			poster = s.siteUrl(poster)
+		}
+
+		if poster != "" {
+			embed.Thumbnail = &discordImage{Url: poster}
+		}
+	}
+
+	if movie.AddedBy != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "Suggested by", Value: movie.AddedBy, Inline: true})
+	}
+
+	return embed
+}
+
+func (s *Server) discordSuggestion(movie movieEvent) *discordMessage {
+	embed := s.discordMovieEmbed(movie)
+	embed.Description = "New suggestion"
+
+	return &discordMessage{Embeds: []discordEmbed{embed}}
+}
+
+func (s *Server) discordWinners(cycle cycleEvent) *discordMessage {
+	if len(cycle.Winners) == 0 {
+		return nil
+	}
+
+	msg := &discordMessage{Content: "The votes are in!"}
+	if len(cycle.Winners) > 1 {
+		msg.Content += " The winners are:"
+	} else {
+		msg.Content += " The winner is:"
+	}
+
+	for _, movie := range cycle.Winners {
+		embed := s.discordMovieEmbed(movie)
+		embed.Fields = append(embed.Fields, discordField{Name: "Votes", Value: fmt.Sprint(movie.Votes), Inline: true})
+
+		// Show the full poster for the winners
+		embed.Image, embed.Thumbnail = embed.Thumbnail, nil
+		msg.Embeds = append(msg.Embeds, embed)
+	}
+
+	// Discord doesn't take more than ten embeds in a message
+	if len(msg.Embeds) > 10 {
+		msg.Embeds = msg.Embeds[:10]
+	}
+
+	return msg
+}
+
+// checkDiscordReminder posts a reminder once the current cycle is within the
+// configured time of its planned end.
+func (s *Server) checkDiscordReminder(now time.Time) {
+	hookUrl := s.discordUrl()
+	if hookUrl == "" {
+		return
+	}
+
+	minutes, err := s.data.GetCfgInt(ConfigDiscordReminder, DefaultDiscordReminder)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigDiscordReminder, err)
+		return
+	}
+
+	if minutes <= 0 {
+		return
+	}
+
+	cycle, err := s.data.GetCurrentCycle()
+	if err != nil || cycle == nil || cycle.PlannedEnd == nil {
+		return
+	}
+
+	end := *cycle.PlannedEnd
+	if now.After(end) || now.Before(end.Add(-time.Duration(minutes)*time.Minute)) {
+		return
+	}
+
+	reminded, err := s.data.GetCfgInt(discordRemindedKey, 0)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", discordRemindedKey, err)
+		return
+	}
+
+	if reminded == cycle.Id {
+		return
+	}
+
+	// Only try once, a missed reminder isn't worth spamming the channel over.
+	if err = s.data.SetCfgInt(discordRemindedKey, cycle.Id); err != nil {
+		s.l.Error("Unable to set %s: %v", discordRemindedKey, err)
+		return
+	}
+
+	msg := &discordMessage{Embeds: []discordEmbed{discordEmbed{
+		Title:       "Voting ends soon",
+		Description: fmt.Sprintf("Voting closes <t:%d:R>.  Get your votes in!", end.Unix()),
+		Url:         s.siteUrl("/"),
+		Color:       discordColor,
+		Timestamp:   &end,
+		Footer:      &discordFooter{Text: "Voting closes"},
+	}}}
+
+	if err = postDiscord(hookUrl, msg); err != nil {
+		s.l.Error("Unable to post reminder to Discord: %v", err)
+	}
+}
+
+// postDiscord sends a message to a Discord webhook.  If Discord asks to slow
+// down the message is sent once more after the requested delay.
+func postDiscord(hookUrl string, msg *discordMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal message: %v", err)
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		resp, err := webhookClient.Post(hookUrl, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt == 0 {
+			wait, err := time.ParseDuration(resp.Header.Get("Retry-After") + "s")
+			if err != nil || wait > webhookTimeout {
+				wait = webhookTimeout
+			}
+			time.Sleep(wait)
+			continue
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("Unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Rate limited by Discord")
+}
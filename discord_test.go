@@ -0,0 +1,176 @@
+package moviepoll
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// discordStandIn records the messages posted to it.
+func discordStandIn(t *testing.T) (*httptest.Server, chan discordMessage) {
+	messages := make(chan discordMessage, 10)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := discordMessage{}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("Invalid message: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		messages <- msg
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	return ts, messages
+}
+
+func nextDiscordMessage(t *testing.T, messages chan discordMessage) discordMessage {
+	t.Helper()
+
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for Discord message")
+	}
+	return discordMessage{}
+}
+
+func Test_DiscordAnnouncements(t *testing.T) {
+	ts, messages := discordStandIn(t)
+	defer ts.Close()
+
+	s := newTestServer(t)
+	s.data.SetCfgString(ConfigDiscordWebhook, ts.URL)
+	s.data.SetCfgString(ConfigHostAddress, "https://movies.example.com/")
+
+	user := addTestUser(t, s, "suggester", common.PRIV_USER)
+	movie := &common.Movie{Id: 4, Name: "Suggested", AddedBy: user, Poster: "4.jpg"}
+
+	s.announceDiscord(event{Type: EventMovie, Data: newMovieEvent(movie)})
+	msg := nextDiscordMessage(t, messages)
+	if len(msg.Embeds) != 1 || msg.Embeds[0].Title != "Suggested" || msg.Embeds[0].Url != "https://movies.example.com/movie/4" {
+		t.Fatalf("Unexpected suggestion message: %+v", msg)
+	}
+
+	if msg.Embeds[0].Thumbnail == nil || msg.Embeds[0].Thumbnail.Url != "https://movies.example.com/posters/4.jpg" {
+		t.Errorf("Missing poster on suggestion: %+v", msg.Embeds[0])
+	}
+
+	movie.Votes = []*common.Vote{&common.Vote{User: user}, &common.Vote{User: user}}
+	s.announceDiscord(event{Type: EventCycle, Data: cycleEvent{
+		CycleId: 1,
+		State:   CycleEnded,
+		Winners: []movieEvent{newMovieEvent(movie)},
+	}})
+
+	msg = nextDiscordMessage(t, messages)
+	if len(msg.Embeds) != 1 || msg.Embeds[0].Image == nil {
+		t.Fatalf("Unexpected winner message: %+v", msg)
+	}
+
+	found := false
+	for _, field := range msg.Embeds[0].Fields {
+		if field.Name == "Votes" && field.Value == "2" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("Vote count missing from winner embed: %+v", msg.Embeds[0].Fields)
+	}
+
+	// Other cycle states aren't announced.
+	s.announceDiscord(event{Type: EventCycle, Data: cycleEvent{CycleId: 1, State: CycleEnding}})
+	select {
+	case msg := <-messages:
+		t.Errorf("Unexpected message: %+v", msg)
+	default:
+	}
+}
+
+func Test_DiscordRateLimited(t *testing.T) {
+	ts, messages := discordStandIn(t)
+	defer ts.Close()
+
+	// The first post has to wait for the rate limit
+	limited := false
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limited {
+			limited = true
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	s := newTestServer(t)
+	s.data.SetCfgString(ConfigDiscordWebhook, slow.URL)
+	s.startDiscord()
+
+	user := addTestUser(t, s, "suggester", common.PRIV_USER)
+	movie := &common.Movie{Id: 4, Name: "Suggested", AddedBy: user}
+
+	// A burst of votes while the post waits doesn't push out the other
+	// announcements.
+	s.events.publish(event{Type: EventMovie, Data: newMovieEvent(movie)})
+	for i := 0; i < eventBufferSize*2; i++ {
+		s.events.publish(event{Type: EventVote, Data: voteEvent{MovieId: 4}})
+	}
+	s.events.publish(event{Type: EventCycle, Data: cycleEvent{
+		CycleId: 1,
+		State:   CycleEnded,
+		Winners: []movieEvent{newMovieEvent(movie)},
+	}})
+
+	if msg := nextDiscordMessage(t, messages); msg.Content != "" {
+		t.Errorf("Expected the suggestion first, got %+v", msg)
+	}
+
+	if msg := nextDiscordMessage(t, messages); !strings.HasPrefix(msg.Content, "The votes are in!") {
+		t.Errorf("Expected the winners, got %+v", msg)
+	}
+}
+
+func Test_DiscordReminder(t *testing.T) {
+	ts, messages := discordStandIn(t)
+	defer ts.Close()
+
+	s := newTestServer(t)
+	s.data.SetCfgString(ConfigDiscordWebhook, ts.URL)
+	s.data.SetCfgInt(ConfigDiscordReminder, 60)
+
+	end := time.Now().Add(24 * time.Hour)
+	if _, err := s.data.AddCycle(&end); err != nil {
+		t.Fatal(err)
+	}
+
+	s.checkDiscordReminder(end.Add(-2 * time.Hour))
+	select {
+	case msg := <-messages:
+		t.Fatalf("Reminder sent too early: %+v", msg)
+	default:
+	}
+
+	s.checkDiscordReminder(end.Add(-30 * time.Minute))
+	msg := nextDiscordMessage(t, messages)
+	if len(msg.Embeds) != 1 || !strings.Contains(msg.Embeds[0].Description, "Voting closes") {
+		t.Fatalf("Unexpected reminder: %+v", msg)
+	}
+
+	// Only once per cycle
+	s.checkDiscordReminder(end.Add(-20 * time.Minute))
+	select {
+	case msg := <-messages:
+		t.Errorf("Reminder sent twice: %+v", msg)
+	default:
+	}
+}
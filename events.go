@@ -0,0 +1,324 @@
+package moviepoll
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// Event types
+const (
+	EventVote     string = "vote"     // votes on a movie changed
+	EventMovie    string = "movie"    // a movie was added
+	EventApproved string = "approved" // a movie was approved
+	EventRemoved  string = "removed"  // a movie was removed
+	EventCycle    string = "cycle"    // the state of the cycle changed
+)
+
+// Cycle states sent with EventCycle
+const (
+	CycleStarted string = "started" // a new cycle started, voting is open
+	CycleVoting  string = "voting"  // voting was re-opened
+	CycleEnding  string = "ending"  // voting closed, winners being picked
+	CycleEnded   string = "ended"   // winners have been picked
+)
+
+// Number of events buffered per subscriber.  Subscribers that fall further
+// behind miss events, unless they subscribed with subscribeQueued().
+const eventBufferSize int = 32
+
+// How often a comment is sent to keep idle connections open.
+const eventKeepAlive time.Duration = 30 * time.Second
+
+type event struct {
+	Type string
+	Data interface{}
+}
+
+type voteEvent struct {
+	MovieId int
+	Votes   int
+}
+
+type movieEvent struct {
+	MovieId int
+	Name    string
+	AddedBy string
+	Votes   int
+	Poster  string
+}
+
+type cycleEvent struct {
+	CycleId int
+	State   string
+	Winners []movieEvent `json:",omitempty"`
+}
+
+// eventHub passes events to everything that subscribed to them, eg the SSE
+// endpoint.  Publishing never blocks.
+type eventHub struct {
+	lock *sync.Mutex
+	subs map[chan event]*eventSub
+	l    *common.Logger
+}
+
+type eventSub struct {
+	types []string // empty for every type
+
+	// Queued subscribers get every event.  Events wait in pending until
+	// the subscriber reads them.
+	queued  bool
+	pending []event
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func (sub *eventSub) wants(e event) bool {
+	if len(sub.types) == 0 {
+		return true
+	}
+
+	for _, t := range sub.types {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+func newEventHub(l *common.Logger) *eventHub {
+	return &eventHub{
+		lock: &sync.Mutex{},
+		subs: map[chan event]*eventSub{},
+		l:    l,
+	}
+}
+
+// subscribe returns a channel with every event.  Events are dropped while
+// its buffer is full, which is fine for clients that only show the current
+// state.
+func (h *eventHub) subscribe() chan event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ch := make(chan event, eventBufferSize)
+	h.subs[ch] = &eventSub{}
+	return ch
+}
+
+// subscribeQueued returns a channel with the events of the given types, or
+// of every type if none are given.  No event is ever dropped, they are
+// queued for as long as the subscriber needs to catch up.
+func (h *eventHub) subscribeQueued(types ...string) chan event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ch := make(chan event)
+	sub := &eventSub{
+		types:  types,
+		queued: true,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	h.subs[ch] = sub
+
+	go h.deliver(ch, sub)
+	return ch
+}
+
+// deliver passes the queued events of a subscriber on until it
+// unsubscribes.
+func (h *eventHub) deliver(ch chan event, sub *eventSub) {
+	defer close(ch)
+
+	for {
+		select {
+		case <-sub.wake:
+		case <-sub.done:
+			return
+		}
+
+		h.lock.Lock()
+		pending := sub.pending
+		sub.pending = nil
+		h.lock.Unlock()
+
+		for _, e := range pending {
+			select {
+			case ch <- e:
+			case <-sub.done:
+				return
+			}
+		}
+	}
+}
+
+func (h *eventHub) unsubscribe(ch chan event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	sub, ok := h.subs[ch]
+	if !ok {
+		return
+	}
+
+	delete(h.subs, ch)
+	if sub.queued {
+		// Closed by deliver()
+		close(sub.done)
+	} else {
+		close(ch)
+	}
+}
+
+func (h *eventHub) publish(e event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for ch, sub := range h.subs {
+		if !sub.wants(e) {
+			continue
+		}
+
+		if sub.queued {
+			sub.pending = append(sub.pending, e)
+			select {
+			case sub.wake <- struct{}{}:
+			default:
+				// Already woken up
+			}
+			continue
+		}
+
+		select {
+		case ch <- e:
+		default:
+			// Don't let a slow client hold up everybody else.
+			h.l.Info("Dropped %s event for a slow subscriber", e.Type)
+		}
+	}
+}
+
+// publishVotes sends the current vote count of a movie.
+func (s *Server) publishVotes(movieId int) {
+	movie, err := s.data.GetMovie(movieId)
+	if err != nil {
+		s.l.Error("Unable to get movie for vote event: %v", err)
+		return
+	}
+
+	s.events.publish(event{Type: EventVote, Data: voteEvent{MovieId: movie.Id, Votes: len(movie.Votes)}})
+}
+
+// publishMovie announces a new movie, unless it is still waiting for
+// approval.
+func (s *Server) publishMovie(movieId int) {
+	movie, err := s.data.GetMovie(movieId)
+	if err != nil {
+		s.l.Error("Unable to get movie for movie event: %v", err)
+		return
+	}
+
+	approval, err := s.data.GetCfgBool(ConfigEntriesRequireApproval, DefaultEntriesRequireApproval)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigEntriesRequireApproval, err)
+		return
+	}
+
+	if approval && !movie.Approved {
+		return
+	}
+
+	s.events.publish(event{Type: EventMovie, Data: newMovieEvent(movie)})
+}
+
+// publishMovieChange announces that a movie was approved or removed.
+func (s *Server) publishMovieChange(evType string, movie *common.Movie) {
+	s.events.publish(event{Type: evType, Data: newMovieEvent(movie)})
+}
+
+// publishCycle announces a change in the state of a cycle.  Winners are only
+// included once the cycle has ended.
+func (s *Server) publishCycle(cycle *common.Cycle, state string, winners []*common.Movie) {
+	ev := cycleEvent{State: state}
+	if cycle != nil {
+		ev.CycleId = cycle.Id
+	}
+
+	for _, movie := range winners {
+		ev.Winners = append(ev.Winners, newMovieEvent(movie))
+	}
+
+	s.events.publish(event{Type: EventCycle, Data: ev})
+}
+
+func newMovieEvent(movie *common.Movie) movieEvent {
+	ev := movieEvent{
+		MovieId: movie.Id,
+		Name:    movie.Name,
+		Votes:   len(movie.Votes),
+		Poster:  movie.Poster,
+	}
+
+	if movie.AddedBy != nil {
+		ev.AddedBy = movie.AddedBy.Name
+	}
+	return ev
+}
+
+// handlerEvents streams events to the browser as Server-Sent Events.
+func (s *Server) handlerEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.doError(http.StatusInternalServerError, "Streaming not supported", w, r)
+		return
+	}
+
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Tell nginx not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			raw, err := json.Marshal(ev.Data)
+			if err != nil {
+				s.l.Error("Unable to marshal %s event: %v", ev.Type, err)
+				continue
+			}
+
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, raw); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package moviepoll
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_EventHub(t *testing.T) {
+	hub := newEventHub(testLog)
+	a := hub.subscribe()
+	b := hub.subscribe()
+
+	hub.publish(event{Type: EventVote, Data: voteEvent{MovieId: 1, Votes: 2}})
+	for _, ch := range []chan event{a, b} {
+		select {
+		case ev := <-ch:
+			if ev.Type != EventVote {
+				t.Errorf("Expected vote event, got %q", ev.Type)
+			}
+		default:
+			t.Errorf("Subscriber did not receive event")
+		}
+	}
+
+	hub.unsubscribe(a)
+	if _, ok := <-a; ok {
+		t.Errorf("Channel not closed after unsubscribe")
+	}
+
+	// A subscriber that doesn't read must not block publishing.
+	for i := 0; i < eventBufferSize*2; i++ {
+		hub.publish(event{Type: EventVote})
+	}
+}
+
+func Test_EventStream(t *testing.T) {
+	s, user, movieId := setupVoteTest(t)
+	cookies := loginCookies(t, s, user)
+
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Wrong content type: %q", ct)
+	}
+
+	// Wait for the handler to subscribe before voting.
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, "retry:") {
+		t.Fatalf("Expected retry line, got %q (%v)", line, err)
+	}
+
+	rec := postForm(s, fmt.Sprintf("/vote/%d", movieId), url.Values{"CsrfToken": {csrfTokenFor(t, s, cookies)}}, cookies)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Vote failed: %d", rec.Code)
+	}
+
+	lines := make(chan string)
+	go func() {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- strings.TrimSpace(line)
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	evType := ""
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("Stream closed before vote event")
+			}
+
+			if strings.HasPrefix(line, "event: ") {
+				evType = strings.TrimPrefix(line, "event: ")
+				continue
+			}
+
+			if !strings.HasPrefix(line, "data: ") || evType != EventVote {
+				continue
+			}
+
+			ev := voteEvent{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+				t.Fatal(err)
+			}
+
+			if ev.MovieId != movieId || ev.Votes != 1 {
+				t.Errorf("Unexpected vote event: %+v", ev)
+			}
+			return
+
+		case <-timeout:
+			t.Fatalf("Timed out waiting for vote event")
+		}
+	}
+}
+
+func Test_VoteJson(t *testing.T) {
+	s, user, movieId := setupVoteTest(t)
+	cookies := loginCookies(t, s, user)
+
+	vote := func() voteResponse {
+		form := url.Values{"CsrfToken": {csrfTokenFor(t, s, cookies)}}
+		req := httptest.NewRequest("POST", fmt.Sprintf("/vote/%d", movieId), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		addCookies(req, cookies)
+
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected OK, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		resp := voteResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := vote(); !resp.Voted || resp.Votes != 1 {
+		t.Errorf("Unexpected response after voting: %+v", resp)
+	}
+
+	if resp := vote(); resp.Voted || resp.Votes != 0 {
+		t.Errorf("Unexpected response after removing vote: %+v", resp)
+	}
+}
+
+func Test_EventHubQueued(t *testing.T) {
+	hub := newEventHub(testLog)
+	ch := hub.subscribeQueued(EventMovie)
+
+	// Nothing is dropped while the subscriber isn't reading
+	count := eventBufferSize * 4
+	for i := 0; i < count; i++ {
+		hub.publish(event{Type: EventVote})
+		hub.publish(event{Type: EventMovie, Data: movieEvent{MovieId: i}})
+	}
+
+	for i := 0; i < count; i++ {
+		select {
+		case ev := <-ch:
+			if ev.Type != EventMovie {
+				t.Fatalf("Expected only movie events, got %q", ev.Type)
+			}
+			if id := ev.Data.(movieEvent).MovieId; id != i {
+				t.Fatalf("Expected movie %d, got %d", i, id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event %d", i)
+		}
+	}
+
+	hub.unsubscribe(ch)
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("Unexpected event after unsubscribe")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Channel not closed after unsubscribe")
+	}
+}
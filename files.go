@@ -0,0 +1,118 @@
+package moviepoll
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// The default templates and static files are built into the binary.
+//
+//go:embed templates static
+var embeddedFiles embed.FS
+
+// layeredFS looks for files in each layer in turn, so earlier layers
+// override single files of later ones.  Directory listings are merged.
+type layeredFS []fs.FS
+
+func (l layeredFS) Open(name string) (fs.File, error) {
+	for _, layer := range l {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (l layeredFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	found := false
+	seen := map[string]bool{}
+	entries := []fs.DirEntry{}
+
+	for _, layer := range l {
+		list, err := fs.ReadDir(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		found = true
+		for _, entry := range list {
+			if !seen[entry.Name()] {
+				seen[entry.Name()] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// newServerFiles returns the templates and static files.  Files in the
+// overrides directory replace the built in ones, eg overrides/templates/cycle.html
+// replaces the cycle template.  While debugging, files are read from the
+// working directory first so changes show up without a rebuild.
+func newServerFiles(overrides string, debug bool) fs.FS {
+	layers := layeredFS{}
+	if overrides != "" {
+		layers = append(layers, os.DirFS(overrides))
+	}
+
+	if debug {
+		layers = append(layers, os.DirFS("."))
+	}
+
+	return append(layers, embeddedFiles)
+}
+
+// serveFile writes a file from the server's files.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := s.files.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	serveReader(w, r, name, f)
+}
+
+// serveReader writes content with http.ServeContent, which needs to seek.
+// Content that can't seek is read into memory first.
+func serveReader(w http.ResponseWriter, r *http.Request, name string, content io.Reader) {
+	rs, ok := content.(io.ReadSeeker)
+	if !ok {
+		raw, err := ioutil.ReadAll(content)
+		if err != nil {
+			http.Error(w, "Unable to read file", http.StatusInternalServerError)
+			return
+		}
+		rs = bytes.NewReader(raw)
+	}
+
+	http.ServeContent(w, r, name, time.Time{}, rs)
+}
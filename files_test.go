@@ -0,0 +1,72 @@
+package moviepoll
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_FileOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir(testDir, "overrides-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"templates/history.html": `{{define "header"}}{{end}}{{define "body"}}Custom history page{{end}}`,
+		"static/css/custom.css":  `body { background: url('/static/img/5ZuS5bs.jpg'); }`,
+	}
+
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newTestServer(t)
+	s.files = newServerFiles(dir, false)
+	if err = s.registerTemplates(); err != nil {
+		t.Fatalf("Unable to register templates: %v", err)
+	}
+
+	if s.assets, err = loadAssets(s.files); err != nil {
+		t.Fatalf("Unable to load assets: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.handlerHistory(w, httptest.NewRequest("GET", "/history", nil))
+	if !strings.Contains(w.Body.String(), "Custom history page") {
+		t.Errorf("History template was not replaced:\n%s", w.Body.String())
+	}
+
+	// Other templates are still built in
+	w = httptest.NewRecorder()
+	s.handlerRoot(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), s.assetUrl("css/site.css")) {
+		t.Errorf("Cycle page is missing the built in stylesheet")
+	}
+
+	// Added and built in static files are listed together
+	custom := s.assetUrl("css/custom.css")
+	if custom == "/static/css/custom.css" || s.assetUrl("css/site.css") == "/static/css/site.css" {
+		t.Fatalf("Static files were not all loaded: %q", custom)
+	}
+
+	if !strings.Contains(string(s.assets.byUrl[custom].Data), s.assetUrl("img/5ZuS5bs.jpg")) {
+		t.Errorf("Custom stylesheet does not reference the built in image")
+	}
+
+	w = httptest.NewRecorder()
+	s.handlerStatic(w, httptest.NewRequest("GET", "/static/../templates/base.html", nil))
+	if w.Code != 404 {
+		t.Errorf("Templates should not be served as static files, got %d", w.Code)
+	}
+}
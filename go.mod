@@ -1,11 +1,15 @@
 module github.com/zorchenhimer/MoviePolls
 
-go 1.13
+go 1.16
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/go-sql-driver/mysql v1.4.1
 	github.com/gorilla/sessions v1.2.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/mitchellh/mapstructure v1.3.3
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/rivo/uniseg v0.1.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/image v0.0.0-20200927104501-e162460cd6b5
 )
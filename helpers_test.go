@@ -0,0 +1,199 @@
+package moviepoll
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/zorchenhimer/MoviePolls/common"
+	mpd "github.com/zorchenhimer/MoviePolls/data"
+	"github.com/zorchenhimer/MoviePolls/storage"
+)
+
+/*
+	Helper functions used in tests
+*/
+
+var (
+	testDir string
+	testLog *common.Logger
+	dbCount int
+)
+
+// Tests run in a temporary directory so the data files don't end up in the
+// source tree.  Templates and static files are built in.
+func TestMain(m *testing.M) {
+	var err error
+	testLog, err = common.NewLogger(common.LLError, "")
+	if err != nil {
+		fmt.Println("Error getting logger for tests: ", err.Error())
+		os.Exit(1)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	testDir, err = ioutil.TempDir("", "moviepolls-test-")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err = os.Chdir(testDir); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	retval := m.Run()
+
+	os.Chdir(wd)
+	os.RemoveAll(testDir)
+	os.Exit(retval)
+}
+
+var testAssets *assetCache
+
+// newTestServer returns a server backed by a fresh json data file.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	dbCount++
+	data, err := mpd.GetDataConnector("json", filepath.Join(testDir, fmt.Sprintf("test-%d.json", dbCount)), testLog)
+	if err != nil {
+		t.Fatalf("Unable to create data connector: %v", err)
+	}
+
+	// Compressing the assets is slow, they are shared between servers
+	if testAssets == nil {
+		if testAssets, err = loadAssets(embeddedFiles); err != nil {
+			t.Fatalf("Unable to load assets: %v", err)
+		}
+	}
+
+	s := &Server{
+		data:         data,
+		cookies:      sessions.NewCookieStore([]byte(getCryptRandKey(64)), []byte(getCryptRandKey(32))),
+		passwordSalt: getCryptRandKey(32),
+		files:        newServerFiles("", false),
+		l:            testLog,
+		urlKeys:      make(map[string]*common.UrlKey),
+
+		oidcLock: &sync.Mutex{},
+
+		loginLimits: newLoginLimiter(),
+		events:      newEventHub(testLog),
+		webhookLog:  newWebhookLog(),
+		search:      newSearchIndex(),
+		posters:     storage.NewLocal(posterDir, "/posters/"),
+		assets:      testAssets,
+
+		brandingFiles: storage.NewLocal(brandingDir, "/branding/"),
+	}
+
+	if err = s.registerTemplates(); err != nil {
+		t.Fatalf("Unable to register templates: %v", err)
+	}
+
+	return s
+}
+
+// addTestUser adds a user with the password "password".
+func addTestUser(t *testing.T, s *Server, name string, priv common.PrivilegeLevel) *common.User {
+	t.Helper()
+
+	user := &common.User{
+		Name:      name,
+		Password:  s.hashPassword("password"),
+		Privilege: priv,
+	}
+
+	if _, err := s.data.AddUser(user); err != nil {
+		t.Fatalf("Unable to add user: %v", err)
+	}
+	return user
+}
+
+// deleteTestUser deletes the user through the admin page and returns what is
+// left of the account.
+func deleteTestUser(t *testing.T, s *Server, user *common.User) *common.User {
+	t.Helper()
+
+	admin := addTestUser(t, s, fmt.Sprintf("admin%d", user.Id), common.PRIV_ADMIN)
+	cookies := loginCookies(t, s, admin)
+	form := url.Values{"confirm": {"yes"}, "CsrfToken": {csrfTokenFor(t, s, cookies)}}
+
+	rec := postForm(s, fmt.Sprintf("/admin/user/%d?action=delete", user.Id), form, cookies)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unable to delete user: %d", rec.Code)
+	}
+
+	deleted, err := s.data.GetUser(user.Id)
+	if err != nil {
+		t.Fatalf("Unable to get deleted user: %v", err)
+	}
+	return deleted
+}
+
+// loginCookies returns the session cookies for a logged in user.
+func loginCookies(t *testing.T, s *Server, user *common.User) []*http.Cookie {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	if err := s.login(user, rec, req); err != nil {
+		t.Fatalf("Unable to login: %v", err)
+	}
+	return rec.Result().Cookies()
+}
+
+// csrfTokenFor returns the CSRF token stored in the session cookies.
+func csrfTokenFor(t *testing.T, s *Server, cookies []*http.Cookie) string {
+	t.Helper()
+
+	session, err := s.cookies.Get(addCookies(httptest.NewRequest("GET", "/", nil), cookies), SessionName)
+	if err != nil {
+		t.Fatalf("Unable to get session: %v", err)
+	}
+
+	token, _ := session.Values["CsrfToken"].(string)
+	if token == "" {
+		t.Fatalf("Session does not have a CSRF token")
+	}
+	return token
+}
+
+// addCookies adds the given cookies to a request, replacing any cookies with
+// the same name.  If a name is set more than once the last one wins, like in a
+// browser.
+func addCookies(req *http.Request, cookies []*http.Cookie) *http.Request {
+	replaced := map[string]*http.Cookie{}
+	for _, c := range cookies {
+		replaced[c.Name] = c
+	}
+
+	existing := req.Cookies()
+	req.Header.Del("Cookie")
+
+	for _, c := range existing {
+		if replaced[c.Name] == nil {
+			req.AddCookie(c)
+		}
+	}
+
+	for _, c := range cookies {
+		if replaced[c.Name] == c {
+			req.AddCookie(c)
+		}
+	}
+	return req
+}
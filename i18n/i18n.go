@@ -0,0 +1,201 @@
+// Package i18n holds the translations of the web interface.
+//
+// Messages are looked up by their English text, so a missing translation
+// shows the English message instead of a key.  Each language is a JSON file
+// in the locales directory.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Locale is the messages and date formats of a language.
+type Locale struct {
+	Tag  string // eg "de"
+	Name string // name of the language in the language itself, eg "Deutsch"
+
+	// Go time layouts.  Day and month names are replaced by the ones below.
+	ShortDateLayout string
+	LongDateLayout  string
+	DateTimeLayout  string
+
+	Days        [7]string // starting on Sunday
+	ShortDays   [7]string
+	Months      [12]string
+	ShortMonths [12]string
+
+	Messages map[string]string
+}
+
+var (
+	locales = map[string]*Locale{}
+
+	// Default is used when nothing else matches.
+	Default *Locale
+)
+
+func init() {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, entry := range entries {
+		raw, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		l := &Locale{}
+		if err = json.Unmarshal(raw, l); err != nil {
+			panic(fmt.Sprintf("Unable to parse %s: %v", entry.Name(), err))
+		}
+		locales[l.Tag] = l
+	}
+
+	Default = locales["en"]
+}
+
+// Get returns the locale with the given tag, or nil if there isn't one.
+func Get(tag string) *Locale {
+	return locales[strings.ToLower(tag)]
+}
+
+// Locales returns every locale, sorted by tag.
+func Locales() []*Locale {
+	list := []*Locale{}
+	for _, l := range locales {
+		list = append(list, l)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Tag < list[j].Tag })
+	return list
+}
+
+// Negotiate picks the locale best matching an Accept-Language header.
+// Regions are ignored, "de-AT" matches "de".
+func Negotiate(header string) *Locale {
+	var best *Locale
+	bestQ := 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if idx := strings.Index(tag, "-"); idx != -1 {
+			tag = tag[:idx]
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if val, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = val
+				}
+			}
+		}
+
+		if l := Get(tag); l != nil && q > bestQ {
+			best = l
+			bestQ = q
+		}
+	}
+
+	if best == nil {
+		return Default
+	}
+	return best
+}
+
+// T translates a message.  Arguments are formatted into the translation
+// with fmt.Sprintf.
+func (l *Locale) T(msg string, args ...interface{}) string {
+	if translated, ok := l.Messages[msg]; ok && translated != "" {
+		msg = translated
+	}
+
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// Format formats a time like time.Format, with the day and month names of
+// the locale.
+func (l *Locale) Format(t time.Time, layout string) string {
+	names := []struct {
+		token string
+		name  string
+	}{
+		// Longer tokens first, "Mon" is the start of "Monday"
+		{"Monday", l.Days[t.Weekday()]},
+		{"Mon", l.ShortDays[t.Weekday()]},
+		{"January", l.Months[t.Month()-1]},
+		{"Jan", l.ShortMonths[t.Month()-1]},
+	}
+
+	sb := strings.Builder{}
+	for layout != "" {
+		found := false
+		for _, n := range names {
+			if strings.HasPrefix(layout, n.token) {
+				sb.WriteString(n.name)
+				layout = layout[len(n.token):]
+				found = true
+				break
+			}
+		}
+
+		if found {
+			continue
+		}
+
+		// Everything up to the next name is a normal layout
+		next := len(layout)
+		for _, n := range names {
+			if idx := strings.Index(layout[1:], n.token); idx != -1 && idx+1 < next {
+				next = idx + 1
+			}
+		}
+
+		sb.WriteString(t.Format(layout[:next]))
+		layout = layout[next:]
+	}
+
+	return sb.String()
+}
+
+// ShortDate formats a date without the year.  Nil times are empty.
+func (l *Locale) ShortDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return l.Format(*t, l.ShortDateLayout)
+}
+
+// LongDate formats a date with the year.  Nil times are empty.
+func (l *Locale) LongDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return l.Format(*t, l.LongDateLayout)
+}
+
+// DateTime formats a date with the time of day and zone.  Nil times are
+// empty.
+func (l *Locale) DateTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return l.Format(*t, l.DateTimeLayout)
+}
@@ -0,0 +1,102 @@
+package i18n
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Negotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                         "en",
+		"de":                       "de",
+		"de-AT,de;q=0.9,en;q=0.8":  "de",
+		"en-US,en;q=0.9,de;q=0.8":  "en",
+		"fr-FR,fr;q=0.9,de;q=0.5":  "de",
+		"fr":                       "en",
+		"en;q=0.2, DE-de;q=0.7":    "de",
+		"de;q=0,en;q=0.1":          "en",
+		"garbage;;;q=,,de;q=nope ": "de",
+	}
+
+	for header, expected := range tests {
+		if tag := Negotiate(header).Tag; tag != expected {
+			t.Errorf("Negotiate(%q) returned %q, expected %q", header, tag, expected)
+		}
+	}
+}
+
+func Test_Translate(t *testing.T) {
+	de := Get("de")
+	if de == nil {
+		t.Fatal("German locale is missing")
+	}
+
+	if msg := de.T("Vote"); msg != "Abstimmen" {
+		t.Errorf("Unexpected translation %q", msg)
+	}
+
+	if msg := de.T("Login with %s", "Example"); msg != "Mit Example anmelden" {
+		t.Errorf("Unexpected translation %q", msg)
+	}
+
+	// Missing translations fall back to the message itself
+	if msg := de.T("Not translated %d", 3); msg != "Not translated 3" {
+		t.Errorf("Unexpected fallback %q", msg)
+	}
+}
+
+func Test_DateFormat(t *testing.T) {
+	date := time.Date(2021, time.March, 1, 20, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		tag      string
+		short    string
+		long     string
+		dateTime string
+	}{
+		{"en", "Mon Mar 1", "Mon Mar 1, 2021", "Mon Mar 1, 2021 8:00 PM UTC"},
+		{"de", "Mo, 1. März", "Mo, 1. März 2021", "Mo, 1. März 2021, 20:00 UTC"},
+	}
+
+	for _, tst := range tests {
+		l := Get(tst.tag)
+		if s := l.ShortDate(&date); s != tst.short {
+			t.Errorf("[%s] Unexpected short date %q", tst.tag, s)
+		}
+		if s := l.LongDate(&date); s != tst.long {
+			t.Errorf("[%s] Unexpected long date %q", tst.tag, s)
+		}
+		if s := l.DateTime(&date); s != tst.dateTime {
+			t.Errorf("[%s] Unexpected date and time %q", tst.tag, s)
+		}
+	}
+
+	if s := Get("de").Format(date, "Monday, January 2 15:04"); s != "Montag, März 1 20:00" {
+		t.Errorf("Unexpected full date %q", s)
+	}
+
+	if s := Default.LongDate(nil); s != "" {
+		t.Errorf("Nil date formatted as %q", s)
+	}
+}
+
+var re_verb = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+// Translations must take the same arguments as the English message.
+func Test_FormatVerbs(t *testing.T) {
+	for _, l := range Locales() {
+		for msg, translated := range l.Messages {
+			expected := re_verb.FindAllString(msg, -1)
+			found := re_verb.FindAllString(translated, -1)
+			sort.Strings(expected)
+			sort.Strings(found)
+
+			if strings.Join(expected, " ") != strings.Join(found, " ") {
+				t.Errorf("[%s] %q has verbs %v, expected %v", l.Tag, translated, found, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package moviepoll
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/i18n"
+)
+
+func getPage(s *Server, path, language string, cookies []*http.Cookie) string {
+	req := addCookies(httptest.NewRequest("GET", path, nil), cookies)
+	if language != "" {
+		req.Header.Set("Accept-Language", language)
+	}
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func Test_LocaleNegotiation(t *testing.T) {
+	s, user, _ := setupVoteTest(t)
+	cookies := loginCookies(t, s, user)
+
+	page := getPage(s, "/", "de-DE,de;q=0.9,en;q=0.8", cookies)
+	for _, expected := range []string{`<html lang="de">`, "Verlauf", "Abstimmen", "Aktuelle Runde"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("German page is missing %q", expected)
+		}
+	}
+
+	page = getPage(s, "/", "", cookies)
+	if !strings.Contains(page, `<html lang="en">`) || !strings.Contains(page, ">Vote<") {
+		t.Errorf("Page without Accept-Language is not English")
+	}
+
+	// The language picked on the account page wins over the browser's
+	form := url.Values{
+		"CsrfToken": {csrfTokenFor(t, s, cookies)},
+		"Form":      {"Language"},
+		"Locale":    {"en"},
+	}
+	body := postForm(s, "/user", form, cookies).Body.String()
+	if !strings.Contains(body, "Language changed") {
+		t.Fatalf("Language was not changed:\n%s", body)
+	}
+
+	page = getPage(s, "/", "de", cookies)
+	if !strings.Contains(page, `<html lang="en">`) {
+		t.Errorf("User preference was ignored")
+	}
+
+	if u, _ := s.data.GetUser(user.Id); u.Locale != "en" {
+		t.Errorf("Unexpected locale %q", u.Locale)
+	}
+
+	form.Set("Locale", "xx")
+	if rec := postForm(s, "/user", form, cookies); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown language to be rejected, got %d", rec.Code)
+	}
+}
+
+func Test_LocaleErrors(t *testing.T) {
+	s, user, _ := setupVoteTest(t)
+	s.data.SetCfgBool(ConfigVotingEnabled, false)
+
+	id, err := s.data.AddMovie(&common.Movie{Name: "Another", AddedBy: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Locale = "de"
+	if err = s.data.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	cookies := loginCookies(t, s, user)
+
+	form := url.Values{"CsrfToken": {csrfTokenFor(t, s, cookies)}}
+	body := postForm(s, "/vote/"+strconv.Itoa(id), form, cookies).Body.String()
+	if !strings.Contains(body, "Abstimmen ist nicht aktiviert") {
+		t.Errorf("Vote error was not translated:\n%s", body)
+	}
+}
+
+var re_templateMessage = regexp.MustCompile(`\$?\.T "((?:[^"\\]|\\.)*)"`)
+
+// Every message in the templates should have a German translation.
+func Test_LocaleTemplateMessages(t *testing.T) {
+	de := i18n.Get("de")
+
+	err := fs.WalkDir(embeddedFiles, "templates", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		raw, err := fs.ReadFile(embeddedFiles, name)
+		if err != nil {
+			return err
+		}
+
+		for _, match := range re_templateMessage.FindAllStringSubmatch(string(raw), -1) {
+			if _, ok := de.Messages[match[1]]; !ok {
+				t.Errorf("[%s] %q is not translated", name, match[1])
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,327 @@
+package moviepoll
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of failures allowed before backoff kicks in.
+const loginFreeAttempts int = 3
+
+// Backoff starts at this delay and doubles for every failure after the free
+// attempts.
+const loginBaseDelay time.Duration = 2 * time.Second
+
+// Maximum number of lockout notices kept for the admin page.
+const loginMaxNotices int = 50
+
+type loginAttempts struct {
+	Failures    int
+	Last        time.Time
+	LockedUntil time.Time
+
+	// Addresses that failed against an account.  Nil for address entries.
+	Addrs map[string]bool
+}
+
+// A loginNotice is shown to admins when an account or address gets locked
+// out.
+type loginNotice struct {
+	Time     time.Time
+	Account  string // empty for address lockouts
+	Address  string
+	Failures int
+}
+
+// loginLimiter tracks failed logins per account name and per client address.
+// Failures past loginFreeAttempts have to wait an exponentially growing delay
+// before the next attempt, and too many failures lock the account or address
+// out for a while.  Everything is kept in memory and resets on restart.
+type loginLimiter struct {
+	lock *sync.Mutex
+
+	accounts map[string]*loginAttempts
+	addrs    map[string]*loginAttempts
+	notices  []loginNotice
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{
+		lock:     &sync.Mutex{},
+		accounts: map[string]*loginAttempts{},
+		addrs:    map[string]*loginAttempts{},
+		notices:  []loginNotice{},
+	}
+}
+
+// wait returns how long the client has to wait before trying again.
+func (a *loginAttempts) wait(now time.Time, maxDelay time.Duration) time.Duration {
+	if a == nil {
+		return 0
+	}
+
+	if a.LockedUntil.After(now) {
+		return a.LockedUntil.Sub(now)
+	}
+
+	if a.Failures < loginFreeAttempts {
+		return 0
+	}
+
+	exp := float64(a.Failures - loginFreeAttempts)
+	delay := time.Duration(float64(loginBaseDelay) * math.Pow(2, exp))
+	if delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+
+	if wait := a.Last.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// check returns how long a login attempt for the given account from the given
+// address has to wait.  Zero means the attempt is allowed.
+func (ll *loginLimiter) check(account, addr string, lockout time.Duration) time.Duration {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	now := time.Now()
+	ll.expire(now, lockout)
+
+	wait := ll.accounts[strings.ToLower(account)].wait(now, lockout)
+	if w := ll.addrs[addr].wait(now, lockout); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+// fail records a failed attempt.  It returns true if the account or address
+// has been locked out by this attempt.
+func (ll *loginLimiter) fail(account, addr string, maxFailures int, lockout time.Duration) bool {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	now := time.Now()
+	account = strings.ToLower(account)
+	locked := false
+
+	acct, ok := ll.accounts[account]
+	if !ok {
+		acct = &loginAttempts{Addrs: map[string]bool{}}
+		ll.accounts[account] = acct
+	}
+	acct.Failures++
+	acct.Last = now
+	acct.Addrs[addr] = true
+
+	if maxFailures > 0 && acct.Failures >= maxFailures && !acct.LockedUntil.After(now) {
+		acct.LockedUntil = now.Add(lockout)
+		ll.notify(loginNotice{Time: now, Account: account, Address: addr, Failures: acct.Failures})
+		locked = true
+	}
+
+	ip, ok := ll.addrs[addr]
+	if !ok {
+		ip = &loginAttempts{}
+		ll.addrs[addr] = ip
+	}
+	ip.Failures++
+	ip.Last = now
+
+	// Addresses get more slack than accounts so a handful of users behind a
+	// single NAT don't lock each other out.
+	if maxFailures > 0 && ip.Failures >= maxFailures*3 && !ip.LockedUntil.After(now) {
+		ip.LockedUntil = now.Add(lockout)
+		ll.notify(loginNotice{Time: now, Address: addr, Failures: ip.Failures})
+		locked = true
+	}
+
+	return locked
+}
+
+// success clears the failures of an account.  Failures of the address it
+// logged in from are kept until they expire, otherwise logging into an own
+// account every few attempts would get around the address lockout.
+func (ll *loginLimiter) success(account string) {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	delete(ll.accounts, strings.ToLower(account))
+}
+
+// clear removes the failures and lockout of an account, along with the
+// addresses that failed against it.
+func (ll *loginLimiter) clear(account string) {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	account = strings.ToLower(account)
+	if acct, ok := ll.accounts[account]; ok {
+		for addr := range acct.Addrs {
+			delete(ll.addrs, addr)
+		}
+	}
+	delete(ll.accounts, account)
+
+	notices := []loginNotice{}
+	for _, n := range ll.notices {
+		if n.Account != account {
+			notices = append(notices, n)
+		}
+	}
+	ll.notices = notices
+}
+
+// status returns the current failure count and lockout of an account.
+func (ll *loginLimiter) status(account string) (int, time.Time) {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	acct, ok := ll.accounts[strings.ToLower(account)]
+	if !ok {
+		return 0, time.Time{}
+	}
+	return acct.Failures, acct.LockedUntil
+}
+
+// getNotices returns lockout notices, newest first.
+func (ll *loginLimiter) getNotices() []loginNotice {
+	ll.lock.Lock()
+	defer ll.lock.Unlock()
+
+	notices := make([]loginNotice, len(ll.notices))
+	copy(notices, ll.notices)
+	sort.Slice(notices, func(i, j int) bool { return notices[i].Time.After(notices[j].Time) })
+	return notices
+}
+
+func (ll *loginLimiter) notify(n loginNotice) {
+	ll.notices = append(ll.notices, n)
+	if len(ll.notices) > loginMaxNotices {
+		ll.notices = ll.notices[len(ll.notices)-loginMaxNotices:]
+	}
+}
+
+// expire forgets entries that haven't failed within the lockout window.
+// Must be called with the lock held.
+func (ll *loginLimiter) expire(now time.Time, lockout time.Duration) {
+	for key, a := range ll.accounts {
+		if now.Sub(a.Last) > lockout && !a.LockedUntil.After(now) {
+			delete(ll.accounts, key)
+		}
+	}
+
+	for key, a := range ll.addrs {
+		if now.Sub(a.Last) > lockout && !a.LockedUntil.After(now) {
+			delete(ll.addrs, key)
+		}
+	}
+}
+
+// getLoginLimits returns the number of failures before a lockout and the
+// length of a lockout.
+func (s *Server) getLoginLimits() (int, time.Duration) {
+	maxFailures, err := s.data.GetCfgInt(ConfigLoginMaxFailures, DefaultLoginMaxFailures)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigLoginMaxFailures, err)
+		maxFailures = DefaultLoginMaxFailures
+	}
+
+	lockout, err := s.data.GetCfgInt(ConfigLoginLockout, DefaultLoginLockout)
+	if err != nil || lockout < 1 {
+		s.l.Error("Unable to get %s: %v", ConfigLoginLockout, err)
+		lockout = DefaultLoginLockout
+	}
+
+	return maxFailures, time.Duration(lockout) * time.Minute
+}
+
+// clientAddr returns the address of the client making the request.  The
+// X-Forwarded-For header is only honored when the request comes from one of
+// the proxies listed in the TrustedProxies setting.
+func (s *Server) clientAddr(r *http.Request) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+
+	proxies, err := s.data.GetCfgString(ConfigTrustedProxies, DefaultTrustedProxies)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigTrustedProxies, err)
+		return addr
+	}
+
+	trusted := parseTrustedProxies(proxies)
+	if len(trusted) == 0 || !isTrustedProxy(addr, trusted) {
+		return addr
+	}
+
+	// Walk the header from the right, skipping our own proxies.  The first
+	// untrusted address is the client.
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		addr = hop
+		if !isTrustedProxy(hop, trusted) {
+			break
+		}
+	}
+
+	return addr
+}
+
+// parseTrustedProxies parses a comma separated list of addresses and CIDR
+// ranges.  Invalid entries are ignored.
+func parseTrustedProxies(list string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				if ip.To4() != nil {
+					entry += "/32"
+				} else {
+					entry += "/128"
+				}
+			}
+		}
+
+		_, n, err := net.ParseCIDR(entry)
+		if err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+func isTrustedProxy(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
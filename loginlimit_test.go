@@ -0,0 +1,145 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+func Test_LoginLimiter(t *testing.T) {
+	ll := newLoginLimiter()
+	lockout := 15 * time.Minute
+
+	for i := 0; i < loginFreeAttempts; i++ {
+		if wait := ll.check("User", "10.0.0.1", lockout); wait != 0 {
+			t.Fatalf("Attempt %d should not have to wait, got %s", i, wait)
+		}
+		ll.fail("User", "10.0.0.1", 5, lockout)
+	}
+
+	// Names are case insensitive and backoff applies from other addresses.
+	if wait := ll.check("user", "10.0.0.2", lockout); wait <= 0 || wait > loginBaseDelay {
+		t.Errorf("Expected backoff of at most %s, got %s", loginBaseDelay, wait)
+	}
+
+	ll.fail("user", "10.0.0.2", 5, lockout)
+	if locked := ll.fail("user", "10.0.0.2", 5, lockout); !locked {
+		t.Fatalf("Account should be locked after 5 failures")
+	}
+
+	if wait := ll.check("user", "10.0.0.3", lockout); wait < lockout-time.Second {
+		t.Errorf("Expected lockout of %s, got %s", lockout, wait)
+	}
+
+	notices := ll.getNotices()
+	if len(notices) != 1 || notices[0].Account != "user" {
+		t.Errorf("Expected a single lockout notice, got %v", notices)
+	}
+
+	ll.clear("USER")
+	if wait := ll.check("user", "10.0.0.1", lockout); wait != 0 {
+		t.Errorf("Lockout was not cleared, wait %s", wait)
+	}
+
+	if len(ll.getNotices()) != 0 {
+		t.Errorf("Notices were not cleared")
+	}
+}
+
+func Test_LoginLimiterSuccess(t *testing.T) {
+	ll := newLoginLimiter()
+	lockout := 15 * time.Minute
+	maxFailures := 2
+
+	// Guessing against other accounts while logging into an own account in
+	// between still locks out the address.
+	locked := false
+	for i := 0; i < maxFailures*3 && !locked; i++ {
+		locked = ll.fail(fmt.Sprintf("victim%d", i), "10.0.0.1", maxFailures, lockout)
+		ll.success("attacker")
+	}
+
+	if !locked {
+		t.Fatalf("Address was not locked out")
+	}
+
+	if wait := ll.check("attacker", "10.0.0.1", lockout); wait < lockout-time.Second {
+		t.Errorf("Expected address lockout of %s, got %s", lockout, wait)
+	}
+
+	// The account is still cleared
+	ll.fail("attacker", "10.0.0.2", maxFailures, lockout)
+	ll.success("attacker")
+	if failures, _ := ll.status("attacker"); failures != 0 {
+		t.Errorf("Account failures were not cleared: %d", failures)
+	}
+}
+
+func Test_LoginLockout(t *testing.T) {
+	s := newTestServer(t)
+	addTestUser(t, s, "victim", common.PRIV_USER)
+	s.data.SetCfgInt(ConfigLoginMaxFailures, 2)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"Username": {"victim"}, "Password": {password}}
+		req := httptest.NewRequest("POST", "/user/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec := httptest.NewRecorder()
+		s.handlerUserLogin(rec, req)
+		return rec
+	}
+
+	login("wrong")
+	login("wrong")
+
+	rec := login("password")
+	if rec.Code == http.StatusFound {
+		t.Fatalf("Locked out account was able to login")
+	}
+
+	if !strings.Contains(rec.Body.String(), "Too many failed login attempts") {
+		t.Errorf("Lockout message missing from login page")
+	}
+
+	s.loginLimits.clear("victim")
+	if rec = login("password"); rec.Code != http.StatusFound {
+		t.Errorf("Expected login after clearing the lockout, got %d", rec.Code)
+	}
+}
+
+func Test_ClientAddr(t *testing.T) {
+	s := newTestServer(t)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.168.1.10:4567"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.2")
+
+	// No trusted proxies, header is ignored.
+	if addr := s.clientAddr(req); addr != "192.168.1.10" {
+		t.Errorf("Expected remote address without trusted proxies, got %s", addr)
+	}
+
+	// Only the local proxy is trusted, so the next hop is the client.
+	s.data.SetCfgString(ConfigTrustedProxies, "192.168.1.0/24")
+	if addr := s.clientAddr(req); addr != "198.51.100.2" {
+		t.Errorf("Expected last untrusted hop, got %s", addr)
+	}
+
+	s.data.SetCfgString(ConfigTrustedProxies, "192.168.1.0/24, 198.51.100.2")
+	if addr := s.clientAddr(req); addr != "203.0.113.7" {
+		t.Errorf("Expected client address, got %s", addr)
+	}
+
+	// Requests not coming from a trusted proxy can't spoof the header.
+	req.RemoteAddr = "203.0.113.50:1234"
+	if addr := s.clientAddr(req); addr != "203.0.113.50" {
+		t.Errorf("Header from untrusted address was honored: %s", addr)
+	}
+}
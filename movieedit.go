@@ -0,0 +1,307 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+type dataMovieEdit struct {
+	dataPageBase
+
+	Movie        *common.Movie
+	ErrorMessage []string
+
+	ValDescription string
+	ValRemarks     string
+	ValLinks       string
+}
+
+type movieRevision struct {
+	*common.MovieRevision
+	Editor string
+}
+
+// canEditMovie returns true if the user suggested the movie and it is still
+// in the current cycle.  Once voting is closed only admins can edit their
+// suggestions.
+func (s *Server) canEditMovie(user *common.User, movie *common.Movie) (bool, error) {
+	if user == nil || movie.AddedBy == nil || movie.AddedBy.Id != user.Id {
+		return false, nil
+	}
+
+	if movie.Removed || movie.CycleWatched != nil || movie.CycleAdded == nil {
+		return false, nil
+	}
+
+	cycle, err := s.data.GetCurrentCycle()
+	if err != nil {
+		return false, fmt.Errorf("Unable to get current cycle: %v", err)
+	}
+
+	if cycle == nil || cycle.Id != movie.CycleAdded.Id {
+		return false, nil
+	}
+
+	if user.IsAdmin() {
+		return true, nil
+	}
+
+	votingEnabled, err := s.data.GetCfgBool(ConfigVotingEnabled, DefaultVotingEnabled)
+	if err != nil {
+		return false, fmt.Errorf("Unable to get %s: %v", ConfigVotingEnabled, err)
+	}
+	return votingEnabled, nil
+}
+
+// canWithdrawMovie is canEditMovie, as long as nobody else voted for the
+// movie.
+func (s *Server) canWithdrawMovie(user *common.User, movie *common.Movie) (bool, error) {
+	ok, err := s.canEditMovie(user, movie)
+	if !ok || err != nil {
+		return false, err
+	}
+
+	for _, vote := range movie.Votes {
+		if vote.User != nil && vote.User.Id != user.Id {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// recordRevision saves the changes between two versions of a movie.
+// Nothing is saved if nothing changed.
+func (s *Server) recordRevision(user *common.User, old, new *common.Movie) {
+	changes := common.DiffMovies(old, new)
+	if len(changes) == 0 {
+		return
+	}
+
+	rev := &common.MovieRevision{
+		MovieId: new.Id,
+		UserId:  user.Id,
+		Time:    time.Now(),
+		Changes: changes,
+	}
+
+	if _, err := s.data.AddMovieRevision(rev); err != nil {
+		s.l.Error("Unable to save revision of movie %d: %v", new.Id, err)
+	}
+}
+
+// movieRevisions returns the revisions of a movie, newest first, with the
+// names of the editors.
+func (s *Server) movieRevisions(movieId int) ([]movieRevision, error) {
+	revs, err := s.data.GetMovieRevisions(movieId)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []movieRevision{}
+	for i := len(revs) - 1; i >= 0; i-- {
+		editor := "somebody"
+		if user, err := s.data.GetUser(revs[i].UserId); err == nil && user != nil {
+			editor = user.Name
+		}
+		list = append(list, movieRevision{MovieRevision: revs[i], Editor: editor})
+	}
+	return list, nil
+}
+
+// handlerMovieEdit lets the user that suggested a movie change its
+// description, remarks, links and poster.
+func (s *Server) handlerMovieEdit(movie *common.Movie, w http.ResponseWriter, r *http.Request) {
+	user := s.getSessionUser(w, r)
+	if user == nil {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	ok, err := s.canEditMovie(user, movie)
+	if err != nil {
+		s.l.Error(err.Error())
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	if !ok {
+		s.doError(http.StatusForbidden, "You can only edit your own suggestions while voting is open", w, r)
+		return
+	}
+
+	links := []string{}
+	for _, link := range movie.Links {
+		links = append(links, link.Url)
+	}
+
+	data := dataMovieEdit{
+		dataPageBase:   s.newPageBase("Edit "+movie.Name, w, r),
+		Movie:          movie,
+		ValDescription: movie.Description,
+		ValRemarks:     movie.Remarks,
+		ValLinks:       strings.Join(links, "\n"),
+	}
+
+	if r.Method == "POST" {
+		if err = r.ParseMultipartForm(4096); err != nil && err != http.ErrNotMultipart {
+			s.l.Error("Unable to parse form: %v", err)
+		}
+
+		updated, err := s.movieFromEditForm(movie, &data, r)
+		if err != nil {
+			s.l.Error(err.Error())
+			s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+			return
+		}
+
+		if updated != nil {
+			if err = s.data.UpdateMovie(updated); err != nil {
+				s.l.Error("Unable to update movie %d: %v", movie.Id, err)
+				s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+				return
+			}
+
+			s.recordRevision(user, movie, updated)
+			s.search.invalidate()
+			http.Redirect(w, r, fmt.Sprintf("/movie/%d", movie.Id), http.StatusSeeOther)
+			return
+		}
+	}
+
+	if err := s.executeTemplate(w, "movieEdit", data); err != nil {
+		s.l.Error("Error rendering template: %v", err)
+	}
+}
+
+// movieFromEditForm checks the edit form using the same limits as adding a
+// movie.  Problems end up in data.ErrorMessage and nil is returned.
+func (s *Server) movieFromEditForm(movie *common.Movie, data *dataMovieEdit, r *http.Request) (*common.Movie, error) {
+	data.ValDescription = strings.TrimSpace(r.FormValue("Description"))
+	data.ValRemarks = strings.TrimSpace(strings.ReplaceAll(r.FormValue("Remarks"), "\r", ""))
+	data.ValLinks = strings.TrimSpace(strings.ReplaceAll(r.FormValue("Links"), "\r", ""))
+
+	maxDescription, err := s.data.GetCfgInt(ConfigMaxDescriptionLength, DefaultMaxDescriptionLength)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %q: %v", ConfigMaxDescriptionLength, err)
+	}
+
+	maxRemarks, err := s.data.GetCfgInt(ConfigMaxRemarksLength, DefaultMaxRemarksLength)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %q: %v", ConfigMaxRemarksLength, err)
+	}
+
+	maxLinks, err := s.data.GetCfgInt(ConfigMaxLinkLength, DefaultMaxLinkLength)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %q: %v", ConfigMaxLinkLength, err)
+	}
+
+	if data.ValDescription == "" {
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Missing description"))
+	} else if common.GetStringLength(data.ValDescription) > maxDescription {
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Description too long! Max Length: %d characters", maxDescription))
+	}
+
+	if common.GetStringLength(data.ValRemarks) > maxRemarks {
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Remarks too long! Max Length: %d characters", maxRemarks))
+	}
+
+	if common.GetStringLength(data.ValLinks) > maxLinks {
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Links too long! Max Length: %d characters", maxLinks))
+	}
+
+	links := []*common.Link{}
+	for _, url := range strings.Split(data.ValLinks, "\n") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+
+		link, err := common.NewLink(url, len(links))
+		if err != nil {
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Invalid link %q: %v", url, err))
+			continue
+		}
+		links = append(links, link)
+	}
+
+	if len(links) == 0 {
+		data.ErrorMessage = append(data.ErrorMessage, data.T("No link found."))
+	}
+
+	if len(data.ErrorMessage) > 0 {
+		return nil, nil
+	}
+
+	updated := *movie
+	updated.Description = data.ValDescription
+	updated.Remarks = data.ValRemarks
+
+	for _, link := range links {
+		id, err := s.data.AddLink(link)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to add link: %v", err)
+		}
+		link.Id = id
+	}
+	updated.Links = links
+
+	if posterFile, _, _ := r.FormFile("PosterFile"); posterFile != nil {
+		posterFile.Close()
+
+		file, err := s.uploadPoster(r)
+		if err != nil {
+			data.ErrorMessage = append(data.ErrorMessage, err.Error())
+			return nil, nil
+		}
+		updated.Poster = filepath.Base(file)
+	}
+
+	return &updated, nil
+}
+
+// handlerMovieWithdraw removes a suggestion at the request of the user that
+// added it.
+func (s *Server) handlerMovieWithdraw(movie *common.Movie, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.doError(http.StatusMethodNotAllowed, "Withdrawing a movie must be POSTed", w, r)
+		return
+	}
+
+	user := s.getSessionUser(w, r)
+	if user == nil {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	ok, err := s.canWithdrawMovie(user, movie)
+	if err != nil {
+		s.l.Error(err.Error())
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	if !ok {
+		s.doError(http.StatusForbidden, "You can only withdraw your own suggestions while voting is open and nobody else voted for them", w, r)
+		return
+	}
+
+	if err = s.data.RemoveMovie(movie.Id); err != nil {
+		s.l.Error("Unable to remove movie with ID %d: %v", movie.Id, err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	user.WithdrawnIn = append(user.WithdrawnIn, movie.CycleAdded.Id)
+	if err = s.data.UpdateUser(user); err != nil {
+		s.l.Error("Unable to update WithdrawnIn for user %d: %v", user.Id, err)
+	}
+
+	s.l.Info("%s withdrew %q", user.Name, movie.Name)
+	s.publishMovieChange(EventRemoved, movie)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,154 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/i18n"
+)
+
+func Test_MovieEdit(t *testing.T) {
+	s, user, movieId := setupVoteTest(t)
+	cookies := loginCookies(t, s, user)
+	token := csrfTokenFor(t, s, cookies)
+	path := fmt.Sprintf("/movie/%d/edit", movieId)
+
+	rec := postForm(s, path, url.Values{
+		"CsrfToken":   {token},
+		"Description": {""},
+		"Links":       {"https://www.imdb.com/title/tt0111161/"},
+	}, cookies)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Missing description") {
+		t.Fatalf("Empty description was accepted: %d", rec.Code)
+	}
+
+	rec = postForm(s, path, url.Values{
+		"CsrfToken":   {token},
+		"Description": {"A better description"},
+		"Remarks":     {"Watch it"},
+		"Links":       {"https://www.imdb.com/title/tt0111161/"},
+	}, cookies)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Edit failed: %d", rec.Code)
+	}
+
+	movie, err := s.data.GetMovie(movieId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if movie.Description != "A better description" || movie.Remarks != "Watch it" || len(movie.Links) != 1 {
+		t.Errorf("Movie was not updated: %s", movie)
+	}
+
+	revs, err := s.movieRevisions(movieId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revs) != 1 || revs[0].Editor != "voter" || len(revs[0].Changes) != 3 {
+		t.Fatalf("Unexpected revisions: %+v", revs)
+	}
+
+	// Only the submitter
+	other := addTestUser(t, s, "other", common.PRIV_USER)
+	otherCookies := loginCookies(t, s, other)
+	rec = postForm(s, path, url.Values{
+		"CsrfToken":   {csrfTokenFor(t, s, otherCookies)},
+		"Description": {"Vandalised"},
+		"Links":       {"https://www.imdb.com/title/tt0111161/"},
+	}, otherCookies)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Someone else edited the movie: %d", rec.Code)
+	}
+
+	// Only admins can edit after voting is closed
+	s.data.SetCfgBool(ConfigVotingEnabled, false)
+	rec = postForm(s, path, url.Values{
+		"CsrfToken":   {token},
+		"Description": {"Too late"},
+		"Links":       {"https://www.imdb.com/title/tt0111161/"},
+	}, cookies)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Movie was edited after voting closed: %d", rec.Code)
+	}
+
+	user.Privilege = common.PRIV_ADMIN
+	if err = s.data.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = postForm(s, path, url.Values{
+		"CsrfToken":   {token},
+		"Description": {"Admin fix"},
+		"Links":       {"https://www.imdb.com/title/tt0111161/"},
+	}, cookies)
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("Admin couldn't edit after voting closed: %d", rec.Code)
+	}
+}
+
+func Test_MovieWithdraw(t *testing.T) {
+	s, user, movieId := setupVoteTest(t)
+	cookies := loginCookies(t, s, user)
+	token := csrfTokenFor(t, s, cookies)
+	path := fmt.Sprintf("/movie/%d/withdraw", movieId)
+
+	other := addTestUser(t, s, "other", common.PRIV_USER)
+	if err := s.data.AddVote(other.Id, movieId); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postForm(s, path, url.Values{"CsrfToken": {token}}, cookies)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Movie with votes from others was withdrawn: %d", rec.Code)
+	}
+
+	if err := s.data.DeleteVote(other.Id, movieId); err != nil {
+		t.Fatal(err)
+	}
+
+	s.data.SetCfgBool(ConfigVotingEnabled, false)
+	rec = postForm(s, path, url.Values{"CsrfToken": {token}}, cookies)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Movie was withdrawn after voting closed: %d", rec.Code)
+	}
+	s.data.SetCfgBool(ConfigVotingEnabled, true)
+
+	// Their own vote doesn't count
+	if err := s.data.AddVote(user.Id, movieId); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = postForm(s, path, url.Values{"CsrfToken": {token}}, cookies)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Withdraw failed: %d", rec.Code)
+	}
+
+	if _, err := s.data.GetMovie(movieId); err == nil {
+		t.Errorf("Movie still exists")
+	}
+
+	// Withdrawing doesn't free up a slot for another suggestion
+	s.data.SetCfgInt(ConfigMaxUserMoviesPerCycle, 1)
+	cycle, err := s.data.GetCurrentCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err = s.data.GetUser(user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := s.checkMovieAddLimit(user, cycle, i18n.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg, "You have already added 1 movies this cycle") {
+		t.Errorf("Expected cycle limit message after withdrawing, got %q", msg)
+	}
+}
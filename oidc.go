@@ -0,0 +1,743 @@
+package moviepoll
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// Allowed difference between our clock and the issuer's when checking token
+// timestamps.
+const oidcClockSkew = 2 * time.Minute
+
+// Session keys used during the login round trip to the issuer.
+const (
+	sessOidcState    string = "OidcState"
+	sessOidcNonce    string = "OidcNonce"
+	sessOidcVerifier string = "OidcVerifier"
+)
+
+// oidcProvider implements the authorization code flow with PKCE against a
+// generic OpenID Connect issuer, eg Keycloak.  Only RSA signed ID tokens are
+// supported.
+type oidcProvider struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+
+	client *http.Client
+
+	AuthEndpoint  string `json:"authorization_endpoint"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JwksUri       string `json:"jwks_uri"`
+	Issuer        string `json:"issuer"`
+
+	keys     map[string]*rsa.PublicKey
+	keysLock *sync.Mutex
+}
+
+type oidcClaims struct {
+	Issuer   string          `json:"iss"`
+	Subject  string          `json:"sub"`
+	Audience json.RawMessage `json:"aud"`
+	Azp      string          `json:"azp"`
+	Expires  int64           `json:"exp"`
+	IssuedAt int64           `json:"iat"`
+	Nonce    string          `json:"nonce"`
+
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+
+	// All claims, used to look up the group claim.
+	raw map[string]interface{}
+}
+
+// newOidcProvider runs issuer discovery and returns a provider ready to
+// use.
+func newOidcProvider(issuer, clientId, clientSecret string) (*oidcProvider, error) {
+	p := &oidcProvider{
+		issuer:       strings.TrimRight(issuer, "/"),
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		keys:         map[string]*rsa.PublicKey{},
+		keysLock:     &sync.Mutex{},
+	}
+
+	resp, err := p.client.Get(p.issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get discovery document: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to get discovery document: %s", resp.Status)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(p); err != nil {
+		return nil, fmt.Errorf("Unable to decode discovery document: %v", err)
+	}
+
+	if strings.TrimRight(p.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("Issuer mismatch in discovery document: %q", p.Issuer)
+	}
+
+	if p.AuthEndpoint == "" || p.TokenEndpoint == "" || p.JwksUri == "" {
+		return nil, fmt.Errorf("Discovery document is missing required endpoints")
+	}
+
+	return p, nil
+}
+
+// authUrl returns the URL to send the user to for authentication.
+func (p *oidcProvider) authUrl(redirect, state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	vals := url.Values{}
+	vals.Set("response_type", "code")
+	vals.Set("client_id", p.clientId)
+	vals.Set("redirect_uri", redirect)
+	vals.Set("scope", "openid profile")
+	vals.Set("state", state)
+	vals.Set("nonce", nonce)
+	vals.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	vals.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.AuthEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthEndpoint + sep + vals.Encode()
+}
+
+// exchange trades an authorization code for an ID token and returns its
+// validated claims.
+func (p *oidcProvider) exchange(code, redirect, verifier, nonce string) (*oidcClaims, error) {
+	vals := url.Values{}
+	vals.Set("grant_type", "authorization_code")
+	vals.Set("code", code)
+	vals.Set("redirect_uri", redirect)
+	vals.Set("client_id", p.clientId)
+	vals.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(vals.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientId), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read token response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Token request failed: %s: %s", resp.Status, body)
+	}
+
+	tokens := struct {
+		IdToken string `json:"id_token"`
+	}{}
+	if err = json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("Unable to decode token response: %v", err)
+	}
+
+	if tokens.IdToken == "" {
+		return nil, fmt.Errorf("Token response did not contain an ID token")
+	}
+
+	return p.validate(tokens.IdToken, nonce)
+}
+
+// validate checks the signature and claims of an ID token.
+func (p *oidcProvider) validate(token, nonce string) (*oidcClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Malformed ID token")
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed ID token header: %v", err)
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err = json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("Malformed ID token header: %v", err)
+	}
+
+	var hash crypto.Hash
+	switch header.Alg {
+	case "RS256":
+		hash = crypto.SHA256
+	case "RS384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("Unsupported ID token algorithm %q", header.Alg)
+	}
+
+	key, err := p.getKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed ID token signature: %v", err)
+	}
+
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), sig); err != nil {
+		return nil, fmt.Errorf("Invalid ID token signature")
+	}
+
+	rawClaims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed ID token claims: %v", err)
+	}
+
+	claims := &oidcClaims{}
+	if err = json.Unmarshal(rawClaims, claims); err != nil {
+		return nil, fmt.Errorf("Malformed ID token claims: %v", err)
+	}
+	if err = json.Unmarshal(rawClaims, &claims.raw); err != nil {
+		return nil, fmt.Errorf("Malformed ID token claims: %v", err)
+	}
+
+	if strings.TrimRight(claims.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("ID token issuer mismatch: %q", claims.Issuer)
+	}
+
+	audience := []string{}
+	if err = json.Unmarshal(claims.Audience, &audience); err != nil {
+		var single string
+		if err = json.Unmarshal(claims.Audience, &single); err != nil {
+			return nil, fmt.Errorf("Malformed ID token audience")
+		}
+		audience = []string{single}
+	}
+
+	found := false
+	for _, aud := range audience {
+		if aud == p.clientId {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("ID token was not issued for this client")
+	}
+
+	if len(audience) > 1 && claims.Azp != p.clientId {
+		return nil, fmt.Errorf("ID token authorized party mismatch: %q", claims.Azp)
+	}
+
+	now := time.Now()
+	if time.Unix(claims.Expires, 0).Add(oidcClockSkew).Before(now) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+
+	if claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).Add(-oidcClockSkew).After(now) {
+		return nil, fmt.Errorf("ID token was issued in the future")
+	}
+
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("ID token nonce mismatch")
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("ID token is missing a subject")
+	}
+
+	return claims, nil
+}
+
+// getKey returns the public key with the given key ID.  The key set is
+// re-fetched if the ID is unknown to pick up key rotations.
+func (p *oidcProvider) getKey(kid string) (*rsa.PublicKey, error) {
+	p.keysLock.Lock()
+	defer p.keysLock.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	resp, err := p.client.Get(p.JwksUri)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get key set: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to get key set: %s", resp.Status)
+	}
+
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("Unable to decode key set: %v", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("No key found with ID %q", kid)
+	}
+	return key, nil
+}
+
+// groups returns the list of groups found in the claim at the given path.
+// Nested claims are separated with a dot (eg, "realm_access.roles").
+func (c *oidcClaims) groups(path string) []string {
+	var val interface{} = c.raw
+	for _, key := range strings.Split(path, ".") {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		val = m[key]
+	}
+
+	groups := []string{}
+	switch v := val.(type) {
+	case []interface{}:
+		for _, g := range v {
+			if str, ok := g.(string); ok {
+				groups = append(groups, str)
+			}
+		}
+	case string:
+		groups = append(groups, v)
+	}
+	return groups
+}
+
+// getOidcProvider returns the configured provider, or nil if OIDC logins are
+// disabled.  Discovery is only re-run when the configuration changes.
+func (s *Server) getOidcProvider() (*oidcProvider, error) {
+	enabled, err := s.data.GetCfgBool(ConfigOidcEnabled, DefaultOidcEnabled)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s: %v", ConfigOidcEnabled, err)
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	issuer, err := s.data.GetCfgString(ConfigOidcIssuer, "")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s: %v", ConfigOidcIssuer, err)
+	}
+
+	clientId, err := s.data.GetCfgString(ConfigOidcClientId, "")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s: %v", ConfigOidcClientId, err)
+	}
+
+	secret, err := s.data.GetCfgString(ConfigOidcClientSecret, "")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s: %v", ConfigOidcClientSecret, err)
+	}
+
+	if issuer == "" || clientId == "" {
+		return nil, fmt.Errorf("OIDC is enabled but the issuer or client ID is not set")
+	}
+
+	s.oidcLock.Lock()
+	defer s.oidcLock.Unlock()
+
+	p := s.oidc
+	if p != nil && p.issuer == strings.TrimRight(issuer, "/") && p.clientId == clientId && p.clientSecret == secret {
+		return p, nil
+	}
+
+	p, err = newOidcProvider(issuer, clientId, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	s.oidc = p
+	return p, nil
+}
+
+// oidcName returns the display name of the provider for the login button, or
+// an empty string if OIDC logins are disabled.
+func (s *Server) oidcName() string {
+	enabled, err := s.data.GetCfgBool(ConfigOidcEnabled, DefaultOidcEnabled)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigOidcEnabled, err)
+		return ""
+	}
+
+	if !enabled {
+		return ""
+	}
+
+	name, err := s.data.GetCfgString(ConfigOidcName, DefaultOidcName)
+	if err != nil || name == "" {
+		return DefaultOidcName
+	}
+	return name
+}
+
+// oidcRedirectUrl returns the callback URL registered with the issuer.
+func (s *Server) oidcRedirectUrl(r *http.Request) string {
+	host, err := s.data.GetCfgString(ConfigHostAddress, "")
+	if err != nil {
+		s.l.Error("Unable to get host: %v", err)
+	}
+
+	if host == "" {
+		host = r.Host
+		if r.TLS != nil {
+			host = "https://" + host
+		}
+	}
+
+	if !strings.HasPrefix(strings.ToLower(host), "http") {
+		host = "http://" + host
+	}
+
+	return strings.TrimRight(host, "/") + "/user/login/oidc/callback"
+}
+
+// Redirects to the issuer.  If a user is logged in, the issuer's account will
+// be linked to it instead of logging in.
+func (s *Server) handlerOidcLogin(w http.ResponseWriter, r *http.Request) {
+	p, err := s.getOidcProvider()
+	if err != nil {
+		s.l.Error("Unable to setup OIDC provider: %v", err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	if p == nil {
+		s.doError(http.StatusNotFound, fmt.Sprintf("%q not found", r.URL.Path), w, r)
+		return
+	}
+
+	session, err := s.cookies.Get(r, SessionName)
+	if err != nil {
+		s.l.Error("Unable to get session from store: %v", err)
+	}
+
+	state := getCryptRandKey(32)
+	nonce := getCryptRandKey(32)
+	verifier := getCryptRandKey(64)
+
+	session.Values[sessOidcState] = state
+	session.Values[sessOidcNonce] = nonce
+	session.Values[sessOidcVerifier] = verifier
+
+	if err = session.Save(r, w); err != nil {
+		s.l.Error("Unable to save session: %v", err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	http.Redirect(w, r, p.authUrl(s.oidcRedirectUrl(r), state, nonce, verifier), http.StatusFound)
+}
+
+func (s *Server) handlerOidcCallback(w http.ResponseWriter, r *http.Request) {
+	p, err := s.getOidcProvider()
+	if err != nil {
+		s.l.Error("Unable to setup OIDC provider: %v", err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	if p == nil {
+		s.doError(http.StatusNotFound, fmt.Sprintf("%q not found", r.URL.Path), w, r)
+		return
+	}
+
+	session, err := s.cookies.Get(r, SessionName)
+	if err != nil {
+		s.l.Error("Unable to get session from store: %v", err)
+	}
+
+	state, _ := session.Values[sessOidcState].(string)
+	nonce, _ := session.Values[sessOidcNonce].(string)
+	verifier, _ := session.Values[sessOidcVerifier].(string)
+
+	// Values are single use
+	delete(session.Values, sessOidcState)
+	delete(session.Values, sessOidcNonce)
+	delete(session.Values, sessOidcVerifier)
+	if err = session.Save(r, w); err != nil {
+		s.l.Error("Unable to save session: %v", err)
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		s.l.Info("OIDC login failed: %s: %s", e, query.Get("error_description"))
+		s.doError(http.StatusBadRequest, "Login was canceled or denied", w, r)
+		return
+	}
+
+	if state == "" || query.Get("state") != state {
+		s.l.Info("OIDC state mismatch")
+		s.doError(http.StatusBadRequest, "Invalid login state. Please try again.", w, r)
+		return
+	}
+
+	claims, err := p.exchange(query.Get("code"), s.oidcRedirectUrl(r), verifier, nonce)
+	if err != nil {
+		s.l.Error("OIDC token exchange failed: %v", err)
+		s.doError(http.StatusBadRequest, "Unable to verify login", w, r)
+		return
+	}
+
+	revoked, err := s.data.OidcSubjectRevoked(claims.Subject)
+	if err != nil {
+		s.l.Error("Unable to lookup OIDC subject: %v", err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	if revoked {
+		s.l.Info("Refused login of revoked OIDC subject %s", claims.Subject)
+		s.doError(http.StatusForbidden, "The account of this login has been removed", w, r)
+		return
+	}
+
+	linked, err := s.data.GetUserByOidcSubject(claims.Subject)
+	if err != nil {
+		s.l.Error("Unable to lookup OIDC subject: %v", err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	// Link the account to the logged in user
+	if user := s.getSessionUser(w, r); user != nil {
+		if linked != nil && linked.Id != user.Id {
+			s.doError(http.StatusBadRequest, "That account is already linked to another user", w, r)
+			return
+		}
+
+		user.OidcSubject = claims.Subject
+		if err = s.data.UpdateUser(user); err != nil {
+			s.l.Error("Unable to update user: %v", err)
+			s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+			return
+		}
+
+		s.l.Info("User %s linked OIDC subject %s", user.Name, claims.Subject)
+		http.Redirect(w, r, "/user", http.StatusFound)
+		return
+	}
+
+	user := linked
+	if user == nil {
+		create, err := s.data.GetCfgBool(ConfigOidcCreateUsers, DefaultOidcCreateUsers)
+		if err != nil {
+			s.l.Error("Unable to get %s: %v", ConfigOidcCreateUsers, err)
+		}
+
+		if !create {
+			s.doError(http.StatusForbidden, "No account is linked to this login", w, r)
+			return
+		}
+
+		user, err = s.newOidcUser(claims)
+		if err != nil {
+			s.l.Error("Unable to create OIDC user: %v", err)
+			s.doError(http.StatusInternalServerError, "Unable to create account", w, r)
+			return
+		}
+	}
+
+	if err = s.mapOidcPrivilege(user, claims); err != nil {
+		s.l.Error("Unable to update user privilege: %v", err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	redirect, err := s.startLogin(user, w, r)
+	if err != nil {
+		s.l.Error("Unable to login: %v", err)
+		s.doError(http.StatusInternalServerError, "Unable to login", w, r)
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// newOidcUser creates a new account for the given claims.  The account has no
+// password and can only log in through the issuer.
+func (s *Server) newOidcUser(claims *oidcClaims) (*common.User, error) {
+	maxlen, err := s.data.GetCfgInt(ConfigMaxNameLength, DefaultMaxNameLength)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s: %v", ConfigMaxNameLength, err)
+	}
+
+	base := strings.TrimSpace(claims.PreferredUsername)
+	if base == "" {
+		base = strings.TrimSpace(claims.Name)
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	// Leave room for a number if the name is taken
+	if maxlen > 4 && common.GetStringLength(base) > maxlen-4 {
+		base = string([]rune(base)[:maxlen-4])
+	}
+
+	name := base
+	for i := 2; ; i++ {
+		exists, err := s.data.CheckUserExists(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			break
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+
+	user := &common.User{
+		Name:        name,
+		OidcSubject: claims.Subject,
+		PassDate:    time.Now(),
+	}
+
+	if _, err = s.data.AddUser(user); err != nil {
+		return nil, err
+	}
+
+	s.l.Info("Created user %s for OIDC subject %s", user.Name, claims.Subject)
+	return user, nil
+}
+
+// revokeOidcSubject unlinks the user's OpenID Connect account and refuses any
+// future login with it.  The user isn't saved.
+func (s *Server) revokeOidcSubject(user *common.User) error {
+	if user.OidcSubject == "" {
+		return nil
+	}
+
+	if err := s.data.RevokeOidcSubject(user.OidcSubject); err != nil {
+		return fmt.Errorf("Unable to revoke OIDC subject: %v", err)
+	}
+
+	user.OidcSubject = ""
+	user.OidcPrivilege = false
+	user.LocalPrivilege = common.PRIV_USER
+	return nil
+}
+
+// mapOidcPrivilege sets the user's privilege from the group claim.  Nothing is
+// changed unless a mod or admin group is configured.  Groups only lower
+// privileges they granted in the first place, so privileges given locally
+// are kept.
+func (s *Server) mapOidcPrivilege(user *common.User, claims *oidcClaims) error {
+	claim, err := s.data.GetCfgString(ConfigOidcGroupsClaim, DefaultOidcGroupsClaim)
+	if err != nil {
+		return err
+	}
+
+	modGroup, err := s.data.GetCfgString(ConfigOidcModGroup, "")
+	if err != nil {
+		return err
+	}
+
+	adminGroup, err := s.data.GetCfgString(ConfigOidcAdminGroup, "")
+	if err != nil {
+		return err
+	}
+
+	if claim == "" || (modGroup == "" && adminGroup == "") {
+		return nil
+	}
+
+	priv := common.PRIV_USER
+	for _, group := range claims.groups(claim) {
+		// Keycloak prefixes full group paths with a slash
+		group = strings.TrimPrefix(group, "/")
+
+		if adminGroup != "" && group == strings.TrimPrefix(adminGroup, "/") {
+			priv = common.PRIV_ADMIN
+		} else if modGroup != "" && group == strings.TrimPrefix(modGroup, "/") && priv < common.PRIV_MOD {
+			priv = common.PRIV_MOD
+		}
+	}
+
+	if priv > user.Privilege {
+		if !user.OidcPrivilege {
+			user.LocalPrivilege = user.Privilege
+		}
+		user.OidcPrivilege = true
+	} else if priv < user.Privilege && user.OidcPrivilege {
+		if priv <= user.LocalPrivilege {
+			priv = user.LocalPrivilege
+			user.OidcPrivilege = false
+		}
+	} else {
+		return nil
+	}
+
+	s.l.Info("Setting privilege of %s to %d from OIDC groups", user.Name, priv)
+	user.Privilege = priv
+	return s.data.UpdateUser(user)
+}
@@ -0,0 +1,430 @@
+package moviepoll
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+const (
+	testOidcClient = "moviepolls"
+	testOidcSecret = "hunter2"
+)
+
+// issuerStub is a minimal in-process OpenID Connect issuer.  Authorization
+// requests are recorded by calling authorize() instead of going through a
+// browser.
+type issuerStub struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	lock  *sync.Mutex
+	codes map[string]stubGrant
+
+	// Claims added to every ID token
+	claims map[string]interface{}
+	// Overrides the nonce in issued tokens if set
+	badNonce string
+}
+
+type stubGrant struct {
+	nonce     string
+	challenge string
+	redirect  string
+}
+
+func newIssuerStub(t *testing.T) *issuerStub {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &issuerStub{
+		key:    key,
+		lock:   &sync.Mutex{},
+		codes:  map[string]stubGrant{},
+		claims: map[string]interface{}{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 stub.srv.URL,
+			"authorization_endpoint": stub.srv.URL + "/auth",
+			"token_endpoint":         stub.srv.URL + "/token",
+			"jwks_uri":               stub.srv.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", stub.handleToken)
+
+	stub.srv = httptest.NewServer(mux)
+	return stub
+}
+
+// authorize acts as the user logging in at the issuer.  It returns the
+// callback URL the issuer would redirect to.
+func (stub *issuerStub) authorize(t *testing.T, authUrl string) string {
+	t.Helper()
+
+	u, err := url.Parse(authUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	if q.Get("client_id") != testOidcClient {
+		t.Fatalf("Wrong client ID in auth request: %q", q.Get("client_id"))
+	}
+
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("Auth request is missing PKCE challenge")
+	}
+
+	code := getCryptRandKey(16)
+	stub.lock.Lock()
+	stub.codes[code] = stubGrant{
+		nonce:     q.Get("nonce"),
+		challenge: q.Get("code_challenge"),
+		redirect:  q.Get("redirect_uri"),
+	}
+	stub.lock.Unlock()
+
+	return q.Get("redirect_uri") + "?code=" + code + "&state=" + url.QueryEscape(q.Get("state"))
+}
+
+func (stub *issuerStub) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testOidcClient || secret != testOidcSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	stub.lock.Lock()
+	grant, ok := stub.codes[r.PostFormValue("code")]
+	delete(stub.codes, r.PostFormValue("code"))
+	stub.lock.Unlock()
+
+	if !ok || grant.redirect != r.PostFormValue("redirect_uri") {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   stub.srv.URL,
+		"aud":   testOidcClient,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": grant.nonce,
+	}
+	for k, v := range stub.claims {
+		claims[k] = v
+	}
+
+	if stub.badNonce != "" {
+		claims["nonce"] = stub.badNonce
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     stub.sign(claims),
+	})
+}
+
+func (stub *issuerStub) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, stub.key, crypto.SHA256, sum[:])
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func setupOidc(t *testing.T) (*Server, *issuerStub) {
+	s := newTestServer(t)
+	stub := newIssuerStub(t)
+
+	s.data.SetCfgBool(ConfigOidcEnabled, true)
+	s.data.SetCfgString(ConfigOidcIssuer, stub.srv.URL)
+	s.data.SetCfgString(ConfigOidcClientId, testOidcClient)
+	s.data.SetCfgString(ConfigOidcClientSecret, testOidcSecret)
+	s.data.SetCfgString(ConfigHostAddress, "http://moviepolls.test")
+
+	return s, stub
+}
+
+// oidcRoundTrip runs a full login and returns the callback response.
+func oidcRoundTrip(t *testing.T, s *Server, stub *issuerStub, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.handlerOidcLogin(rec, addCookies(httptest.NewRequest("GET", "/user/login/oidc", nil), cookies))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected redirect to issuer, got %d", rec.Code)
+	}
+
+	callback := stub.authorize(t, rec.Header().Get("Location"))
+	if !strings.HasPrefix(callback, "http://moviepolls.test/user/login/oidc/callback?") {
+		t.Fatalf("Unexpected redirect URI: %q", callback)
+	}
+
+	req := httptest.NewRequest("GET", callback, nil)
+	addCookies(req, cookies)
+	addCookies(req, rec.Result().Cookies())
+
+	rec = httptest.NewRecorder()
+	s.handlerOidcCallback(rec, req)
+	return rec
+}
+
+func Test_OidcCreateUser(t *testing.T) {
+	s, stub := setupOidc(t)
+	defer stub.srv.Close()
+
+	s.data.SetCfgString(ConfigOidcGroupsClaim, "realm_access.roles")
+	s.data.SetCfgString(ConfigOidcModGroup, "movie-mods")
+	s.data.SetCfgString(ConfigOidcAdminGroup, "/movie-admins")
+
+	stub.claims["sub"] = "subject-1"
+	stub.claims["preferred_username"] = "keycloakuser"
+	stub.claims["realm_access"] = map[string]interface{}{"roles": []string{"offline_access", "movie-mods"}}
+
+	rec := oidcRoundTrip(t, s, stub, nil)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Fatalf("Expected redirect after login, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	user, err := s.data.GetUserByOidcSubject("subject-1")
+	if err != nil || user == nil {
+		t.Fatalf("User was not created: %v", err)
+	}
+
+	if user.Name != "keycloakuser" {
+		t.Errorf("Wrong user name: %q", user.Name)
+	}
+
+	if user.Privilege != common.PRIV_MOD {
+		t.Errorf("Expected mod privilege, got %d", user.Privilege)
+	}
+
+	// Second login with the admin group maps onto the existing account.
+	stub.claims["realm_access"] = map[string]interface{}{"roles": []string{"movie-admins"}}
+	rec = oidcRoundTrip(t, s, stub, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected redirect after login, got %d", rec.Code)
+	}
+
+	again, _ := s.data.GetUserByOidcSubject("subject-1")
+	if again.Id != user.Id {
+		t.Fatalf("A second user was created")
+	}
+
+	if again.Privilege != common.PRIV_ADMIN {
+		t.Errorf("Expected admin privilege, got %d", again.Privilege)
+	}
+}
+
+func Test_OidcLongName(t *testing.T) {
+	s := newTestServer(t)
+	s.data.SetCfgInt(ConfigMaxNameLength, 10)
+
+	for i, expected := range []string{"ÄÖÜäöü", "ÄÖÜäöü2"} {
+		user, err := s.newOidcUser(&oidcClaims{
+			Subject:           fmt.Sprintf("subject-%d", i),
+			PreferredUsername: "ÄÖÜäöüßé",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if user.Name != expected || !utf8.ValidString(user.Name) {
+			t.Errorf("Expected name %q, got %q", expected, user.Name)
+		}
+	}
+}
+
+func Test_OidcLinkUser(t *testing.T) {
+	s, stub := setupOidc(t)
+	defer stub.srv.Close()
+
+	user := addTestUser(t, s, "localuser", common.PRIV_USER)
+	stub.claims["sub"] = "subject-2"
+
+	rec := oidcRoundTrip(t, s, stub, loginCookies(t, s, user))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/user" {
+		t.Fatalf("Expected redirect to /user, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	linked, err := s.data.GetUserByOidcSubject("subject-2")
+	if err != nil || linked == nil || linked.Id != user.Id {
+		t.Fatalf("Account was not linked: %v", err)
+	}
+}
+
+func Test_OidcRejects(t *testing.T) {
+	s, stub := setupOidc(t)
+	defer stub.srv.Close()
+
+	stub.claims["sub"] = "subject-3"
+
+	// Bad state
+	rec := httptest.NewRecorder()
+	s.handlerOidcLogin(rec, httptest.NewRequest("GET", "/user/login/oidc", nil))
+	callback := stub.authorize(t, rec.Header().Get("Location"))
+	callback = strings.Replace(callback, "state=", "state=x", 1)
+
+	cb := httptest.NewRecorder()
+	s.handlerOidcCallback(cb, addCookies(httptest.NewRequest("GET", callback, nil), rec.Result().Cookies()))
+	if cb.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad state to be rejected, got %d", cb.Code)
+	}
+
+	// Replayed nonce
+	stub.badNonce = "not-the-nonce"
+	if rec = oidcRoundTrip(t, s, stub, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad nonce to be rejected, got %d", rec.Code)
+	}
+	stub.badNonce = ""
+
+	// Token signed by someone else
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.key = other
+	if rec = oidcRoundTrip(t, s, stub, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad signature to be rejected, got %d", rec.Code)
+	}
+
+	if user, _ := s.data.GetUserByOidcSubject("subject-3"); user != nil {
+		t.Errorf("User was created from a rejected login")
+	}
+}
+
+func Test_OidcRemovedAccounts(t *testing.T) {
+	s, stub := setupOidc(t)
+	defer stub.srv.Close()
+
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+	adminCookies := loginCookies(t, s, admin)
+
+	for _, action := range []string{"delete", "purge"} {
+		subject := "subject-" + action
+		stub.claims["sub"] = subject
+		if rec := oidcRoundTrip(t, s, stub, nil); rec.Code != http.StatusFound {
+			t.Fatalf("Expected redirect after login, got %d", rec.Code)
+		}
+
+		user, err := s.data.GetUserByOidcSubject(subject)
+		if err != nil || user == nil {
+			t.Fatalf("User was not created: %v", err)
+		}
+
+		form := url.Values{"confirm": {"yes"}, "CsrfToken": {csrfTokenFor(t, s, adminCookies)}}
+		rec := postForm(s, fmt.Sprintf("/admin/user/%d?action=%s", user.Id, action), form, adminCookies)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Unable to %s user: %d", action, rec.Code)
+		}
+
+		if linked, _ := s.data.GetUserByOidcSubject(subject); linked != nil {
+			t.Errorf("Subject is still linked after %s", action)
+		}
+
+		// Neither the old account nor a new one can log in
+		if rec = oidcRoundTrip(t, s, stub, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected login after %s to be refused, got %d", action, rec.Code)
+		}
+
+		if linked, _ := s.data.GetUserByOidcSubject(subject); linked != nil {
+			t.Errorf("A new account was created after %s", action)
+		}
+	}
+}
+
+func Test_OidcLocalPrivilege(t *testing.T) {
+	s, stub := setupOidc(t)
+	defer stub.srv.Close()
+
+	s.data.SetCfgString(ConfigOidcGroupsClaim, "groups")
+	s.data.SetCfgString(ConfigOidcModGroup, "movie-mods")
+	s.data.SetCfgString(ConfigOidcAdminGroup, "movie-admins")
+
+	login := func(user *common.User, groups ...string) *common.User {
+		t.Helper()
+
+		stub.claims["groups"] = groups
+		if rec := oidcRoundTrip(t, s, stub, nil); rec.Code != http.StatusFound {
+			t.Fatalf("Expected redirect after login, got %d", rec.Code)
+		}
+
+		user, err := s.data.GetUser(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+
+	// Local admins aren't demoted without the groups
+	owner := addTestUser(t, s, "owner", common.PRIV_ADMIN)
+	stub.claims["sub"] = "subject-owner"
+	if rec := oidcRoundTrip(t, s, stub, loginCookies(t, s, owner)); rec.Code != http.StatusFound {
+		t.Fatalf("Unable to link account: %d", rec.Code)
+	}
+
+	if owner = login(owner); owner.Privilege != common.PRIV_ADMIN {
+		t.Errorf("Local admin was demoted to %d", owner.Privilege)
+	}
+
+	// Privileges from the groups go away with them, down to the local one
+	mod := addTestUser(t, s, "mod", common.PRIV_MOD)
+	stub.claims["sub"] = "subject-mod"
+	if rec := oidcRoundTrip(t, s, stub, loginCookies(t, s, mod)); rec.Code != http.StatusFound {
+		t.Fatalf("Unable to link account: %d", rec.Code)
+	}
+
+	if mod = login(mod, "movie-admins"); mod.Privilege != common.PRIV_ADMIN {
+		t.Errorf("Expected admin privilege from the group, got %d", mod.Privilege)
+	}
+
+	if mod = login(mod); mod.Privilege != common.PRIV_MOD {
+		t.Errorf("Expected the local mod privilege after leaving the group, got %d", mod.Privilege)
+	}
+}
@@ -0,0 +1,203 @@
+package moviepoll
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// Type of the message with the full standings.  Everything else uses the
+// event types.
+const overlaySnapshot string = "snapshot"
+
+const (
+	overlayWriteTimeout time.Duration = 10 * time.Second
+	overlayPingInterval time.Duration = 30 * time.Second
+	overlayPongTimeout  time.Duration = overlayPingInterval * 2
+)
+
+const overlayPage string = "static/overlay.html"
+
+var overlayUpgrader = websocket.Upgrader{
+	// Overlays are loaded by streaming software that doesn't send a useful
+	// origin, and the feed only contains public data anyway.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type overlayMessage struct {
+	Type string
+	Data interface{}
+}
+
+type overlayStandings struct {
+	CycleId       int
+	VotingEnabled bool
+	Movies        []overlayMovie
+}
+
+type overlayMovie struct {
+	MovieId int
+	Name    string
+	Votes   int
+}
+
+// overlayAllowed checks the token if one is configured.  The token is passed
+// in the query string because browser sources can't set headers.
+func (s *Server) overlayAllowed(r *http.Request) bool {
+	token, err := s.data.GetCfgString(ConfigOverlayToken, DefaultOverlayToken)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigOverlayToken, err)
+		return false
+	}
+
+	if token == "" {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(r.URL.Query().Get("token"))) == 1
+}
+
+// overlayStandings returns the vote counts of all active movies, most votes
+// first.
+func (s *Server) overlayStandings() (overlayStandings, error) {
+	standings := overlayStandings{Movies: []overlayMovie{}}
+
+	cycle, err := s.data.GetCurrentCycle()
+	if err != nil {
+		return standings, err
+	}
+
+	if cycle != nil {
+		standings.CycleId = cycle.Id
+	}
+
+	standings.VotingEnabled, err = s.data.GetCfgBool(ConfigVotingEnabled, DefaultVotingEnabled)
+	if err != nil {
+		return standings, err
+	}
+
+	approval, err := s.data.GetCfgBool(ConfigEntriesRequireApproval, DefaultEntriesRequireApproval)
+	if err != nil {
+		return standings, err
+	}
+
+	movies, err := s.data.GetActiveMovies()
+	if err != nil {
+		return standings, err
+	}
+
+	for _, movie := range common.SortMoviesByVotes(movies) {
+		if approval && !movie.Approved {
+			continue
+		}
+
+		standings.Movies = append(standings.Movies, overlayMovie{
+			MovieId: movie.Id,
+			Name:    movie.Name,
+			Votes:   len(movie.Votes),
+		})
+	}
+
+	return standings, nil
+}
+
+// handlerOverlay serves a minimal page meant to be used as a browser source
+// in OBS.
+func (s *Server) handlerOverlay(w http.ResponseWriter, r *http.Request) {
+	if !s.overlayAllowed(r) {
+		s.doError(http.StatusForbidden, "Invalid overlay token", w, r)
+		return
+	}
+
+	s.serveFile(w, r, overlayPage)
+}
+
+// handlerOverlaySocket sends the standings when a client connects, followed
+// by every event.  A new snapshot is sent after each cycle change.
+func (s *Server) handlerOverlaySocket(w http.ResponseWriter, r *http.Request) {
+	if !s.overlayAllowed(r) {
+		s.doError(http.StatusForbidden, "Invalid overlay token", w, r)
+		return
+	}
+
+	// Subscribe before taking the snapshot so nothing is missed in between.
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	conn, err := overlayUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error.
+		s.l.Debug("Unable to upgrade overlay connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	send := func(msg overlayMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(overlayWriteTimeout))
+		return conn.WriteJSON(msg)
+	}
+
+	sendSnapshot := func() error {
+		standings, err := s.overlayStandings()
+		if err != nil {
+			s.l.Error("Unable to get overlay standings: %v", err)
+			return err
+		}
+		return send(overlayMessage{Type: overlaySnapshot, Data: standings})
+	}
+
+	if err = sendSnapshot(); err != nil {
+		return
+	}
+
+	// Clients don't send anything, but the connection has to be read to
+	// handle pongs and notice when it's closed.
+	closed := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(overlayPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(overlayPongTimeout))
+	})
+
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(overlayPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(overlayWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			if err := send(overlayMessage{Type: ev.Type, Data: ev.Data}); err != nil {
+				return
+			}
+
+			if ev.Type == EventCycle {
+				if err := sendSnapshot(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package moviepoll
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type rawOverlayMessage struct {
+	Type string
+	Data json.RawMessage
+}
+
+func readOverlay(t *testing.T, conn *websocket.Conn) rawOverlayMessage {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg := rawOverlayMessage{}
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("Unable to read overlay message: %v", err)
+	}
+	return msg
+}
+
+func Test_OverlaySocket(t *testing.T) {
+	s, user, movieId := setupVoteTest(t)
+	cookies := loginCookies(t, s, user)
+
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	wsUrl := "ws" + strings.TrimPrefix(ts.URL, "http") + "/overlay/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := readOverlay(t, conn)
+	if msg.Type != overlaySnapshot {
+		t.Fatalf("Expected snapshot first, got %q", msg.Type)
+	}
+
+	standings := overlayStandings{}
+	if err = json.Unmarshal(msg.Data, &standings); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(standings.Movies) != 1 || standings.Movies[0].MovieId != movieId || standings.Movies[0].Votes != 0 {
+		t.Fatalf("Unexpected standings: %+v", standings)
+	}
+
+	rec := postForm(s, fmt.Sprintf("/vote/%d", movieId), url.Values{"CsrfToken": {csrfTokenFor(t, s, cookies)}}, cookies)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Vote failed: %d", rec.Code)
+	}
+
+	msg = readOverlay(t, conn)
+	if msg.Type != EventVote {
+		t.Fatalf("Expected vote delta, got %q", msg.Type)
+	}
+
+	delta := voteEvent{}
+	if err = json.Unmarshal(msg.Data, &delta); err != nil {
+		t.Fatal(err)
+	}
+
+	if delta.MovieId != movieId || delta.Votes != 1 {
+		t.Errorf("Unexpected vote delta: %+v", delta)
+	}
+}
+
+func Test_OverlayToken(t *testing.T) {
+	s := newTestServer(t)
+	s.data.SetCfgString(ConfigOverlayToken, "secret")
+
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	wsUrl := "ws" + strings.TrimPrefix(ts.URL, "http") + "/overlay/ws"
+	if _, resp, err := websocket.DefaultDialer.Dial(wsUrl+"?token=wrong", nil); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected wrong token to be refused")
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl+"?token=secret", nil)
+	if err != nil {
+		t.Fatalf("Expected correct token to be accepted: %v", err)
+	}
+	defer conn.Close()
+
+	if msg := readOverlay(t, conn); msg.Type != overlaySnapshot {
+		t.Errorf("Expected snapshot, got %q", msg.Type)
+	}
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest("GET", "/overlay", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected overlay page without token to be refused, got %d", rec.Code)
+	}
+}
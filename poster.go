@@ -0,0 +1,244 @@
+package moviepoll
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nfnt/resize"
+	"github.com/zorchenhimer/MoviePolls/common"
+	mpd "github.com/zorchenhimer/MoviePolls/data"
+	"github.com/zorchenhimer/MoviePolls/storage"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	posterDir = "posters"
+
+	posterMaxBytes     int64 = 10 << 20 // 10 MB
+	posterMaxDimension int   = 6000     // width or height, in pixels
+
+	posterFullWidth  uint = 600
+	posterThumbWidth uint = 200
+	posterQuality    int  = 85
+)
+
+// Types accepted for posters, as returned by http.DetectContentType
+var posterTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+var posterClient = &http.Client{Timeout: 30 * time.Second}
+
+// decodePoster checks that raw is an image of a supported type and
+// reasonable size and decodes it.  Only the first frame of a GIF is used.
+func decodePoster(raw []byte) (image.Image, error) {
+	mime := http.DetectContentType(raw)
+	if !posterTypes[mime] {
+		return nil, fmt.Errorf("Unsupported poster format %q.  Use a JPEG, PNG, WebP or GIF image.", mime)
+	}
+
+	// Check the size before decoding everything
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read poster image: %v", err)
+	}
+
+	if cfg.Width > posterMaxDimension || cfg.Height > posterMaxDimension {
+		return nil, fmt.Errorf("Poster is too large (%dx%d).  Max size is %dx%d pixels.",
+			cfg.Width, cfg.Height, posterMaxDimension, posterMaxDimension)
+	}
+
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return nil, fmt.Errorf("Poster image is empty")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read poster image: %v", err)
+	}
+	return img, nil
+}
+
+// encodePosterJpeg scales img down to width and encodes it as a JPEG.
+// Images are never scaled up.  Re-encoding drops any metadata (EXIF etc) of
+// the original.
+func encodePosterJpeg(img image.Image, width uint) ([]byte, error) {
+	if uint(img.Bounds().Dx()) > width {
+		img = resize.Resize(width, 0, img, resize.Lanczos3)
+	}
+
+	// JPEG has no transparency
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, flat, &jpeg.Options{Quality: posterQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// savePoster stores an uploaded or downloaded image in the full and
+// thumbnail sizes.  The file name is a hash of the image, so the same poster
+// is only stored once.  The name of the full size file is returned.
+func savePoster(store storage.Storage, raw []byte) (string, error) {
+	img, err := decodePoster(raw)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(raw)
+	name := hex.EncodeToString(sum[:16]) + ".jpg"
+
+	sizes := []struct {
+		name  string
+		width uint
+	}{
+		{name, posterFullWidth},
+		{common.PosterThumb(name), posterThumbWidth},
+	}
+
+	for _, size := range sizes {
+		exists, err := store.Exists(size.name)
+		if err != nil {
+			return "", fmt.Errorf("Unable to check for poster: %v", err)
+		}
+
+		if exists {
+			continue
+		}
+
+		data, err := encodePosterJpeg(img, size.width)
+		if err != nil {
+			return "", fmt.Errorf("Unable to encode poster: %v", err)
+		}
+
+		if err = store.Put(size.name, data, "image/jpeg"); err != nil {
+			return "", fmt.Errorf("Unable to save poster: %v", err)
+		}
+	}
+
+	return name, nil
+}
+
+// newPosterStorage returns the storage configured for posters.
+func newPosterStorage(data mpd.DataConnector) (storage.Storage, error) {
+	backend, err := data.GetCfgString(ConfigPosterStorage, DefaultPosterStorage)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %q: %v", ConfigPosterStorage, err)
+	}
+
+	switch backend {
+	case "", "local":
+		return storage.NewLocal(posterDir, "/posters/"), nil
+	case "s3":
+	default:
+		return nil, fmt.Errorf("Unknown poster storage %q", backend)
+	}
+
+	cfg := storage.S3Config{UrlPrefix: "/posters/"}
+	values := []struct {
+		key string
+		val *string
+		def string
+	}{
+		{ConfigS3Endpoint, &cfg.Endpoint, ""},
+		{ConfigS3Region, &cfg.Region, DefaultS3Region},
+		{ConfigS3Bucket, &cfg.Bucket, ""},
+		{ConfigS3AccessKey, &cfg.AccessKey, ""},
+		{ConfigS3SecretKey, &cfg.SecretKey, ""},
+		{ConfigS3Prefix, &cfg.Prefix, DefaultS3Prefix},
+		{ConfigS3PublicUrl, &cfg.PublicUrl, ""},
+	}
+
+	for _, v := range values {
+		if *v.val, err = data.GetCfgString(v.key, v.def); err != nil {
+			return nil, fmt.Errorf("Unable to get %q: %v", v.key, err)
+		}
+	}
+
+	return storage.NewS3(cfg)
+}
+
+// posterUrl returns the URL of a poster.  Posters from before the poster
+// storage, and the placeholder, are always served from disk.
+func (s *Server) posterUrl(name string) string {
+	if name == "" {
+		name = "unknown.jpg"
+	}
+
+	if !common.StoredPoster(name) {
+		return "/posters/" + name
+	}
+	return s.posters.Url(name)
+}
+
+// readPoster reads at most posterMaxBytes from r.
+func readPoster(r io.Reader) ([]byte, error) {
+	return readUpload(r, posterMaxBytes)
+}
+
+// downloadPoster fetches a poster for autofill and saves it.  The name of
+// the full size file is returned.
+func downloadPoster(store storage.Storage, url string) (string, error) {
+	resp, err := posterClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unable to download poster: %s", resp.Status)
+	}
+
+	raw, err := readPoster(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return savePoster(store, raw)
+}
+
+// uploadPoster saves the poster uploaded in the PosterFile form field.  The
+// name of the full size file is returned.
+func (s *Server) uploadPoster(r *http.Request) (string, error) {
+	// 10 MB upload limit
+	r.ParseMultipartForm(posterMaxBytes)
+
+	file, handler, err := r.FormFile("PosterFile")
+	if err != nil {
+		s.l.Error(err.Error())
+		return "", fmt.Errorf("Unable to retrive the file")
+	}
+	defer file.Close()
+
+	s.l.Info("Uploaded File: %v - Size %v", handler.Filename, handler.Size)
+
+	raw, err := readPoster(file)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := savePoster(s.posters, raw)
+	if err != nil {
+		return "", err
+	}
+
+	s.l.Debug("[uploadPoster] Filename: %v", name)
+	return name, nil
+}
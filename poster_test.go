@@ -0,0 +1,157 @@
+package moviepoll
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/storage"
+)
+
+// 1x1 lossless WebP
+const testWebp = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.RGBA{R: 255, A: 255})
+	}
+	return img
+}
+
+func Test_SavePoster(t *testing.T) {
+	pngBuf := &bytes.Buffer{}
+	if err := png.Encode(pngBuf, testImage(900, 1200)); err != nil {
+		t.Fatal(err)
+	}
+
+	gifBuf := &bytes.Buffer{}
+	if err := gif.Encode(gifBuf, testImage(100, 150), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	webp, err := base64.StdEncoding.DecodeString(testWebp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := storage.NewLocal(posterDir, "/posters/")
+
+	for _, raw := range [][]byte{pngBuf.Bytes(), gifBuf.Bytes(), webp} {
+		name, err := savePoster(store, raw)
+		if err != nil {
+			t.Fatalf("Unable to save poster: %v", err)
+		}
+
+		if !strings.HasSuffix(name, ".jpg") || common.PosterThumb(name) == name {
+			t.Errorf("Unexpected poster name %q", name)
+		}
+
+		for file, maxWidth := range map[string]int{name: 600, common.PosterThumb(name): 200} {
+			f, err := os.Open(filepath.Join(posterDir, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := jpeg.DecodeConfig(f)
+			f.Close()
+			if err != nil {
+				t.Fatalf("%s is not a JPEG: %v", file, err)
+			}
+
+			if cfg.Width > maxWidth {
+				t.Errorf("%s is %d pixels wide, expected at most %d", file, cfg.Width, maxWidth)
+			}
+		}
+	}
+
+	// Same image, same file
+	first, _ := savePoster(store, pngBuf.Bytes())
+	second, _ := savePoster(store, pngBuf.Bytes())
+	if first != second {
+		t.Errorf("Posters were not deduplicated: %q %q", first, second)
+	}
+
+	if _, err := savePoster(store, []byte("<html>not an image</html>")); err == nil || !strings.Contains(err.Error(), "Unsupported poster format") {
+		t.Errorf("Non-image was accepted: %v", err)
+	}
+
+	huge := &bytes.Buffer{}
+	if err := png.Encode(huge, image.NewGray(image.Rect(0, 0, posterMaxDimension+1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := savePoster(store, huge.Bytes()); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Oversized image was accepted: %v", err)
+	}
+}
+
+func Test_DownloadPoster(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Served as JPEG, but actually a PNG
+		w.Header().Set("Content-Type", "image/jpeg")
+		png.Encode(w, testImage(300, 450))
+	}))
+	defer ts.Close()
+
+	store := storage.NewLocal(posterDir, "/posters/")
+
+	name, err := downloadPoster(store, ts.URL+"/poster.jpg")
+	if err != nil {
+		t.Fatalf("Unable to download poster: %v", err)
+	}
+
+	if !common.FileExists(filepath.Join(posterDir, name)) || !common.FileExists(filepath.Join(posterDir, common.PosterThumb(name))) {
+		t.Errorf("Poster files missing for %q", name)
+	}
+
+	if _, err = downloadPoster(store, ts.URL+"/missing.jpg"); err == nil {
+		t.Errorf("Expected an error for a missing poster")
+	}
+}
+
+func Test_PosterUrl(t *testing.T) {
+	s := newTestServer(t)
+
+	store, err := storage.NewS3(storage.S3Config{
+		Endpoint:  "http://localhost:9000",
+		Bucket:    "movies",
+		Prefix:    "posters/",
+		PublicUrl: "https://cdn.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.posters = store
+
+	hashed := "0123456789abcdef0123456789abcdef.jpg"
+	tests := map[string]string{
+		"":                         "/posters/unknown.jpg",
+		"unknown.jpg":              "/posters/unknown.jpg",
+		"42.jpg":                   "/posters/42.jpg",
+		hashed:                     "https://cdn.example.com/posters/" + hashed,
+		common.PosterThumb(hashed): "https://cdn.example.com/posters/" + common.PosterThumb(hashed),
+	}
+
+	for name, expected := range tests {
+		if url := s.posterUrl(name); url != expected {
+			t.Errorf("posterUrl(%q): got %q, expected %q", name, url, expected)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// Ratings and reviews users give movies after watching them.
+
+// reviewView is a review with its author and movie, for the templates.
+type reviewView struct {
+	*common.Review
+	User  *common.User
+	Movie *common.Movie
+}
+
+// reviewRatings returns the ratings to pick from, lowest first.
+func reviewRatings() []int {
+	ratings := []int{}
+	for i := common.ReviewMinRating; i <= common.ReviewMaxRating; i++ {
+		ratings = append(ratings, i)
+	}
+	return ratings
+}
+
+// movieReviews returns the reviews of a movie with their authors and their
+// community rating.  Reviews of users that can't be found are left out of
+// both.
+func (s *Server) movieReviews(movie *common.Movie) ([]reviewView, common.RatingSummary, error) {
+	reviews, err := s.data.GetMovieReviews(movie.Id)
+	if err != nil {
+		return nil, common.RatingSummary{}, fmt.Errorf("Unable to get reviews of movie %d: %v", movie.Id, err)
+	}
+
+	views := []reviewView{}
+	shown := []*common.Review{}
+	for _, review := range reviews {
+		user, err := s.data.GetUser(review.UserId)
+		if err != nil {
+			s.l.Error("Unable to get user %d of review %d: %v", review.UserId, review.Id, err)
+			continue
+		}
+		views = append(views, reviewView{Review: review, User: user, Movie: movie})
+		shown = append(shown, review)
+	}
+
+	return views, common.SummarizeReviews(shown), nil
+}
+
+// userReviews returns the reviews by a user with their movies.
+func (s *Server) userReviews(user *common.User) ([]reviewView, error) {
+	reviews, err := s.data.GetUserReviews(user.Id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get reviews by user %d: %v", user.Id, err)
+	}
+
+	views := []reviewView{}
+	for _, review := range reviews {
+		movie, err := s.data.GetMovie(review.MovieId)
+		if err != nil {
+			s.l.Error("Unable to get movie %d of review %d: %v", review.MovieId, review.Id, err)
+			continue
+		}
+		views = append(views, reviewView{Review: review, User: user, Movie: movie})
+	}
+	return views, nil
+}
+
+// cycleRatings returns the community ratings of the movies watched in the
+// cycles, by movie ID.  Movies without ratings are left out.  Like
+// movieReviews, only reviews of users that can be found count.
+func (s *Server) cycleRatings(cycles []*common.Cycle) (map[int]*common.RatingSummary, error) {
+	ratings := map[int]*common.RatingSummary{}
+	for _, cycle := range cycles {
+		for _, movie := range cycle.Watched {
+			reviews, err := s.data.GetMovieReviews(movie.Id)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to get reviews of movie %d: %v", movie.Id, err)
+			}
+
+			// Same reviews as on the movie page
+			shown := []*common.Review{}
+			for _, review := range reviews {
+				if _, err := s.data.GetUser(review.UserId); err != nil {
+					continue
+				}
+				shown = append(shown, review)
+			}
+
+			if sum := common.SummarizeReviews(shown); sum.Count > 0 {
+				ratings[movie.Id] = &sum
+			}
+		}
+	}
+	return ratings, nil
+}
+
+// Adds, updates, or deletes the user's review of a watched movie
+func (s *Server) handlerMovieReview(movie *common.Movie, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.doError(http.StatusMethodNotAllowed, "Reviews must be POSTed", w, r)
+		return
+	}
+
+	user := s.getSessionUser(w, r)
+	if user == nil {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	if movie.CycleWatched == nil {
+		s.doError(http.StatusBadRequest, "Only watched movies can be rated", w, r)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.l.Error("ParseForm() error: %v", err)
+		s.doError(http.StatusInternalServerError, "Form error", w, r)
+		return
+	}
+
+	movieUrl := fmt.Sprintf("/movie/%d", movie.Id)
+
+	if r.PostFormValue("Form") == "Delete" {
+		if err := s.data.DeleteReview(user.Id, movie.Id); err != nil {
+			s.l.Error("Unable to delete review of movie %d by user %d: %v", movie.Id, user.Id, err)
+			s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+			return
+		}
+
+		http.Redirect(w, r, movieUrl, http.StatusSeeOther)
+		return
+	}
+
+	rating, err := strconv.Atoi(r.PostFormValue("Rating"))
+	if err != nil || rating < common.ReviewMinRating || rating > common.ReviewMaxRating {
+		s.doError(http.StatusBadRequest, "Pick a rating from 1 to 10", w, r)
+		return
+	}
+
+	text := strings.TrimSpace(strings.ReplaceAll(r.PostFormValue("Text"), "\r", ""))
+
+	maxLength, err := s.data.GetCfgInt(ConfigMaxReviewLength, DefaultMaxReviewLength)
+	if err != nil {
+		s.l.Error("Unable to get %q: %v", ConfigMaxReviewLength, err)
+		maxLength = DefaultMaxReviewLength
+	}
+
+	if common.GetStringLength(text) > maxLength {
+		s.doError(
+			http.StatusBadRequest,
+			s.requestLocale(user, r).T("Review too long! Max Length: %d characters", maxLength),
+			w, r)
+		return
+	}
+
+	_, err = s.data.SetReview(&common.Review{
+		MovieId: movie.Id,
+		UserId:  user.Id,
+		Rating:  rating,
+		Text:    text,
+	})
+	if err != nil {
+		s.l.Error("Unable to save review of movie %d by user %d: %v", movie.Id, user.Id, err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	http.Redirect(w, r, movieUrl, http.StatusSeeOther)
+}
@@ -0,0 +1,141 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+func Test_SummarizeReviews(t *testing.T) {
+	sum := common.SummarizeReviews(nil)
+	if sum.Count != 0 || sum.Average != 0 {
+		t.Errorf("Unexpected summary without reviews: %v", sum)
+	}
+
+	sum = common.SummarizeReviews([]*common.Review{{Rating: 8}, {Rating: 5}, {Rating: 10}})
+	if sum.Count != 3 || sum.Average != 23.0/3.0 {
+		t.Errorf("Unexpected summary: %v", sum)
+	}
+}
+
+func Test_MovieReviews(t *testing.T) {
+	s, admin, movie := setupShowingTest(t)
+	path := fmt.Sprintf("/movie/%d/review", movie.Id)
+
+	review := func(user *common.User, form url.Values) int {
+		cookies := loginCookies(t, s, user)
+		form.Set("CsrfToken", csrfTokenFor(t, s, cookies))
+		return postForm(s, path, form, cookies).Code
+	}
+
+	alice := addTestUser(t, s, "alice", common.PRIV_USER)
+	bob := addTestUser(t, s, "bobby", common.PRIV_USER)
+
+	if code := review(alice, url.Values{"Rating": {"3"}, "Text": {"Not my thing"}}); code != http.StatusSeeOther {
+		t.Fatalf("Unexpected status %d", code)
+	}
+
+	// Rating again replaces the old review
+	if code := review(alice, url.Values{"Rating": {"8"}, "Text": {"Grew on me"}}); code != http.StatusSeeOther {
+		t.Fatalf("Unexpected status %d", code)
+	}
+
+	if code := review(bob, url.Values{"Rating": {"5"}}); code != http.StatusSeeOther {
+		t.Fatalf("Unexpected status %d", code)
+	}
+
+	reviews, err := s.data.GetMovieReviews(movie.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reviews) != 2 {
+		t.Fatalf("Expected two reviews, got %d", len(reviews))
+	}
+
+	for _, form := range []url.Values{
+		{"Rating": {"0"}},
+		{"Rating": {"11"}},
+		{"Rating": {"ten"}},
+		{"Rating": {"7"}, "Text": {strings.Repeat("a", DefaultMaxReviewLength+1)}},
+	} {
+		if code := review(bob, form); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %d", form, code)
+		}
+	}
+
+	cookies := loginCookies(t, s, alice)
+	page := getPage(s, fmt.Sprintf("/movie/%d", movie.Id), "", cookies)
+	for _, expected := range []string{
+		"Community rating: 6.5/10 from 2 ratings",
+		"<strong>alice</strong> 8/10<br />Grew on me",
+		"<strong>bobby</strong> 5/10",
+		`<option value="8" selected>8</option>`,
+		">Delete rating</button>",
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Movie page is missing %q", expected)
+		}
+	}
+
+	if page = getPage(s, "/history", "", nil); !strings.Contains(page, "6.5/10") {
+		t.Errorf("History is missing the community rating")
+	}
+
+	page = getPage(s, "/user", "", cookies)
+	if !strings.Contains(page, fmt.Sprintf(`<a href="/movie/%d">Picked Movie</a> 8/10 - Grew on me`, movie.Id)) {
+		t.Errorf("Account page is missing the user's rating")
+	}
+
+	// Reviews of users that can't be found don't count
+	if _, err = s.data.SetReview(&common.Review{MovieId: movie.Id, UserId: 9999, Rating: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if page = getPage(s, fmt.Sprintf("/movie/%d", movie.Id), "", cookies); !strings.Contains(page, "Community rating: 6.5/10 from 2 ratings") {
+		t.Errorf("Movie page counts the review of a missing user")
+	}
+	if page = getPage(s, "/history", "", nil); !strings.Contains(page, "6.5/10") {
+		t.Errorf("History counts the review of a missing user")
+	}
+
+	// Purging a user deletes their reviews
+	adminCookies := loginCookies(t, s, admin)
+	form := url.Values{"confirm": {"yes"}, "CsrfToken": {csrfTokenFor(t, s, adminCookies)}}
+	if code := postForm(s, fmt.Sprintf("/admin/user/%d?action=purge", bob.Id), form, adminCookies).Code; code != http.StatusOK {
+		t.Fatalf("Unable to purge user: %d", code)
+	}
+
+	if r, err := s.data.GetUserReview(bob.Id, movie.Id); err != nil || r != nil {
+		t.Errorf("Review of a purged user wasn't deleted: %v", err)
+	}
+
+	if page = getPage(s, fmt.Sprintf("/movie/%d", movie.Id), "", cookies); !strings.Contains(page, "Community rating: 8.0/10 from 1 rating<") {
+		t.Errorf("Movie page still counts the review of a purged user")
+	}
+	if page = getPage(s, "/history", "", nil); !strings.Contains(page, `title="1 rating"`) {
+		t.Errorf("History doesn't count a single rating")
+	}
+
+	if code := review(alice, url.Values{"Form": {"Delete"}}); code != http.StatusSeeOther {
+		t.Fatalf("Unexpected status %d", code)
+	}
+
+	if r, err := s.data.GetUserReview(alice.Id, movie.Id); err != nil || r != nil {
+		t.Errorf("Review wasn't deleted: %v", err)
+	}
+
+	// Movies that weren't watched can't be rated
+	movieId, err := s.data.AddMovie(&common.Movie{Name: "Not Watched", AddedBy: alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path = fmt.Sprintf("/movie/%d/review", movieId)
+	if code := review(alice, url.Values{"Rating": {"7"}}); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unwatched movie, got %d", code)
+	}
+}
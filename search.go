@@ -0,0 +1,509 @@
+package moviepoll
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// Weight of a term depending on the field it was found in
+const (
+	searchWeightName    float64 = 10
+	searchWeightTag     float64 = 6
+	searchWeightDescr   float64 = 2
+	searchWeightRemarks float64 = 1
+	searchWeightLink    float64 = 1
+)
+
+// Multiplier depending on how well a query term matched
+const (
+	searchMatchExact  float64 = 1
+	searchMatchPrefix float64 = 0.6
+	searchMatchFuzzy  float64 = 0.3
+)
+
+// Bonus for a quoted phrase found in the title, or anywhere else
+const (
+	searchPhraseName  float64 = 15
+	searchPhraseOther float64 = 5
+)
+
+// The index is rebuilt after changes, and at least this often to catch
+// anything that changed without an event.
+const searchMaxAge time.Duration = 5 * time.Minute
+
+// searchQuery is a parsed search.  Free text is matched against the title,
+// description, remarks, tags and links.  Filters:
+//
+//	"some words"   exact phrase
+//	tag:horror     has the tag (t: works too), quote tags with spaces
+//	added-by:name  added by the user
+//	watched:yes    only watched movies, watched:no for the current ones
+//	cycle:3        added or watched in cycle 3, cycle:current for this cycle
+type searchQuery struct {
+	Terms   []string
+	Phrases []string
+
+	Tags    []string
+	AddedBy string
+	Watched string // "", "yes" or "no"
+	Cycle   string // "", "current" or a cycle ID
+}
+
+func (q searchQuery) hasText() bool {
+	return len(q.Terms) > 0 || len(q.Phrases) > 0
+}
+
+// splitSearchQuery splits on spaces, keeping quoted parts together.  Quotes
+// are kept so phrases can be told apart from words.
+func splitSearchQuery(raw string) []string {
+	parts := []string{}
+	current := strings.Builder{}
+	quoted := false
+
+	for _, r := range raw {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+func parseSearchQuery(raw string) searchQuery {
+	q := searchQuery{}
+
+	for _, part := range splitSearchQuery(raw) {
+		key, value := "", part
+		if idx := strings.Index(part, ":"); idx > 0 && !strings.HasPrefix(part, "\"") {
+			key = strings.ToLower(part[:idx])
+			value = part[idx+1:]
+		}
+		value = strings.ToLower(strings.Trim(value, "\""))
+
+		switch key {
+		case "tag", "t":
+			if value != "" {
+				q.Tags = append(q.Tags, value)
+			}
+			continue
+		case "added-by", "by":
+			q.AddedBy = value
+			continue
+		case "watched":
+			switch value {
+			case "yes", "true", "1":
+				q.Watched = "yes"
+			case "no", "false", "0":
+				q.Watched = "no"
+			}
+			continue
+		case "cycle":
+			q.Cycle = value
+			continue
+		}
+
+		if strings.HasPrefix(part, "\"") {
+			if phrase := strings.Join(searchTokens(value), " "); phrase != "" {
+				q.Phrases = append(q.Phrases, phrase)
+			}
+			continue
+		}
+
+		// Not a known filter, eg a time like 10:30
+		q.Terms = append(q.Terms, searchTokens(part)...)
+	}
+
+	return q
+}
+
+// searchTokens lowercases the text and splits it into words.
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// levenshtein returns the edit distance between a and b, or max+1 if it is
+// larger than max.
+func levenshtein(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for i := range prev {
+		prev[i] = i
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		best := curr[0]
+		for k := 1; k <= len(rb); k++ {
+			cost := 1
+			if ra[i-1] == rb[k-1] {
+				cost = 0
+			}
+
+			curr[k] = prev[k-1] + cost
+			if v := prev[k] + 1; v < curr[k] {
+				curr[k] = v
+			}
+			if v := curr[k-1] + 1; v < curr[k] {
+				curr[k] = v
+			}
+
+			if curr[k] < best {
+				best = curr[k]
+			}
+		}
+
+		if best > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// Number of typos allowed in a term
+func searchFuzziness(term string) int {
+	switch n := len([]rune(term)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+type searchDoc struct {
+	MovieId int
+	Name    string
+	Text    string // every field, for phrases
+	Tags    []string
+	AddedBy string
+
+	CycleAdded   int
+	CycleWatched int
+}
+
+type searchResult struct {
+	MovieId int
+	Score   float64
+}
+
+// searchIndex is an inverted index of all movies.
+type searchIndex struct {
+	lock  *sync.RWMutex
+	built time.Time
+	dirty bool
+
+	docs  map[int]*searchDoc
+	terms map[string]map[int]float64 // term -> movie ID -> weight
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		lock:  &sync.RWMutex{},
+		dirty: true,
+	}
+}
+
+// invalidate makes the next search rebuild the index.
+func (idx *searchIndex) invalidate() {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.dirty = true
+}
+
+func (idx *searchIndex) stale() bool {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return idx.dirty || time.Since(idx.built) > searchMaxAge
+}
+
+func (idx *searchIndex) build(movies []*common.Movie) {
+	docs := map[int]*searchDoc{}
+	terms := map[string]map[int]float64{}
+
+	add := func(id int, text string, weight float64) {
+		for _, term := range searchTokens(text) {
+			if terms[term] == nil {
+				terms[term] = map[int]float64{}
+			}
+			terms[term][id] += weight
+		}
+	}
+
+	for _, movie := range movies {
+		doc := &searchDoc{
+			MovieId: movie.Id,
+			Name:    strings.Join(searchTokens(movie.Name), " "),
+		}
+
+		text := []string{movie.Name, movie.Description, movie.Remarks}
+		add(movie.Id, movie.Name, searchWeightName)
+		add(movie.Id, movie.Description, searchWeightDescr)
+		add(movie.Id, movie.Remarks, searchWeightRemarks)
+
+		for _, tag := range movie.Tags {
+			// Aliases too, so tag:sci-fi finds Science Fiction
+			for _, name := range append([]string{tag.Name}, tag.Aliases...) {
+				doc.Tags = append(doc.Tags, strings.ToLower(strings.TrimSpace(name)))
+				text = append(text, name)
+				add(movie.Id, name, searchWeightTag)
+			}
+		}
+
+		for _, link := range movie.Links {
+			add(movie.Id, link.Url, searchWeightLink)
+		}
+
+		if movie.AddedBy != nil {
+			doc.AddedBy = strings.ToLower(movie.AddedBy.Name)
+		}
+
+		if movie.CycleAdded != nil {
+			doc.CycleAdded = movie.CycleAdded.Id
+		}
+
+		if movie.CycleWatched != nil {
+			doc.CycleWatched = movie.CycleWatched.Id
+		}
+
+		doc.Text = strings.Join(searchTokens(strings.Join(text, " ")), " ")
+		docs[movie.Id] = doc
+	}
+
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	idx.docs = docs
+	idx.terms = terms
+	idx.built = time.Now()
+	idx.dirty = false
+}
+
+// matchTerm returns the score of every movie matching the query term.
+// Must be called with the lock held.
+func (idx *searchIndex) matchTerm(query string) map[int]float64 {
+	scores := map[int]float64{}
+	fuzziness := searchFuzziness(query)
+
+	for term, postings := range idx.terms {
+		quality := 0.0
+		switch {
+		case term == query:
+			quality = searchMatchExact
+		case len(query) >= 2 && strings.HasPrefix(term, query):
+			quality = searchMatchPrefix
+		case fuzziness > 0 && levenshtein(term, query, fuzziness) <= fuzziness:
+			quality = searchMatchFuzzy
+		default:
+			continue
+		}
+
+		// Only the best match of each movie counts
+		for id, weight := range postings {
+			if score := weight * quality; score > scores[id] {
+				scores[id] = score
+			}
+		}
+	}
+
+	return scores
+}
+
+// matchFilters checks everything but the free text.  Must be called with
+// the lock held.
+func (idx *searchIndex) matchFilters(doc *searchDoc, q searchQuery, currentCycle int) bool {
+	for _, tag := range q.Tags {
+		found := false
+		for _, t := range doc.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if q.AddedBy != "" && doc.AddedBy != q.AddedBy {
+		return false
+	}
+
+	if q.Watched == "yes" && doc.CycleWatched == 0 {
+		return false
+	}
+
+	if q.Watched == "no" && doc.CycleWatched != 0 {
+		return false
+	}
+
+	if q.Cycle != "" {
+		cycle := currentCycle
+		if q.Cycle != "current" {
+			var err error
+			if cycle, err = strconv.Atoi(q.Cycle); err != nil {
+				return false
+			}
+		}
+
+		if cycle == 0 || (doc.CycleAdded != cycle && doc.CycleWatched != cycle) {
+			return false
+		}
+	}
+
+	for _, phrase := range q.Phrases {
+		if !strings.Contains(doc.Text, phrase) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// search returns the IDs of the matching movies, best match first.  Every
+// term has to match.  Current movies are listed before watched ones with
+// the same score.
+func (idx *searchIndex) search(q searchQuery, currentCycle int) []searchResult {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	var scores map[int]float64
+	for _, term := range q.Terms {
+		matched := idx.matchTerm(term)
+		if scores == nil {
+			scores = matched
+			continue
+		}
+
+		for id := range scores {
+			if m, ok := matched[id]; ok {
+				scores[id] += m
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	// Only filters and phrases, start with everything.
+	if scores == nil {
+		scores = map[int]float64{}
+		for id := range idx.docs {
+			scores[id] = 0
+		}
+	}
+
+	results := []searchResult{}
+	for id, score := range scores {
+		doc := idx.docs[id]
+		if doc == nil || !idx.matchFilters(doc, q, currentCycle) {
+			continue
+		}
+
+		for _, phrase := range q.Phrases {
+			if strings.Contains(doc.Name, phrase) {
+				score += searchPhraseName
+			} else {
+				score += searchPhraseOther
+			}
+		}
+
+		results = append(results, searchResult{MovieId: id, Score: score})
+	}
+
+	sort.Slice(results, func(i, k int) bool {
+		a, b := results[i], results[k]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+
+		aWatched := idx.docs[a.MovieId].CycleWatched != 0
+		bWatched := idx.docs[b.MovieId].CycleWatched != 0
+		if aWatched != bWatched {
+			return !aWatched
+		}
+
+		return idx.docs[a.MovieId].Name < idx.docs[b.MovieId].Name
+	})
+
+	return results
+}
+
+// searchMovies runs a search over all movies, including watched ones.
+// Movies waiting for approval are left out.
+func (s *Server) searchMovies(raw string) ([]*common.Movie, error) {
+	approval, err := s.data.GetCfgBool(ConfigEntriesRequireApproval, DefaultEntriesRequireApproval)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.search.stale() {
+		all, err := s.data.GetMovies()
+		if err != nil {
+			return nil, err
+		}
+
+		movies := []*common.Movie{}
+		for _, movie := range all {
+			if !movie.Removed && (movie.Approved || !approval) {
+				movies = append(movies, movie)
+			}
+		}
+
+		s.search.build(movies)
+	}
+
+	currentCycle := 0
+	if cycle, err := s.data.GetCurrentCycle(); err == nil && cycle != nil {
+		currentCycle = cycle.Id
+	}
+
+	found := []*common.Movie{}
+	for _, result := range s.search.search(parseSearchQuery(raw), currentCycle) {
+		movie, err := s.data.GetMovie(result.MovieId)
+		if err != nil {
+			// Removed since the index was built
+			continue
+		}
+		found = append(found, movie)
+	}
+
+	return found, nil
+}
+
+// startSearchIndex keeps the index up to date.  Vote counts aren't indexed,
+// so votes don't invalidate it.
+func (s *Server) startSearchIndex() {
+	ch := s.events.subscribeQueued(EventMovie, EventApproved, EventRemoved, EventCycle)
+	go func() {
+		for range ch {
+			s.search.invalidate()
+		}
+	}()
+}
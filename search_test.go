@@ -0,0 +1,181 @@
+package moviepoll
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+func Test_ParseSearchQuery(t *testing.T) {
+	q := parseSearchQuery(`space "the last" tag:"Science Fiction" t:horror added-by:Bob watched:no cycle:current 10:30`)
+
+	if strings.Join(q.Terms, ",") != "space,10,30" {
+		t.Errorf("Unexpected terms: %q", q.Terms)
+	}
+
+	if len(q.Phrases) != 1 || q.Phrases[0] != "the last" {
+		t.Errorf("Unexpected phrases: %q", q.Phrases)
+	}
+
+	if strings.Join(q.Tags, ",") != "science fiction,horror" {
+		t.Errorf("Unexpected tags: %q", q.Tags)
+	}
+
+	if q.AddedBy != "bob" || q.Watched != "no" || q.Cycle != "current" {
+		t.Errorf("Unexpected filters: %+v", q)
+	}
+}
+
+func Test_Levenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		max  int
+		dist int
+	}{
+		{"alien", "alien", 1, 0},
+		{"alien", "alein", 2, 2},
+		{"matrix", "matrx", 1, 1},
+		{"matrix", "casablanca", 2, 3},
+	}
+
+	for _, tt := range tests {
+		if d := levenshtein(tt.a, tt.b, tt.max); d != tt.dist {
+			t.Errorf("levenshtein(%q, %q, %d) = %d, expected %d", tt.a, tt.b, tt.max, d, tt.dist)
+		}
+	}
+}
+
+func addSearchMovie(t *testing.T, s *Server, movie *common.Movie) int {
+	t.Helper()
+
+	for _, tag := range movie.Tags {
+		id, err := s.data.AddTag(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag.Id = id
+	}
+
+	id, err := s.data.AddMovie(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func searchNames(t *testing.T, s *Server, query string) []string {
+	t.Helper()
+
+	movies, err := s.searchMovies(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, movie := range movies {
+		names = append(names, movie.Name)
+	}
+	return names
+}
+
+func Test_SearchMovies(t *testing.T) {
+	s := newTestServer(t)
+	alice := addTestUser(t, s, "alice", common.PRIV_USER)
+	bob := addTestUser(t, s, "bob", common.PRIV_USER)
+
+	past, err := s.data.AddCycle(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pastCycle, err := s.data.GetCycle(past)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addSearchMovie(t, s, &common.Movie{
+		Name:         "Alien",
+		Description:  "A crew in space meets a deadly creature.",
+		AddedBy:      alice,
+		Tags:         []*common.Tag{&common.Tag{Name: "Horror"}, &common.Tag{Name: "Science Fiction"}},
+		CycleWatched: pastCycle,
+	})
+
+	ended := time.Now()
+	pastCycle.Ended = &ended
+	if err = s.data.UpdateCycle(pastCycle); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.data.AddCycle(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	addSearchMovie(t, s, &common.Movie{
+		Name:        "Space Jam",
+		Description: "Basketball with cartoons.",
+		AddedBy:     bob,
+		Tags:        []*common.Tag{&common.Tag{Name: "Comedy"}},
+	})
+
+	addSearchMovie(t, s, &common.Movie{
+		Name:        "The Thing",
+		Description: "Something in the ice, far from space.",
+		Remarks:     "Better than Alien",
+		AddedBy:     alice,
+		Tags:        []*common.Tag{&common.Tag{Name: "Horror"}},
+	})
+
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		// Title beats description, current beats watched
+		{"space", "Space Jam,The Thing,Alien"},
+		{"alien", "Alien,The Thing"},
+		{"allen", "Alien,The Thing"},
+		{"spa", "Space Jam,The Thing,Alien"},
+		{"spac jam", "Space Jam"},
+		{"horror", "The Thing,Alien"},
+		{`tag:"science fiction"`, "Alien"},
+		{"tag:horror creature", "Alien"},
+		{"added-by:bob", "Space Jam"},
+		{"watched:yes", "Alien"},
+		{"watched:no tag:horror", "The Thing"},
+		{"cycle:current", "Space Jam,The Thing"},
+		{fmt.Sprintf("cycle:%d", past), "Alien"},
+		{`"deadly creature"`, "Alien"},
+		{`"creature deadly"`, ""},
+		{"space basketball", "Space Jam"},
+		{"nothing-matches-this", ""},
+	}
+
+	for _, tt := range tests {
+		if names := strings.Join(searchNames(t, s, tt.query), ","); names != tt.expected {
+			t.Errorf("Search %q: expected %q, got %q", tt.query, tt.expected, names)
+		}
+	}
+}
+
+func Test_SearchIndexInvalidate(t *testing.T) {
+	s := newTestServer(t)
+
+	addSearchMovie(t, s, &common.Movie{Name: "Before"})
+	if names := searchNames(t, s, "after"); len(names) != 0 {
+		t.Fatalf("Unexpected results: %q", names)
+	}
+
+	// Not in the index until it's invalidated
+	addSearchMovie(t, s, &common.Movie{Name: "After"})
+	if names := searchNames(t, s, "after"); len(names) != 0 {
+		t.Fatalf("Index was rebuilt without being invalidated: %q", names)
+	}
+
+	s.search.invalidate()
+	if names := strings.Join(searchNames(t, s, "after"), ","); names != "After" {
+		t.Errorf("Expected the new movie, got %q", names)
+	}
+}
@@ -1,19 +1,26 @@
 package moviepoll
 
 import (
+	"bytes"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/zorchenhimer/MoviePolls/common"
 	mpd "github.com/zorchenhimer/MoviePolls/data"
+	"github.com/zorchenhimer/MoviePolls/i18n"
+	"github.com/zorchenhimer/MoviePolls/storage"
 )
 
 const SessionName string = "moviepoll-session"
@@ -37,6 +44,46 @@ const (
 	DefaultMaxDescriptionLength int = 1000
 	DefaultMaxLinkLength        int = 500 // length of all links combined
 	DefaultMaxRemarksLength     int = 200
+	DefaultMaxReviewLength      int = 500
+
+	DefaultMaxTags      int = 10 // per movie, zero is unlimited
+	DefaultMaxTagLength int = 30
+
+	DefaultMaxUserMoviesPerCycle int = 0 // zero is unlimited
+	DefaultMovieAddCooldown      int = 0 // in minutes
+
+	DefaultOidcEnabled     bool   = false
+	DefaultOidcName        string = "OpenID Connect"
+	DefaultOidcGroupsClaim string = "groups"
+	DefaultOidcCreateUsers bool   = true
+
+	DefaultLoginMaxFailures int    = 10
+	DefaultLoginLockout     int    = 15 // in minutes
+	DefaultTrustedProxies   string = ""
+
+	DefaultRequireTwoFactor bool = false
+
+	DefaultOverlayToken string = "" // empty disables the token check
+
+	DefaultDiscordWebhook  string = ""
+	DefaultDiscordReminder int    = 60 // minutes before the planned end, zero disables
+
+	DefaultTwitchEnabled bool   = false
+	DefaultTwitchServer  string = "irc.chat.twitch.tv:6697"
+	DefaultTwitchTls     bool   = true
+
+	DefaultPosterStorage string = "local" // or "s3"
+	DefaultS3Region      string = "us-east-1"
+	DefaultS3Prefix      string = "posters/"
+
+	// Match the colors in site.css
+	DefaultSiteName        string = "MoviePolls"
+	DefaultAccentColor     string = "#2266aa"
+	DefaultHeaderColor     string = "#70707f"
+	DefaultBackgroundColor string = "#333333"
+	DefaultTextColor       string = "#cfccd1"
+
+	DefaultTimeZone string = "UTC" // IANA name, eg "Europe/Berlin"
 )
 
 // configuration keys
@@ -60,17 +107,75 @@ const (
 	ConfigMaxDescriptionLength string = "MaxDescriptionLength"
 	ConfigMaxLinkLength        string = "MaxLinkLength"
 	ConfigMaxRemarksLength     string = "MaxRemarksLength"
+	ConfigMaxReviewLength      string = "MaxReviewLength"
+
+	ConfigMaxTags      string = "MaxTags"
+	ConfigMaxTagLength string = "MaxTagLength"
+
+	ConfigMaxUserMoviesPerCycle string = "MaxUserMoviesPerCycle"
+	ConfigMovieAddCooldown      string = "MovieAddCooldown"
+
+	ConfigOidcEnabled      string = "OidcEnabled"
+	ConfigOidcName         string = "OidcName"
+	ConfigOidcIssuer       string = "OidcIssuer"
+	ConfigOidcClientId     string = "OidcClientId"
+	ConfigOidcClientSecret string = "OidcClientSecret"
+	ConfigOidcGroupsClaim  string = "OidcGroupsClaim"
+	ConfigOidcModGroup     string = "OidcModGroup"
+	ConfigOidcAdminGroup   string = "OidcAdminGroup"
+	ConfigOidcCreateUsers  string = "OidcCreateUsers"
+
+	ConfigLoginMaxFailures string = "LoginMaxFailures"
+	ConfigLoginLockout     string = "LoginLockout"
+	ConfigTrustedProxies   string = "TrustedProxies"
+
+	ConfigRequireTwoFactor string = "RequireTwoFactor"
+
+	ConfigOverlayToken string = "OverlayToken"
+
+	ConfigDiscordWebhook  string = "DiscordWebhook"
+	ConfigDiscordReminder string = "DiscordReminder"
+
+	ConfigTwitchEnabled string = "TwitchEnabled"
+	ConfigTwitchServer  string = "TwitchServer"
+	ConfigTwitchTls     string = "TwitchTls"
+	ConfigTwitchNick    string = "TwitchNick"
+	ConfigTwitchToken   string = "TwitchToken"
+	ConfigTwitchChannel string = "TwitchChannel"
+
+	ConfigPosterStorage string = "PosterStorage"
+	ConfigS3Endpoint    string = "S3Endpoint"
+	ConfigS3Region      string = "S3Region"
+	ConfigS3Bucket      string = "S3Bucket"
+	ConfigS3AccessKey   string = "S3AccessKey"
+	ConfigS3SecretKey   string = "S3SecretKey"
+	ConfigS3Prefix      string = "S3Prefix"
+	ConfigS3PublicUrl   string = "S3PublicUrl"
+
+	ConfigSiteName        string = "SiteName"
+	ConfigSiteLogo        string = "SiteLogo"
+	ConfigSiteFavicon     string = "SiteFavicon"
+	ConfigAccentColor     string = "AccentColor"
+	ConfigHeaderColor     string = "HeaderColor"
+	ConfigBackgroundColor string = "BackgroundColor"
+	ConfigTextColor       string = "TextColor"
+	ConfigCustomCss       string = "CustomCss"
+	ConfigFooterLinks     string = "FooterLinks"
+
+	ConfigTimeZone string = "TimeZone"
 )
 
 type Options struct {
-	Listen   string // eg, "127.0.0.1:8080" or ":8080" (defaults to 0.0.0.0:8080)
-	Debug    bool   // debug logging to console
-	LogLevel common.LogLevel
-	LogFile  string
+	Listen    string // eg, "127.0.0.1:8080" or ":8080" (defaults to 0.0.0.0:8080)
+	Debug     bool   // debug logging to console
+	LogLevel  common.LogLevel
+	LogFile   string
+	Overrides string // directory with templates and static files replacing the built in ones
 }
 
 type Server struct {
 	templates map[string]*template.Template
+	files     fs.FS // templates and static files
 	s         *http.Server
 	debug     bool // turns on debug things (eg, reloading templates on each page request)
 	data      mpd.DataConnector
@@ -81,6 +186,22 @@ type Server struct {
 	l *common.Logger
 
 	urlKeys map[string]*common.UrlKey
+
+	oidc     *oidcProvider
+	oidcLock *sync.Mutex
+
+	loginLimits *loginLimiter
+
+	events     *eventHub
+	webhookLog *webhookLog
+	search     *searchIndex
+
+	posters       storage.Storage
+	brandingFiles storage.Storage
+	assets        *assetCache
+
+	// nil if the chat bot is disabled
+	twitch *twitchBot
 }
 
 func NewServer(options Options) (*Server, error) {
@@ -126,10 +247,18 @@ func NewServer(options Options) (*Server, error) {
 	server := &Server{
 		debug: options.Debug,
 		data:  data,
+		files: newServerFiles(options.Overrides, options.Debug),
 
 		cookies: sessions.NewCookieStore([]byte(authKey), []byte(encryptKey)),
 		l:       l,
 		urlKeys: make(map[string]*common.UrlKey),
+
+		oidcLock: &sync.Mutex{},
+
+		loginLimits: newLoginLimiter(),
+		events:      newEventHub(l),
+		webhookLog:  newWebhookLog(),
+		search:      newSearchIndex(),
 	}
 
 	server.passwordSalt, err = server.data.GetCfgString("PassSalt", "")
@@ -169,39 +298,29 @@ func NewServer(options Options) (*Server, error) {
 		fmt.Printf("Claim admin: %s/auth/%s Password: %s\n", host, urlKey.Url, urlKey.Key)
 	}
 
-	mux := http.NewServeMux()
-	mux.Handle("/api/", apiHandler{})
-	mux.HandleFunc("/movie/", server.handlerMovie)
-	mux.HandleFunc("/static/", server.handlerStatic)
-	mux.HandleFunc("/posters/", server.handlerPoster)
-	mux.HandleFunc("/add", server.handlerAddMovie)
+	server.posters, err = newPosterStorage(server.data)
+	if err != nil {
+		return nil, err
+	}
 
-	// list of past cycles
-	mux.HandleFunc("/history", server.handlerHistory)
-
-	mux.HandleFunc("/user", server.handlerUser)
-	mux.HandleFunc("/user/login", server.handlerUserLogin)
-	mux.HandleFunc("/user/logout", server.handlerUserLogout)
-	mux.HandleFunc("/user/new", server.handlerUserNew)
-
-	mux.HandleFunc("/vote/", server.handlerVote)
-	mux.HandleFunc("/", server.handlerRoot)
-	mux.HandleFunc("/favicon.ico", server.handlerFavicon)
-
-	mux.HandleFunc("/auth/", server.handlerAuth)
-	mux.HandleFunc("/admin/", server.handlerAdmin)
-	mux.HandleFunc("/admin/config", server.handlerAdminConfig)
-	mux.HandleFunc("/admin/cycles", server.handlerAdminCycles)
-	mux.HandleFunc("/admin/cyclepost", server.handlerAdminCycles_Post)
-	// mux.HandleFunc("/admin/nextcycle", server.handlerAdminNextCycle)
-	mux.HandleFunc("/admin/user/", server.handlerAdminUserEdit)
-	mux.HandleFunc("/admin/users", server.handlerAdminUsers)
-	mux.HandleFunc("/admin/movies", server.handlerAdminMovies)
-	mux.HandleFunc("/admin/movie/", server.handlerAdminMovieEdit)
-
-	hs.Handler = mux
+	server.brandingFiles = storage.NewLocal(brandingDir, "/branding/")
+
+	server.assets, err = loadAssets(server.files)
+	if err != nil {
+		return nil, err
+	}
+
+	hs.Handler = server.routes()
 	server.s = hs
 
+	server.startWebhooks()
+	server.startSearchIndex()
+	server.startDiscord()
+
+	if err = server.startTwitch(); err != nil {
+		server.l.Error("Twitch bot not started: %v", err)
+	}
+
 	err = server.registerTemplates()
 	if err != nil {
 		return nil, err
@@ -210,6 +329,58 @@ func NewServer(options Options) (*Server, error) {
 	return server, nil
 }
 
+// routes returns the handler for all of the server's endpoints.
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/", apiHandler{})
+	mux.HandleFunc("/movie/", s.handlerMovie)
+	mux.HandleFunc("/static/", s.handlerStatic)
+	mux.HandleFunc("/posters/", s.handlerPoster)
+	mux.HandleFunc("/branding/", s.handlerBranding)
+	mux.HandleFunc("/add", s.handlerAddMovie)
+	mux.HandleFunc("/tags", s.handlerTagSuggest)
+
+	// list of past cycles
+	mux.HandleFunc("/history", s.handlerHistory)
+
+	// iCalendar feeds
+	mux.HandleFunc("/calendar.ics", s.handlerCalendar)
+	mux.HandleFunc("/calendar/", s.handlerCalendar)
+
+	mux.HandleFunc("/user", s.handlerUser)
+	mux.HandleFunc("/user/login", s.handlerUserLogin)
+	mux.HandleFunc("/user/login/2fa", s.handlerTwoFactorLogin)
+	mux.HandleFunc("/user/login/oidc", s.handlerOidcLogin)
+	mux.HandleFunc("/user/login/oidc/callback", s.handlerOidcCallback)
+	mux.HandleFunc("/user/logout", s.handlerUserLogout)
+	mux.HandleFunc("/user/new", s.handlerUserNew)
+
+	mux.HandleFunc("/vote/", s.handlerVote)
+	mux.HandleFunc("/showing/", s.handlerShowing)
+	mux.HandleFunc("/events", s.handlerEvents)
+	mux.HandleFunc("/overlay", s.handlerOverlay)
+	mux.HandleFunc("/overlay/ws", s.handlerOverlaySocket)
+	mux.HandleFunc("/", s.handlerRoot)
+	mux.HandleFunc("/favicon.ico", s.handlerFavicon)
+
+	mux.HandleFunc("/auth/", s.handlerAuth)
+	mux.HandleFunc("/admin/", s.handlerAdmin)
+	mux.HandleFunc("/admin/config", s.handlerAdminConfig)
+	mux.HandleFunc("/admin/cycles", s.handlerAdminCycles)
+	mux.HandleFunc("/admin/cyclepost", s.handlerAdminCycles_Post)
+	// mux.HandleFunc("/admin/nextcycle", s.handlerAdminNextCycle)
+	mux.HandleFunc("/admin/user/", s.handlerAdminUserEdit)
+	mux.HandleFunc("/admin/users", s.handlerAdminUsers)
+	mux.HandleFunc("/admin/movies", s.handlerAdminMovies)
+	mux.HandleFunc("/admin/movie/", s.handlerAdminMovieEdit)
+	mux.HandleFunc("/admin/showings", s.handlerAdminShowings)
+	mux.HandleFunc("/admin/webhooks", s.handlerAdminWebhooks)
+	mux.HandleFunc("/admin/tags", s.handlerAdminTags)
+	mux.HandleFunc("/admin/branding", s.handlerAdminBranding)
+
+	return s.csrfProtect(mux)
+}
+
 func (s *Server) Run() error {
 	s.l.Info("Listening on address %s", s.s.Addr)
 	return s.s.ListenAndServe()
@@ -253,7 +424,9 @@ func (s *Server) AddUser(user *common.User) error {
 }
 
 func (s *Server) handlerFavicon(w http.ResponseWriter, r *http.Request) {
-	if common.FileExists("data/favicon.ico") {
+	if favicon, err := s.data.GetCfgString(ConfigSiteFavicon, ""); err == nil && favicon != "" {
+		http.Redirect(w, r, "/branding/"+favicon, http.StatusFound)
+	} else if common.FileExists("data/favicon.ico") {
 		http.ServeFile(w, r, "data/favicon.ico")
 	} else {
 		http.NotFound(w, r)
@@ -261,19 +434,79 @@ func (s *Server) handlerFavicon(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handlerStatic(w http.ResponseWriter, r *http.Request) {
-	file := strings.TrimLeft(filepath.Clean("/"+r.URL.Path), "/\\")
+	file := strings.TrimLeft(path.Clean("/"+r.URL.Path), "/")
+	if !strings.HasPrefix(file, staticDir+"/") {
+		http.NotFound(w, r)
+		return
+	}
+
 	if s.debug {
 		s.l.Info("Attempting to serve file %q", file)
+		w.Header().Set("Cache-Control", cacheRevalidate)
+		s.serveFile(w, r, file)
+		return
 	}
-	http.ServeFile(w, r, file)
+
+	if a, ok := s.assets.byUrl[r.URL.Path]; ok {
+		a.serve(w, r, cacheImmutable)
+		return
+	}
+
+	if a, ok := s.assets.byPath[r.URL.Path]; ok {
+		a.serve(w, r, cacheRevalidate)
+		return
+	}
+
+	// Added to the overrides since startup
+	s.serveFile(w, r, file)
 }
 
+// handlerPoster serves posters from the poster storage.  Posters that
+// aren't in the storage, like the placeholder, are served from disk.
+// Stored posters are named after their content and never change.
 func (s *Server) handlerPoster(w http.ResponseWriter, r *http.Request) {
-	file := strings.TrimLeft(filepath.Clean("/"+r.URL.Path), "/\\")
+	name := filepath.Base(r.URL.Path)
 	if s.debug {
-		s.l.Info("Attempting to serve file %q", file)
+		s.l.Info("Attempting to serve poster %q", name)
+	}
+
+	if !common.StoredPoster(name) {
+		w.Header().Set("Cache-Control", cachePosterLegacy)
+		http.ServeFile(w, r, filepath.Join(posterDir, name))
+		return
+	}
+
+	// The name is the ETag, so there's no need to fetch the poster
+	etag := `"` + strings.TrimSuffix(name, ".jpg") + `"`
+	w.Header().Set("Cache-Control", cacheImmutable)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
-	http.ServeFile(w, r, file)
+
+	rc, err := s.posters.Get(name)
+	if err == storage.ErrNotFound {
+		w.Header().Del("Cache-Control")
+		w.Header().Del("ETag")
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		s.l.Error("Unable to get poster %q: %v", name, err)
+		w.Header().Del("Cache-Control")
+		w.Header().Del("ETag")
+		http.Error(w, "Unable to get poster", http.StatusBadGateway)
+		return
+	}
+	defer rc.Close()
+
+	raw, err := ioutil.ReadAll(rc)
+	if err != nil {
+		s.l.Error("Unable to read poster %q: %v", name, err)
+		return
+	}
+
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(raw))
 }
 
 func (s *Server) handlerAddMovie(w http.ResponseWriter, r *http.Request) {
@@ -321,7 +554,20 @@ func (s *Server) handlerAddMovie(w http.ResponseWriter, r *http.Request) {
 		FormfillEnabled: formfillEnabled,
 	}
 
-	if r.Method == "POST" {
+	data.RateLimited, err = s.checkMovieAddLimit(user, currentCycle, data.Locale)
+	if err != nil {
+		s.doError(
+			http.StatusInternalServerError,
+			"Something went wrong :C",
+			w, r)
+
+		s.l.Error("Unable to check movie add limit: %v", err)
+		return
+	}
+
+	if r.Method == "POST" && data.RateLimited != "" {
+		s.l.Debug("User %s is rate limited: %s", user.Name, data.RateLimited)
+	} else if r.Method == "POST" {
 		err = r.ParseMultipartForm(4096)
 		if err != nil {
 			s.l.Error("Error parsing movie form: %v", err)
@@ -335,62 +581,19 @@ func (s *Server) handlerAddMovie(w http.ResponseWriter, r *http.Request) {
 			results, links := s.handleAutofill(&data, w, r)
 
 			if results == nil || links == nil {
-				data.ErrorMessage = append(data.ErrorMessage, "Could not autofill all fields")
+				data.ErrorMessage = append(data.ErrorMessage, data.T("Could not autofill all fields"))
 				data.ErrAutofill = true
 			} else {
-				// Fill all the fields in the movie struct
-				movie.Name = results[0]
-				movie.Description = results[1]
-				movie.Poster = filepath.Base(results[2])
-				movie.Duration = results[3]
-
-				rating, err := strconv.ParseFloat(results[4], 32)
-				if err != nil {
-					s.l.Error("Error converting string to float for adding a movie")
-					movie.Rating = 0.0
-				} else {
-					movie.Rating = float32(rating)
-				}
-
-				movie.Remarks = results[6]
-
-				for _, link := range links {
-					id, err := s.data.AddLink(link)
-					if err != nil {
-						s.l.Debug("link error: %v", err)
-					}
-					link.Id = id
-				}
-
-				movie.Links = links
-				movie.AddedBy = user
-
-				tags := []*common.Tag{}
-				for _, tagStr := range strings.Split(results[5], ",") {
-					tag := &common.Tag{
-						Name: tagStr,
-					}
-
-					id, err := s.data.AddTag(tag)
-					if err != nil {
-						s.l.Debug("duplicate tag: %v", tagStr)
-					}
-					tag.Id = id
-
-					tags = append(tags, tag)
-				}
-
-				movie.Tags = tags
-				// Prepare a int for the id
-				var movieId int
-
-				movieId, err = s.data.AddMovie(movie)
+				movieId, err := s.addAutofilledMovie(user, results, links)
 				if err != nil {
 					data.ErrTitle = true // For now we enable the title flag
-					data.ErrorMessage = append(data.ErrorMessage, "Could not add movie, contact your server administrator")
+					data.ErrorMessage = append(data.ErrorMessage, data.T("Could not add movie, contact your server administrator"))
 					s.l.Error("Movie could not be added. Error: %v", err)
 				} else {
+					s.movieAdded(user)
+					s.publishMovie(movieId)
 					http.Redirect(w, r, fmt.Sprintf("/movie/%d", movieId), http.StatusFound)
+					return
 				}
 
 			}
@@ -400,7 +603,7 @@ func (s *Server) handlerAddMovie(w http.ResponseWriter, r *http.Request) {
 			results, links := s.handleFormfill(&data, w, r)
 
 			if results == nil || links == nil {
-				data.ErrorMessage = append(data.ErrorMessage, "One or more fields reported an error.")
+				data.ErrorMessage = append(data.ErrorMessage, data.T("One or more fields reported an error."))
 			} else {
 				// Fill all the fields in the movie struct
 				movie.Name = results[0]
@@ -410,6 +613,11 @@ func (s *Server) handlerAddMovie(w http.ResponseWriter, r *http.Request) {
 				movie.Links = links
 				movie.AddedBy = user
 
+				movie.Tags, err = s.addTags(strings.Split(results[4], ","))
+				if err != nil {
+					s.l.Error("Unable to add tags: %v", err)
+				}
+
 				// Prepare a int for the id
 				var movieId int
 
@@ -424,10 +632,13 @@ func (s *Server) handlerAddMovie(w http.ResponseWriter, r *http.Request) {
 				movieId, err = s.data.AddMovie(movie)
 				if err != nil {
 					data.ErrTitle = true // For now we enable the title flag
-					data.ErrorMessage = append(data.ErrorMessage, "Could not add movie, contact your server administrator")
+					data.ErrorMessage = append(data.ErrorMessage, data.T("Could not add movie, contact your server administrator"))
 					s.l.Error("Movie could not be added. Error: %v", err)
 				} else {
+					s.movieAdded(user)
+					s.publishMovie(movieId)
 					http.Redirect(w, r, fmt.Sprintf("/movie/%d", movieId), http.StatusFound)
+					return
 				}
 			}
 		}
@@ -437,22 +648,83 @@ func (s *Server) handlerAddMovie(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkMovieAddLimit returns a message explaining why the user cannot add a
+// movie right now, or an empty string if they can.  Mods, admins, and users
+// with RateLimitOverride set are never limited.
+func (s *Server) checkMovieAddLimit(user *common.User, cycle *common.Cycle, locale *i18n.Locale) (string, error) {
+	if user.RateLimitOverride || user.Privilege >= common.PRIV_MOD {
+		return "", nil
+	}
+
+	maxMovies, err := s.data.GetCfgInt(ConfigMaxUserMoviesPerCycle, DefaultMaxUserMoviesPerCycle)
+	if err != nil {
+		return "", fmt.Errorf("Unable to get %q: %v", ConfigMaxUserMoviesPerCycle, err)
+	}
+
+	if maxMovies > 0 {
+		added, err := s.data.GetUserMovies(user.Id)
+		if err != nil {
+			return "", fmt.Errorf("Unable to get movies added by user %d: %v", user.Id, err)
+		}
+
+		count := 0
+		for _, movie := range added {
+			if movie.CycleAdded != nil && movie.CycleAdded.Id == cycle.Id {
+				count++
+			}
+		}
+
+		// Withdrawn movies are gone, but don't free up a slot
+		for _, id := range user.WithdrawnIn {
+			if id == cycle.Id {
+				count++
+			}
+		}
+
+		if count >= maxMovies {
+			return locale.T("You have already added %d movies this cycle.  You can add another movie once the next cycle starts.", count), nil
+		}
+	}
+
+	cooldown, err := s.data.GetCfgInt(ConfigMovieAddCooldown, DefaultMovieAddCooldown)
+	if err != nil {
+		return "", fmt.Errorf("Unable to get %q: %v", ConfigMovieAddCooldown, err)
+	}
+
+	if cooldown > 0 && !user.LastMovieAdd.IsZero() {
+		wait := time.Until(user.LastMovieAdd.Add(time.Duration(cooldown) * time.Minute))
+		if wait > 0 {
+			return locale.T("You can add another movie in %s.", formatWait(locale, wait)), nil
+		}
+	}
+
+	return "", nil
+}
+
+// movieAdded records the time of a user's last added movie for the cooldown
+// between additions.
+func (s *Server) movieAdded(user *common.User) {
+	user.LastMovieAdd = time.Now()
+	if err := s.data.UpdateUser(user); err != nil {
+		s.l.Error("Unable to update LastMovieAdd for user %d: %v", user.Id, err)
+	}
+}
+
 func (s *Server) doError(code int, message string, w http.ResponseWriter, r *http.Request) {
 	s.l.Debug("%d for %q", code, r.URL.Path)
+	base := s.newPageBase("Error", w, r)
 	dataErr := dataError{
-		dataPageBase: s.newPageBase("Error", w, r),
-		Message:      message,
+		dataPageBase: base,
+		Message:      base.T(message),
 		Code:         code,
 	}
 
-	w.WriteHeader(http.StatusNotFound)
+	w.WriteHeader(code)
 	if err := s.executeTemplate(w, "error", dataErr); err != nil {
 		s.l.Error("Error rendering template: %v", err)
 	}
 }
 
-var re_tagSearch = `t:"([a-zA-Z ]+)"`
-
 func (s *Server) handlerRoot(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
 		s.doError(http.StatusNotFound, fmt.Sprintf("%q not found", r.URL.Path), w, r)
@@ -468,6 +740,8 @@ func (s *Server) handlerRoot(w http.ResponseWriter, r *http.Request) {
 		AvailableVotes int
 		LastCycle      *common.Cycle
 		Cycle          *common.Cycle
+		Search         string
+		Showings       []showingView
 	}{
 		dataPageBase: s.newPageBase("Current Cycle", w, r),
 	}
@@ -477,29 +751,20 @@ func (s *Server) handlerRoot(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			s.l.Error(err.Error())
 		}
-		searchVal := r.FormValue("search")
-
-		// finding tags
-		re := regexp.MustCompile(re_tagSearch)
-		tags := re.FindAllString(searchVal, -1)
-
-		// clean up the tags from the "tagsyntax"
-		tagsToFind := []string{}
-		for _, tag := range tags {
-			tagsToFind = append(tagsToFind, tag[3:len(tag)-1])
-		}
-
-		searchVal = re.ReplaceAllString(searchVal, "")
-		searchVal = strings.Trim(searchVal, " ")
-
-		// we first seach for matching titles (ignoring the tags for now)
-		movieList, err = s.data.SearchMovieTitles(searchVal)
-
-		// NOW we filter the already found movies by the tags provided
-		movieList, err = common.FilterMoviesByTags(movieList, tagsToFind)
+		data.Search = strings.TrimSpace(r.FormValue("search"))
+	}
 
+	if data.Search != "" {
+		// Search results are already ranked
+		var err error
+		movieList, err = s.searchMovies(data.Search)
 		if err != nil {
-			s.l.Error(err.Error())
+			s.l.Error("Unable to search movies: %v", err)
+			s.doError(
+				http.StatusInternalServerError,
+				"Something went wrong :C",
+				w, r)
+			return
 		}
 	} else {
 		var err error = nil
@@ -512,6 +777,7 @@ func (s *Server) handlerRoot(w http.ResponseWriter, r *http.Request) {
 				w, r)
 			return
 		}
+		movieList = common.SortMoviesByVotes(movieList)
 	}
 
 	if data.User != nil {
@@ -541,7 +807,7 @@ func (s *Server) handlerRoot(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	data.Movies = common.SortMoviesByVotes(movieList)
+	data.Movies = movieList
 	data.VotingEnabled, _ = s.data.GetCfgBool("VotingEnabled", DefaultVotingEnabled)
 
 	cycles, err := s.data.GetPastCycles(0, 1)
@@ -554,6 +820,11 @@ func (s *Server) handlerRoot(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	data.Showings, err = s.upcomingShowings(data.LastCycle)
+	if err != nil {
+		s.l.Error("Error getting upcoming showings: %v", err)
+	}
+
 	cycle, err := s.data.GetCurrentCycle()
 	if err != nil {
 		s.l.Error("Error getting Current Cycle: %v", err)
@@ -598,16 +869,90 @@ func (s *Server) handlerMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch command {
+	case "edit":
+		s.handlerMovieEdit(movie, w, r)
+		return
+	case "withdraw":
+		s.handlerMovieWithdraw(movie, w, r)
+		return
+	case "review":
+		s.handlerMovieReview(movie, w, r)
+		return
+	}
+
 	data := struct {
 		dataPageBase
 		Movie          *common.Movie
 		VotingEnabled  bool
 		AvailableVotes int
+		CanEdit        bool
+		CanWithdraw    bool
+		Watched        *time.Time
+		Showings       []showingView
+
+		// Community ratings, only for watched movies
+		Reviews         []reviewView
+		Rating          common.RatingSummary
+		Ratings         []int
+		MyRating        int
+		MyReview        string
+		MaxReviewLength int
 	}{
 		dataPageBase: s.newPageBase(movie.Name, w, r),
 		Movie:        movie,
 	}
 
+	if movie.CycleWatched != nil {
+		data.Watched = movie.CycleWatched.Ended
+	}
+
+	// The first showing is when the movie was watched, the cycle may have
+	// ended with an earlier showing of another movie.
+	showings, err := s.data.GetMovieShowings(movie.Id)
+	if err != nil {
+		s.l.Error("Unable to get showings of movie %d: %v", movie.Id, err)
+	} else {
+		data.Showings = s.showingViews(showings)
+		if len(data.Showings) > 0 {
+			data.Watched = data.Showings[0].StartTime()
+		}
+	}
+
+	if movie.CycleWatched != nil {
+		data.Reviews, data.Rating, err = s.movieReviews(movie)
+		if err != nil {
+			s.l.Error(err.Error())
+		}
+
+		data.Ratings = reviewRatings()
+		data.MaxReviewLength, err = s.data.GetCfgInt(ConfigMaxReviewLength, DefaultMaxReviewLength)
+		if err != nil {
+			s.l.Error("Unable to get %q: %v", ConfigMaxReviewLength, err)
+			data.MaxReviewLength = DefaultMaxReviewLength
+		}
+
+		if data.User != nil {
+			review, err := s.data.GetUserReview(data.User.Id, movie.Id)
+			if err != nil {
+				s.l.Error("Unable to get review of movie %d by user %d: %v", movie.Id, data.User.Id, err)
+			} else if review != nil {
+				data.MyRating = review.Rating
+				data.MyReview = review.Text
+			}
+		}
+	}
+
+	data.CanEdit, err = s.canEditMovie(data.User, movie)
+	if err != nil {
+		s.l.Error(err.Error())
+	}
+
+	data.CanWithdraw, err = s.canWithdrawMovie(data.User, movie)
+	if err != nil {
+		s.l.Error(err.Error())
+	}
+
 	data.VotingEnabled, _ = s.data.GetCfgBool("VotingEnabled", DefaultVotingEnabled)
 	// FIXME: This is copied from handleCycle.  Put this in a business layer instead.
 	if data.User != nil {
@@ -647,20 +992,65 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 	remarkstext := strings.ReplaceAll(r.FormValue("Remarks"), "\r", "")
 	data.ValRemarks = remarkstext
 
+	return s.autofill(data, linktext, remarkstext)
+}
+
+// addAutofilledMovie adds a movie using the results of autofill.
+func (s *Server) addAutofilledMovie(user *common.User, results []string, links []*common.Link) (int, error) {
+	movie := &common.Movie{}
+
+	// Fill all the fields in the movie struct
+	movie.Name = results[0]
+	movie.Description = results[1]
+	movie.Poster = filepath.Base(results[2])
+	movie.Duration = results[3]
+
+	rating, err := strconv.ParseFloat(results[4], 32)
+	if err != nil {
+		s.l.Error("Error converting string to float for adding a movie")
+		movie.Rating = 0.0
+	} else {
+		movie.Rating = float32(rating)
+	}
+
+	movie.Remarks = results[6]
+
+	for _, link := range links {
+		id, err := s.data.AddLink(link)
+		if err != nil {
+			s.l.Debug("link error: %v", err)
+		}
+		link.Id = id
+	}
+
+	movie.Links = links
+	movie.AddedBy = user
+
+	// Autofilled names matching an alias end up on the aliased tag.
+	tags, err := s.addTags(strings.Split(results[5], ","))
+	if err != nil {
+		return 0, err
+	}
+
+	movie.Tags = tags
+	return s.data.AddMovie(movie)
+}
+
+// autofill looks up the movie behind the first source link (IMDb or
+// MyAnimeList).  Problems are reported in data.ErrorMessage and nil is
+// returned.  Used by the add movie page and the chat bot.
+func (s *Server) autofill(data *dataAddMovie, linktext, remarkstext string) (results []string, links []*common.Link) {
 	// Check link maxlength
 	maxLinkLength, err := s.data.GetCfgInt(ConfigMaxLinkLength, DefaultMaxLinkLength)
 	if err != nil {
 		s.l.Error("Unable to get %q: %v", ConfigMaxLinkLength, err)
-		s.doError(
-			http.StatusInternalServerError,
-			"something went wrong :C",
-			w, r)
-		return
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Something went wrong :C"))
+		return nil, nil
 	}
 
 	if common.GetStringLength(linktext) > maxLinkLength {
 		s.l.Debug("Links too long: %d", common.GetStringLength(linktext))
-		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Links too long! Max Length: %d characters", maxLinkLength))
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Links too long! Max Length: %d characters", maxLinkLength))
 		data.ErrLinks = true
 	}
 
@@ -668,7 +1058,7 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 	linkstrings := strings.Split(linktext, "\n")
 	if len(linkstrings) == 0 {
 		s.l.Error("no links given")
-		data.ErrorMessage = append(data.ErrorMessage, "No link found.")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("No link found."))
 		data.ErrLinks = true
 	}
 
@@ -681,11 +1071,12 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 
 		if err != nil {
 			s.l.Error("Cannot add link")
-			data.ErrorMessage = append(data.ErrorMessage, "Could not add link: %v", err.Error())
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Could not add link: %v", err))
 			data.ErrLinks = true
+			continue
 		}
 
-		if ls.IsSource {
+		if ls.IsSource && sourcelink == nil {
 			sourcelink = ls
 		}
 
@@ -696,16 +1087,13 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 	maxRemarksLength, err := s.data.GetCfgInt(ConfigMaxRemarksLength, DefaultMaxRemarksLength)
 	if err != nil {
 		s.l.Error("Unable to get %q: %v", ConfigMaxRemarksLength, err)
-		s.doError(
-			http.StatusInternalServerError,
-			"something went wrong :C",
-			w, r)
-		return
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Something went wrong :C"))
+		return nil, nil
 	}
 
 	if common.GetStringLength(remarkstext) > maxRemarksLength {
 		s.l.Debug("Remarks too long: %d", common.GetStringLength(remarkstext))
-		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Remarks too long! Max Length: %d characters", maxRemarksLength))
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Remarks too long! Max Length: %d characters", maxRemarksLength))
 		data.ErrRemarks = true
 	}
 
@@ -714,10 +1102,17 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 		return nil, nil
 	}
 
+	if sourcelink == nil {
+		s.l.Debug("no source link")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("To use autofill an imdb or myanimelist link as first link is required"))
+		data.ErrLinks = true
+		return nil, nil
+	}
+
 	if sourcelink.Type == "MyAnimeList" {
 		s.l.Debug("MAL link")
 
-		results, err = s.handleJikan(data, w, r, sourcelink.Url)
+		results, err = s.handleJikan(data, sourcelink.Url)
 
 		if err != nil {
 			s.l.Error(err.Error())
@@ -728,7 +1123,7 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 
 		if len(results) != 6 {
 			s.l.Error("Jikan API results have an unexpected length, expected 6 got %v", len(results))
-			data.ErrorMessage = append(data.ErrorMessage, "API autofill did not return enough data, contact the server administrator")
+			data.ErrorMessage = append(data.ErrorMessage, data.T("API autofill did not return enough data, contact the server administrator"))
 			return nil, nil
 		} else {
 			title = results[0]
@@ -737,16 +1132,13 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 		exists, err := s.data.CheckMovieExists(title)
 		if err != nil {
 			s.l.Error(err.Error())
-			s.doError(
-				http.StatusInternalServerError,
-				"something went wrong :C",
-				w, r)
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Something went wrong :C"))
 			return nil, nil
 		}
 
 		if exists {
 			s.l.Debug("Movie already exists")
-			data.ErrorMessage = append(data.ErrorMessage, "Movie already exists in database")
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Movie already exists in database"))
 			data.ErrAutofill = true
 			return nil, nil
 		}
@@ -758,7 +1150,7 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 	if sourcelink.Type == "IMDb" {
 		s.l.Debug("IMDB link")
 
-		results, err = s.handleTmdb(data, w, r, sourcelink.Url)
+		results, err = s.handleTmdb(data, sourcelink.Url)
 
 		if err != nil {
 			s.l.Error(err.Error())
@@ -769,7 +1161,7 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 
 		if len(results) != 6 {
 			s.l.Error("Tmdb API results have an unexpected length, expected 6 got %v", len(results))
-			data.ErrorMessage = append(data.ErrorMessage, "API autofill did not return enough data, did you input a link to a series?")
+			data.ErrorMessage = append(data.ErrorMessage, data.T("API autofill did not return enough data, did you input a link to a series?"))
 			return nil, nil
 		} else {
 			title = results[0]
@@ -778,17 +1170,13 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 		exists, err := s.data.CheckMovieExists(title)
 		if err != nil {
 			s.l.Error(err.Error())
-			s.doError(
-				http.StatusInternalServerError,
-				"something went wrong :C",
-				w, r)
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Something went wrong :C"))
 			return nil, nil
-
 		}
 
 		if exists {
 			s.l.Debug("Movie already exists")
-			data.ErrorMessage = append(data.ErrorMessage, "Movie already exists in database")
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Movie already exists in database"))
 			data.ErrAutofill = true
 			return nil, nil
 		}
@@ -799,49 +1187,12 @@ func (s *Server) handleAutofill(data *dataAddMovie, w http.ResponseWriter, r *ht
 	}
 
 	s.l.Debug("no link")
-	data.ErrorMessage = append(data.ErrorMessage, "To use autofill an imdb or myanimelist link as first link is required")
+	data.ErrorMessage = append(data.ErrorMessage, data.T("To use autofill an imdb or myanimelist link as first link is required"))
 	data.ErrLinks = true
 	return nil, nil
 
 }
 
-func (s *Server) uploadFile(r *http.Request, name string) (string, error) {
-	s.l.Debug("[uploadFile] Start")
-	var err error
-	// 10 MB upload limit
-	r.ParseMultipartForm(10 << 20)
-
-	file, handler, err := r.FormFile("PosterFile")
-
-	if err != nil {
-		s.l.Error(err.Error())
-		return "", fmt.Errorf("Unable to retrive the file")
-	}
-
-	defer file.Close()
-
-	s.l.Info("Uploaded File: %v - Size %v", handler.Filename, handler.Size)
-
-	tempFile, err := ioutil.TempFile("posters", name+"-*.png")
-
-	if err != nil {
-		return "", fmt.Errorf("Error while saving file to disk: %v", err)
-	}
-	defer tempFile.Close()
-
-	fileBytes, err := ioutil.ReadAll(file)
-
-	if err != nil {
-		return "", err
-	}
-
-	tempFile.Write(fileBytes)
-
-	s.l.Debug("[uploadFile] Filename: %v", tempFile.Name())
-
-	return tempFile.Name(), nil
-}
-
 // List of past cycles
 func (s *Server) handlerHistory(w http.ResponseWriter, r *http.Request) {
 	past, err := s.data.GetPastCycles(0, 100)
@@ -854,12 +1205,19 @@ func (s *Server) handlerHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ratings, err := s.cycleRatings(past)
+	if err != nil {
+		s.l.Error(err.Error())
+	}
+
 	data := struct {
 		dataPageBase
-		Cycles []*common.Cycle
+		Cycles  []*common.Cycle
+		Ratings map[int]*common.RatingSummary
 	}{
 		dataPageBase: s.newPageBase("Cycle History", w, r),
 		Cycles:       past,
+		Ratings:      ratings,
 	}
 
 	if err := s.executeTemplate(w, "history", data); err != nil {
@@ -869,21 +1227,18 @@ func (s *Server) handlerHistory(w http.ResponseWriter, r *http.Request) {
 
 var re_jikanToken = regexp.MustCompile(`[^\/]*\/anime\/([0-9]+)`)
 
-func (s *Server) handleJikan(data *dataAddMovie, w http.ResponseWriter, r *http.Request, sourcelink string) ([]string, error) {
+func (s *Server) handleJikan(data *dataAddMovie, sourcelink string) ([]string, error) {
 
 	jikanEnabled, err := s.data.GetCfgBool("JikanEnabled", DefaultJikanEnabled)
 	if err != nil {
-		s.doError(
-			http.StatusInternalServerError,
-			"something went wrong :C",
-			w, r)
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Something went wrong :C"))
 		return nil, fmt.Errorf("Error while retriving config value 'JikanEnabled':\n %v", err)
 	}
 
 	s.l.Debug("jikanEnabled: %v", jikanEnabled)
 
 	if !jikanEnabled {
-		data.ErrorMessage = append(data.ErrorMessage, "Jikan API usage was not enabled by the site administrator")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Jikan API usage was not enabled by the site administrator"))
 		return nil, fmt.Errorf("Jikan not enabled")
 	}
 
@@ -892,7 +1247,7 @@ func (s *Server) handleJikan(data *dataAddMovie, w http.ResponseWriter, r *http.
 	var id string
 	if len(match) < 2 {
 		s.l.Debug("Regex match didn't find the anime id in %v", sourcelink)
-		data.ErrorMessage = append(data.ErrorMessage, "Could not retrive anime id from provided link, did you input a manga link?")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Could not retrive anime id from provided link, did you input a manga link?"))
 		data.ErrLinks = true
 		return nil, fmt.Errorf("Could not retrive anime id from link")
 	}
@@ -901,10 +1256,7 @@ func (s *Server) handleJikan(data *dataAddMovie, w http.ResponseWriter, r *http.
 	bannedTypesString, err := s.data.GetCfgString(ConfigJikanBannedTypes, DefaultJikanBannedTypes)
 
 	if err != nil {
-		s.doError(
-			http.StatusInternalServerError,
-			"something went wrong :C",
-			w, r)
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Something went wrong :C"))
 		return nil, fmt.Errorf("Error while retriving config value 'JikanBannedTypes':\n %v", err)
 	}
 
@@ -913,14 +1265,11 @@ func (s *Server) handleJikan(data *dataAddMovie, w http.ResponseWriter, r *http.
 	maxEpisodes, err := s.data.GetCfgInt(ConfigJikanMaxEpisodes, DefaultJikanMaxEpisodes)
 
 	if err != nil {
-		s.doError(
-			http.StatusInternalServerError,
-			"something went wrong :C",
-			w, r)
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Something went wrong :C"))
 		return nil, fmt.Errorf("Error while retriving config value 'JikanMaxEpisodes':\n %v", err)
 	}
 
-	sourceAPI := jikan{id: id, l: s.l, excludedTypes: bannedTypes, maxEpisodes: maxEpisodes}
+	sourceAPI := jikan{id: id, l: s.l, posters: s.posters, excludedTypes: bannedTypes, maxEpisodes: maxEpisodes}
 
 	// Request data from API
 	results, err := getMovieData(&sourceAPI)
@@ -935,17 +1284,17 @@ func (s *Server) handleJikan(data *dataAddMovie, w http.ResponseWriter, r *http.
 
 var re_tmdbToken = regexp.MustCompile(`[^\/]*\/title\/(tt[0-9]*)`)
 
-func (s *Server) handleTmdb(data *dataAddMovie, w http.ResponseWriter, r *http.Request, sourcelink string) ([]string, error) {
+func (s *Server) handleTmdb(data *dataAddMovie, sourcelink string) ([]string, error) {
 
 	tmdbEnabled, err := s.data.GetCfgBool("TmdbEnabled", DefaultTmdbEnabled)
 	if err != nil {
-		data.ErrorMessage = append(data.ErrorMessage, "Something went wrong :C")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Something went wrong :C"))
 		return nil, fmt.Errorf("Error while retriving config value 'TmdbEnabled':\n %v", err)
 	}
 
 	if !tmdbEnabled {
 		s.l.Debug("Aborting Tmdb autofill since it is not enabled")
-		data.ErrorMessage = append(data.ErrorMessage, "Tmdb API usage was not enabled by the site administrator")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Tmdb API usage was not enabled by the site administrator"))
 		return nil, fmt.Errorf("Tmdb not enabled")
 	}
 
@@ -953,7 +1302,7 @@ func (s *Server) handleTmdb(data *dataAddMovie, w http.ResponseWriter, r *http.R
 	token, err := s.data.GetCfgString("TmdbToken", "")
 	if err != nil || token == "" {
 		s.l.Debug("Aborting Tmdb autofill since no token was found")
-		data.ErrorMessage = append(data.ErrorMessage, "TmdbToken is either empty or not set in the admin config")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("TmdbToken is either empty or not set in the admin config"))
 		return nil, fmt.Errorf("TmdbToken is either empty or not set in the admin config")
 	}
 	// get the movie id
@@ -961,13 +1310,13 @@ func (s *Server) handleTmdb(data *dataAddMovie, w http.ResponseWriter, r *http.R
 	var id string
 	if len(match) < 2 {
 		s.l.Debug("Regex match didn't find the movie id in %v", sourcelink)
-		data.ErrorMessage = append(data.ErrorMessage, "Could not retrive movie id from provided link")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Could not retrive movie id from provided link"))
 		data.ErrLinks = true
 		return nil, fmt.Errorf("Could not retrive movie id from link")
 	}
 	id = match[1]
 
-	sourceAPI := tmdb{id: id, token: token, l: s.l}
+	sourceAPI := tmdb{id: id, token: token, l: s.l, posters: s.posters}
 
 	// Request data from API
 	results, err := getMovieData(&sourceAPI)
@@ -1003,7 +1352,7 @@ func (s *Server) handleFormfill(data *dataAddMovie, w http.ResponseWriter, r *ht
 
 	if common.GetStringLength(linktext) > maxLinkLength {
 		s.l.Debug("Links too long: %d", common.GetStringLength(linktext))
-		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Links too long! Max Length: %d characters", maxLinkLength))
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Links too long! Max Length: %d characters", maxLinkLength))
 		data.ErrLinks = true
 	}
 
@@ -1011,7 +1360,7 @@ func (s *Server) handleFormfill(data *dataAddMovie, w http.ResponseWriter, r *ht
 	linkstrings := strings.Split(linktext, "\n")
 	if len(linkstrings) == 0 {
 		s.l.Error("no links given")
-		data.ErrorMessage = append(data.ErrorMessage, "No link found.")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("No link found."))
 		data.ErrLinks = true
 	}
 
@@ -1022,7 +1371,7 @@ func (s *Server) handleFormfill(data *dataAddMovie, w http.ResponseWriter, r *ht
 
 		if err != nil {
 			s.l.Error("Cannot add link")
-			data.ErrorMessage = append(data.ErrorMessage, "Could not add link: %v", err.Error())
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Could not add link: %v", err))
 			data.ErrLinks = true
 		}
 
@@ -1042,7 +1391,7 @@ func (s *Server) handleFormfill(data *dataAddMovie, w http.ResponseWriter, r *ht
 
 	if common.GetStringLength(remarkstext) > maxRemarksLength {
 		s.l.Debug("Remarks too long: %d", common.GetStringLength(remarkstext))
-		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Remarks too long! Max Length: %d characters", maxRemarksLength))
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Remarks too long! Max Length: %d characters", maxRemarksLength))
 		data.ErrRemarks = true
 	}
 
@@ -1061,18 +1410,18 @@ func (s *Server) handleFormfill(data *dataAddMovie, w http.ResponseWriter, r *ht
 	data.ValTitle = title
 
 	if data.ValTitle == "" {
-		data.ErrorMessage = append(data.ErrorMessage, "Missing movie title")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Missing movie title"))
 		data.ErrTitle = true
 	}
 
 	if common.GetStringLength(data.ValTitle) > maxTitleLength {
 		s.l.Debug("Title too long: %d", common.GetStringLength(data.ValTitle))
 		data.ErrTitle = true
-		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Title too long! Max Length: %d characters", maxTitleLength))
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Title too long! Max Length: %d characters", maxTitleLength))
 	} else if common.GetStringLength(common.CleanMovieName(data.ValTitle)) == 0 {
 		s.l.Debug("Title too short: %d", common.GetStringLength(common.CleanMovieName(data.ValTitle)))
 		data.ErrTitle = true
-		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Title too short! Min Length: %d characters", 1))
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Title too short! Min Length: %d characters", 1))
 	}
 
 	movieExists, err := s.data.CheckMovieExists(title)
@@ -1087,7 +1436,7 @@ func (s *Server) handleFormfill(data *dataAddMovie, w http.ResponseWriter, r *ht
 	if movieExists {
 		data.ErrTitle = true
 		s.l.Debug("Movie exists")
-		data.ErrorMessage = append(data.ErrorMessage, "Movie already exists")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Movie already exists"))
 	}
 
 	descr := strings.TrimSpace(r.FormValue("Description"))
@@ -1106,17 +1455,31 @@ func (s *Server) handleFormfill(data *dataAddMovie, w http.ResponseWriter, r *ht
 	if common.GetStringLength(data.ValDescription) > maxDescriptionLength {
 		s.l.Debug("Description too long: %d", common.GetStringLength(data.ValDescription))
 		data.ErrDescription = true
-		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Description too long! Max Length: %d characters", maxDescriptionLength))
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Description too long! Max Length: %d characters", maxDescriptionLength))
 	}
 
 	if common.GetStringLength(descr) == 0 {
 		data.ErrDescription = true
-		data.ErrorMessage = append(data.ErrorMessage, "Missing description")
+		data.ErrorMessage = append(data.ErrorMessage, data.T("Missing description"))
 	}
 
-	var posterpath string
+	data.ValTags = strings.TrimSpace(r.FormValue("Tags"))
+	tagNames, problems, err := s.parseTagInput(data.ValTags, data.Locale)
+	if err != nil {
+		s.l.Error("Unable to check tags: %v", err)
+		s.doError(
+			http.StatusInternalServerError,
+			"something went wrong :C",
+			w, r)
+		return
+	}
+
+	if len(problems) > 0 {
+		data.ErrTags = true
+		data.ErrorMessage = append(data.ErrorMessage, problems...)
+	}
 
-	posterFileName := strings.TrimSpace(r.FormValue("MovieName"))
+	var posterpath string
 
 	posterFile, _, err := r.FormFile("PosterFile")
 
@@ -1127,7 +1490,7 @@ func (s *Server) handleFormfill(data *dataAddMovie, w http.ResponseWriter, r *ht
 			data.ErrorMessage = append(data.ErrorMessage, err.Error())
 		}
 
-		file, err := s.uploadFile(r, posterFileName)
+		file, err := s.uploadPoster(r)
 
 		if err != nil {
 			s.l.Error("Upload of the file was not possible: %v", err.Error())
@@ -1144,7 +1507,7 @@ func (s *Server) handleFormfill(data *dataAddMovie, w http.ResponseWriter, r *ht
 		return nil, nil
 	}
 
-	results = append(results, title, descr, posterpath, remarkstext)
+	results = append(results, title, descr, posterpath, remarkstext, strings.Join(tagNames, ","))
 
 	return results, links
 }
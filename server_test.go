@@ -0,0 +1,68 @@
+package moviepoll
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/i18n"
+)
+
+func Test_MovieAddLimit(t *testing.T) {
+	s := newTestServer(t)
+
+	if _, err := s.data.AddCycle(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cycle, err := s.data.GetCurrentCycle()
+	if err != nil || cycle == nil {
+		t.Fatalf("Unable to get current cycle: %v", err)
+	}
+
+	user := addTestUser(t, s, "submitter", common.PRIV_USER)
+	mod := addTestUser(t, s, "moderator", common.PRIV_MOD)
+
+	s.data.SetCfgInt(ConfigMaxUserMoviesPerCycle, 1)
+	s.data.SetCfgInt(ConfigMovieAddCooldown, 60)
+
+	if msg, err := s.checkMovieAddLimit(user, cycle, i18n.Default); err != nil || msg != "" {
+		t.Fatalf("New user should be able to add a movie: %q %v", msg, err)
+	}
+
+	// Cooldown
+	s.movieAdded(user)
+	msg, err := s.checkMovieAddLimit(user, cycle, i18n.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg, "You can add another movie in") {
+		t.Errorf("Expected cooldown message, got %q", msg)
+	}
+
+	// Per cycle limit
+	user.LastMovieAdd = time.Now().Add(-2 * time.Hour)
+	if _, err = s.data.AddMovie(&common.Movie{Name: "Limit Test", AddedBy: user}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err = s.checkMovieAddLimit(user, cycle, i18n.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(msg, "You have already added 1 movies this cycle") {
+		t.Errorf("Expected cycle limit message, got %q", msg)
+	}
+
+	// Bypass
+	s.movieAdded(mod)
+	if msg, err = s.checkMovieAddLimit(mod, cycle, i18n.Default); err != nil || msg != "" {
+		t.Errorf("Mods should bypass limits: %q %v", msg, err)
+	}
+
+	user.RateLimitOverride = true
+	if msg, err = s.checkMovieAddLimit(user, cycle, i18n.Default); err != nil || msg != "" {
+		t.Errorf("RateLimitOverride should bypass limits: %q %v", msg, err)
+	}
+}
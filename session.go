@@ -4,17 +4,36 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/zorchenhimer/MoviePolls/common"
 )
 
+// Sessions that haven't been used for this long are expired.
+const sessionMaxAge time.Duration = 30 * 24 * time.Hour
+
+// LastSeen is only updated this often so not every request writes to the
+// database.
+const sessionSeenInterval time.Duration = 5 * time.Minute
+
+// Longer user agents are truncated before being stored.
+const sessionMaxUserAgent int = 256
+
 func (s *Server) logout(w http.ResponseWriter, r *http.Request) error {
 	session, err := s.cookies.Get(r, SessionName)
 	if err != nil {
 		return fmt.Errorf("Unable to get session from store: %v", err)
 	}
 
+	if id, ok := session.Values["SessionId"].(string); ok && id != "" {
+		if err = s.data.DeleteSession(id); err != nil {
+			return fmt.Errorf("Unable to delete session: %v", err)
+		}
+	}
+
+	delete(session.Values, "PendingUserId")
+	delete(session.Values, "PendingTime")
 	return delSession(session, w, r)
 }
 
@@ -29,19 +48,122 @@ func (s *Server) login(user *common.User, w http.ResponseWriter, r *http.Request
 		return fmt.Errorf("Unable to gob PassDate")
 	}
 
+	// Replace the record of a previous login in this cookie
+	if id, ok := session.Values["SessionId"].(string); ok && id != "" {
+		if err = s.data.DeleteSession(id); err != nil {
+			return fmt.Errorf("Unable to delete old session: %v", err)
+		}
+	}
+
+	if err = s.pruneSessions(user.Id); err != nil {
+		s.l.Error("Unable to prune sessions for user %d: %v", user.Id, err)
+	}
+
+	record, err := common.NewSession(user.Id, s.clientAddr(r), truncateUserAgent(r.UserAgent()))
+	if err != nil {
+		return err
+	}
+
+	if err = s.data.AddSession(record); err != nil {
+		return fmt.Errorf("Unable to add session: %v", err)
+	}
+
+	session.Values["SessionId"] = record.Id
 	session.Values["UserId"] = user.Id
 	session.Values["PassDate"] = fmt.Sprintf("%X", sha256.Sum256([]byte(gobbed)))
 
+	// Don't carry a token from before the login over into the new session.
+	session.Values["CsrfToken"] = getCryptRandKey(32)
+
 	return session.Save(r, w)
 }
 
 func delSession(session *sessions.Session, w http.ResponseWriter, r *http.Request) error {
 	delete(session.Values, "UserId")
 	delete(session.Values, "PassDate")
+	delete(session.Values, "TwoFactor")
+	delete(session.Values, "SessionId")
 
 	return session.Save(r, w)
 }
 
+// currentSessionId returns the ID of the server side session for the request.
+func (s *Server) currentSessionId(r *http.Request) string {
+	session, err := s.cookies.Get(r, SessionName)
+	if err != nil {
+		return ""
+	}
+
+	id, _ := session.Values["SessionId"].(string)
+	return id
+}
+
+// revokeSessions logs out either a single session of the user, or all of
+// their sessions except the current one.
+func (s *Server) revokeSessions(user *common.User, others bool, id string, r *http.Request) error {
+	current := s.currentSessionId(r)
+
+	sessions, err := s.data.GetUserSessions(user.Id)
+	if err != nil {
+		return fmt.Errorf("Unable to get sessions: %v", err)
+	}
+
+	for _, session := range sessions {
+		if session.Id == current {
+			continue
+		}
+
+		if others || session.Id == id {
+			if err = s.data.DeleteSession(session.Id); err != nil {
+				return fmt.Errorf("Unable to delete session: %v", err)
+			}
+		}
+	}
+
+	s.l.Info("User %s revoked sessions", user.Name)
+	return nil
+}
+
+// getUserSessions returns the user's sessions that haven't expired yet.
+func (s *Server) getUserSessions(userId int) ([]*common.Session, error) {
+	all, err := s.data.GetUserSessions(userId)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get sessions for user %d: %v", userId, err)
+	}
+
+	active := []*common.Session{}
+	for _, session := range all {
+		if time.Since(session.LastSeen) <= sessionMaxAge {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+// pruneSessions removes expired sessions of a user.
+func (s *Server) pruneSessions(userId int) error {
+	all, err := s.data.GetUserSessions(userId)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range all {
+		if time.Since(session.LastSeen) > sessionMaxAge {
+			if err = s.data.DeleteSession(session.Id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func truncateUserAgent(ua string) string {
+	if len(ua) > sessionMaxUserAgent {
+		return ua[:sessionMaxUserAgent]
+	}
+	return ua
+}
+
 func (s *Server) getSessionUser(w http.ResponseWriter, r *http.Request) *common.User {
 	session, err := s.cookies.Get(r, SessionName)
 	if err != nil {
@@ -87,5 +209,31 @@ func (s *Server) getSessionUser(w http.ResponseWriter, r *http.Request) *common.
 		return nil
 	}
 
+	// The server side record is gone if the session was revoked.
+	sessionId, _ := session.Values["SessionId"].(string)
+	record, err := s.data.GetSession(sessionId)
+	if err != nil || record == nil || record.UserId != user.Id || time.Since(record.LastSeen) > sessionMaxAge {
+		if err != nil {
+			s.l.Error("Unable to get session: %v", err)
+		} else {
+			s.l.Debug("Session for user %s was revoked or has expired", user.Name)
+		}
+
+		err = delSession(session, w, r)
+		if err != nil {
+			s.l.Error("Unable to delete cookie: %v", err)
+		}
+		return nil
+	}
+
+	if time.Since(record.LastSeen) > sessionSeenInterval {
+		record.LastSeen = time.Now()
+		record.Address = s.clientAddr(r)
+		record.UserAgent = truncateUserAgent(r.UserAgent())
+		if err = s.data.UpdateSession(record); err != nil {
+			s.l.Error("Unable to update session: %v", err)
+		}
+	}
+
 	return user
 }
@@ -0,0 +1,118 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// sessionUser returns the logged in user for the given cookies.
+func sessionUser(s *Server, cookies []*http.Cookie) *common.User {
+	req := addCookies(httptest.NewRequest("GET", "/", nil), cookies)
+	return s.getSessionUser(httptest.NewRecorder(), req)
+}
+
+func Test_SessionRevoke(t *testing.T) {
+	s := newTestServer(t)
+	user := addTestUser(t, s, "devices", common.PRIV_USER)
+
+	laptop := loginCookies(t, s, user)
+	phone := loginCookies(t, s, user)
+
+	sessions, err := s.data.GetUserSessions(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected two sessions, got %d", len(sessions))
+	}
+
+	if sessionUser(s, laptop) == nil || sessionUser(s, phone) == nil {
+		t.Fatalf("Sessions are not logged in")
+	}
+
+	rec := postHandler(s.handlerUser, "/user", url.Values{"Form": {"RevokeOtherSessions"}}, laptop)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Sessions revoked") {
+		t.Fatalf("Unable to revoke sessions: %d", rec.Code)
+	}
+
+	if sessionUser(s, phone) != nil {
+		t.Errorf("Revoked session is still logged in")
+	}
+
+	if sessionUser(s, laptop) == nil {
+		t.Errorf("Current session was revoked")
+	}
+
+	// Logging out removes the record.
+	req := addCookies(httptest.NewRequest("POST", "/user/logout", nil), laptop)
+	if err = s.logout(httptest.NewRecorder(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	if sessions, _ = s.data.GetUserSessions(user.Id); len(sessions) != 0 {
+		t.Errorf("Expected no sessions after logout, got %d", len(sessions))
+	}
+}
+
+func Test_SessionRevokeOtherUser(t *testing.T) {
+	s := newTestServer(t)
+	attacker := addTestUser(t, s, "attacker", common.PRIV_USER)
+	victim := addTestUser(t, s, "victim", common.PRIV_USER)
+
+	attackerCookies := loginCookies(t, s, attacker)
+	victimCookies := loginCookies(t, s, victim)
+
+	sessions, err := s.data.GetUserSessions(victim.Id)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("Expected one session: %v", err)
+	}
+
+	postHandler(s.handlerUser, "/user", url.Values{"Form": {"RevokeSession"}, "SessionId": {sessions[0].Id}}, attackerCookies)
+	if sessionUser(s, victimCookies) == nil {
+		t.Errorf("A user was able to revoke another user's session")
+	}
+}
+
+func Test_SessionForceLogout(t *testing.T) {
+	s := newTestServer(t)
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+	user := addTestUser(t, s, "loggedin", common.PRIV_USER)
+
+	userCookies := loginCookies(t, s, user)
+	adminCookies := loginCookies(t, s, admin)
+
+	rec := postHandler(s.handlerAdminUserEdit, fmt.Sprintf("/admin/user/%d", user.Id), url.Values{"Form": {"ForceLogout"}}, adminCookies)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Force logout failed: %d", rec.Code)
+	}
+
+	if sessionUser(s, userCookies) != nil {
+		t.Errorf("User is still logged in after force logout")
+	}
+
+	if sessionUser(s, adminCookies) == nil {
+		t.Errorf("Admin was logged out")
+	}
+}
+
+func Test_SessionDeletedUser(t *testing.T) {
+	s := newTestServer(t)
+	user := addTestUser(t, s, "loggedin", common.PRIV_USER)
+	userCookies := loginCookies(t, s, user)
+
+	deleteTestUser(t, s, user)
+
+	if sessionUser(s, userCookies) != nil {
+		t.Errorf("Deleted user is still logged in")
+	}
+
+	if sessions, err := s.data.GetUserSessions(user.Id); err != nil || len(sessions) != 0 {
+		t.Errorf("Sessions of the deleted user were kept: %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// Watch nights of the movies selected at the end of a cycle.  Admins
+// schedule one or more showings of every selected movie and users RSVP to
+// them.  The earliest showing of a cycle becomes the cycle's end date, which
+// is the watched date on the history and movie pages.
+
+// Number of finished cycles on the admin page
+const showingAdminCycles = 5
+
+// showingView is a showing with its movie, for the templates.
+type showingView struct {
+	*common.Showing
+	Movie *common.Movie
+}
+
+// StartTime returns the start for the date helpers of the page.
+func (v showingView) StartTime() *time.Time {
+	return &v.Start
+}
+
+// Over returns true once the showing has ended.
+func (v showingView) Over() bool {
+	return time.Now().After(v.Start.Add(calendarWatchLength))
+}
+
+type dataAdminShowings struct {
+	dataPageBase
+
+	Cycles   []adminShowingCycle
+	TimeZone string
+	zone     *time.Location
+
+	ErrorMessage []string
+}
+
+type adminShowingCycle struct {
+	Cycle  *common.Cycle
+	Movies []adminShowingMovie
+}
+
+type adminShowingMovie struct {
+	Movie    *common.Movie
+	Showings []*common.Showing
+}
+
+// InputTime formats a start time for a datetime-local input in the server's
+// time zone.
+func (d dataAdminShowings) InputTime(t time.Time) string {
+	return t.In(d.zone).Format(inputDateTimeLayout)
+}
+
+func (s *Server) handlerAdminShowings(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminRights(w, r) {
+		return
+	}
+
+	zone := s.serverLocation()
+	data := dataAdminShowings{
+		TimeZone: zone.String(),
+		zone:     zone,
+	}
+
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			s.doError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Unable to parse form: %v", err),
+				w, r)
+			return
+		}
+
+		var err error
+		switch r.PostFormValue("Form") {
+		case "Add":
+			err = s.adminAddShowing(r, &data)
+		case "Update", "Delete":
+			err = s.adminEditShowing(r, &data)
+		}
+
+		if err != nil {
+			s.doError(http.StatusInternalServerError, err.Error(), w, r)
+			return
+		}
+	}
+
+	cycles, err := s.data.GetPastCycles(0, showingAdminCycles)
+	if err != nil {
+		s.doError(
+			http.StatusInternalServerError,
+			fmt.Sprintf("Unable to get past cycles: %v", err),
+			w, r)
+		return
+	}
+
+	for _, cycle := range cycles {
+		showings, err := s.data.GetCycleShowings(cycle.Id)
+		if err != nil {
+			s.doError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Unable to get showings of cycle %d: %v", cycle.Id, err),
+				w, r)
+			return
+		}
+
+		c := adminShowingCycle{Cycle: cycle}
+		for _, movie := range cycle.Watched {
+			m := adminShowingMovie{Movie: movie}
+			for _, showing := range showings {
+				if showing.MovieId == movie.Id {
+					m.Showings = append(m.Showings, showing)
+				}
+			}
+			c.Movies = append(c.Movies, m)
+		}
+		data.Cycles = append(data.Cycles, c)
+	}
+
+	data.dataPageBase = s.newPageBase("Admin - Showings", w, r)
+
+	if err := s.executeTemplate(w, "adminShowings", data); err != nil {
+		s.l.Error("Error rendering template: %v", err)
+	}
+}
+
+func (s *Server) adminAddShowing(r *http.Request, data *dataAdminShowings) error {
+	movieId, err := strconv.Atoi(r.PostFormValue("MovieId"))
+	if err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, "Invalid movie ID")
+		return nil
+	}
+
+	movie, err := s.data.GetMovie(movieId)
+	if err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, err.Error())
+		return nil
+	}
+
+	if movie.CycleWatched == nil {
+		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("%s wasn't selected in a cycle", movie.Name))
+		return nil
+	}
+
+	start, err := parseInputTime(r.PostFormValue("Start"), data.zone)
+	if err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, err.Error())
+		return nil
+	}
+
+	showing := &common.Showing{
+		MovieId:  movie.Id,
+		CycleId:  movie.CycleWatched.Id,
+		Start:    start,
+		Location: strings.TrimSpace(r.PostFormValue("Location")),
+	}
+
+	if _, err = s.data.AddShowing(showing); err != nil {
+		return fmt.Errorf("Unable to add showing: %v", err)
+	}
+
+	s.l.Info("Showing of %s scheduled for %s", movie.Name, start)
+	return s.updateCycleEnded(showing.CycleId)
+}
+
+func (s *Server) adminEditShowing(r *http.Request, data *dataAdminShowings) error {
+	id, err := strconv.Atoi(r.PostFormValue("Id"))
+	if err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, "Invalid showing ID")
+		return nil
+	}
+
+	showing, err := s.data.GetShowing(id)
+	if err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, err.Error())
+		return nil
+	}
+
+	if r.PostFormValue("Form") == "Delete" {
+		if err = s.data.DeleteShowing(id); err != nil {
+			return fmt.Errorf("Unable to delete showing: %v", err)
+		}
+
+		s.l.Info("Showing %d deleted", id)
+		return s.updateCycleEnded(showing.CycleId)
+	}
+
+	showing.Start, err = parseInputTime(r.PostFormValue("Start"), data.zone)
+	if err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, err.Error())
+		return nil
+	}
+	showing.Location = strings.TrimSpace(r.PostFormValue("Location"))
+
+	if err = s.data.UpdateShowing(showing); err != nil {
+		return fmt.Errorf("Unable to update showing: %v", err)
+	}
+	return s.updateCycleEnded(showing.CycleId)
+}
+
+// updateCycleEnded moves the end of a cycle to its earliest showing.  Cycles
+// without showings keep the date they were ended with.
+func (s *Server) updateCycleEnded(cycleId int) error {
+	showings, err := s.data.GetCycleShowings(cycleId)
+	if err != nil {
+		return fmt.Errorf("Unable to get showings of cycle %d: %v", cycleId, err)
+	}
+
+	if len(showings) == 0 {
+		return nil
+	}
+
+	cycle, err := s.data.GetCycle(cycleId)
+	if err != nil {
+		return fmt.Errorf("Unable to get cycle %d: %v", cycleId, err)
+	}
+
+	start := showings[0].Start
+	if cycle.Ended != nil && cycle.Ended.Equal(start) {
+		return nil
+	}
+
+	cycle.Ended = &start
+	if err = s.data.UpdateCycle(cycle); err != nil {
+		return fmt.Errorf("Unable to update cycle %d: %v", cycleId, err)
+	}
+	return nil
+}
+
+// showingViews returns the showings with their movies.  Showings of movies
+// that can't be found are skipped.
+func (s *Server) showingViews(showings []*common.Showing) []showingView {
+	views := []showingView{}
+	for _, showing := range showings {
+		movie, err := s.data.GetMovie(showing.MovieId)
+		if err != nil {
+			s.l.Error("Unable to get movie %d of showing %d: %v", showing.MovieId, showing.Id, err)
+			continue
+		}
+		views = append(views, showingView{Showing: showing, Movie: movie})
+	}
+	return views
+}
+
+// upcomingShowings returns the showings of the last finished cycle that
+// haven't ended yet.
+func (s *Server) upcomingShowings(cycle *common.Cycle) ([]showingView, error) {
+	if cycle == nil {
+		return nil, nil
+	}
+
+	showings, err := s.data.GetCycleShowings(cycle.Id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get showings of cycle %d: %v", cycle.Id, err)
+	}
+
+	upcoming := []showingView{}
+	for _, v := range s.showingViews(showings) {
+		if !v.Over() {
+			upcoming = append(upcoming, v)
+		}
+	}
+	return upcoming, nil
+}
+
+// Toggles RSVPs
+func (s *Server) handlerShowing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.doError(http.StatusMethodNotAllowed, "RSVPs must be POSTed", w, r)
+		return
+	}
+
+	user := s.getSessionUser(w, r)
+	if user == nil {
+		http.Redirect(w, r, "/user/login", http.StatusFound)
+		return
+	}
+
+	var showingId int
+	if _, err := fmt.Sscanf(r.URL.Path, "/showing/%d/rsvp", &showingId); err != nil {
+		s.doError(http.StatusNotFound, "Showing not found", w, r)
+		return
+	}
+
+	showing, err := s.data.GetShowing(showingId)
+	if err != nil {
+		s.doError(http.StatusNotFound, "Showing not found", w, r)
+		return
+	}
+
+	if (showingView{Showing: showing}).Over() {
+		s.doError(http.StatusBadRequest, "This showing is over", w, r)
+		return
+	}
+
+	if err = s.data.SetRsvp(showing.Id, user.Id, !showing.Attending(user.Id)); err != nil {
+		s.l.Error("Unable to RSVP user %d to showing %d: %v", user.Id, showing.Id, err)
+		s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+		return
+	}
+
+	ref := r.Header.Get("Referer")
+	if ref == "" {
+		ref = fmt.Sprintf("/movie/%d", showing.MovieId)
+	}
+	http.Redirect(w, r, ref, http.StatusFound)
+}
@@ -0,0 +1,267 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// setupShowingTest returns a server with a finished cycle in which the movie
+// "Picked Movie" was selected, and an admin to schedule it.
+func setupShowingTest(t *testing.T) (*Server, *common.User, *common.Movie) {
+	t.Helper()
+
+	s := newTestServer(t)
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+
+	cycleId, err := s.data.AddCycle(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cycle, err := s.data.GetCycle(cycleId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movieId, err := s.data.AddMovie(&common.Movie{Name: "Picked Movie", AddedBy: admin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movie, err := s.data.GetMovie(movieId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movie.CycleWatched = cycle
+	if err = s.data.UpdateMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+
+	ended := time.Now().Add(-time.Hour).Round(time.Hour)
+	cycle.Ended = &ended
+	if err = s.data.UpdateCycle(cycle); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, admin, movie
+}
+
+func postShowingForm(t *testing.T, s *Server, admin *common.User, form url.Values) {
+	t.Helper()
+
+	cookies := loginCookies(t, s, admin)
+	form.Set("CsrfToken", csrfTokenFor(t, s, cookies))
+
+	rec := postForm(s, "/admin/showings", form, cookies)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "errorMessage") {
+		t.Fatalf("Form was rejected:\n%s", rec.Body.String())
+	}
+}
+
+func Test_AdminShowings(t *testing.T) {
+	s, admin, movie := setupShowingTest(t)
+
+	start := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Minute)
+	postShowingForm(t, s, admin, url.Values{
+		"Form":     {"Add"},
+		"MovieId":  {fmt.Sprint(movie.Id)},
+		"Start":    {start.Format(inputDateTimeLayout)},
+		"Location": {"https://stream.example.com/watch"},
+	})
+
+	showings, err := s.data.GetMovieShowings(movie.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(showings) != 1 || !showings[0].Start.Equal(start) || showings[0].CycleId != movie.CycleWatched.Id {
+		t.Fatalf("Showing wasn't added: %v", showings)
+	}
+
+	// The watched date comes from the showing
+	cycle, err := s.data.GetCycle(movie.CycleWatched.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cycle.Ended == nil || !cycle.Ended.Equal(start) {
+		t.Errorf("Cycle end wasn't moved to the showing: %v", cycle.Ended)
+	}
+
+	// Earlier showings become the end of the cycle
+	earlier := start.Add(-24 * time.Hour)
+	postShowingForm(t, s, admin, url.Values{
+		"Form":     {"Update"},
+		"Id":       {fmt.Sprint(showings[0].Id)},
+		"Start":    {earlier.Format(inputDateTimeLayout)},
+		"Location": {"Living room"},
+	})
+
+	showing, err := s.data.GetShowing(showings[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !showing.Start.Equal(earlier) || showing.Location != "Living room" {
+		t.Errorf("Showing wasn't updated: %v", showing)
+	}
+
+	cycle, err = s.data.GetCycle(cycle.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cycle.Ended.Equal(earlier) {
+		t.Errorf("Cycle end wasn't updated: %v", cycle.Ended)
+	}
+
+	postShowingForm(t, s, admin, url.Values{
+		"Form": {"Delete"},
+		"Id":   {fmt.Sprint(showing.Id)},
+	})
+
+	if _, err = s.data.GetShowing(showing.Id); err == nil {
+		t.Errorf("Showing wasn't deleted")
+	}
+}
+
+func Test_ShowingRsvp(t *testing.T) {
+	s, admin, movie := setupShowingTest(t)
+	s.data.SetCfgString(ConfigHostAddress, "https://movies.example.com")
+
+	start := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Minute)
+	postShowingForm(t, s, admin, url.Values{
+		"Form":     {"Add"},
+		"MovieId":  {fmt.Sprint(movie.Id)},
+		"Start":    {start.Format(inputDateTimeLayout)},
+		"Location": {"https://stream.example.com/watch"},
+	})
+
+	showings, err := s.data.GetMovieShowings(movie.Id)
+	if err != nil || len(showings) != 1 {
+		t.Fatalf("Showing wasn't added: %v", err)
+	}
+	showing := showings[0]
+
+	user := addTestUser(t, s, "viewer", common.PRIV_USER)
+	cookies := loginCookies(t, s, user)
+
+	for _, path := range []string{"/", fmt.Sprintf("/movie/%d", movie.Id)} {
+		page := getPage(s, path, "", cookies)
+		for _, expected := range []string{
+			`<a href="https://stream.example.com/watch">`,
+			"(0 attending)",
+			fmt.Sprintf(`action="/showing/%d/rsvp"`, showing.Id),
+			">RSVP</button>",
+		} {
+			if !strings.Contains(page, expected) {
+				t.Errorf("%s is missing %q", path, expected)
+			}
+		}
+	}
+
+	rsvp := func() {
+		form := url.Values{"CsrfToken": {csrfTokenFor(t, s, cookies)}}
+		rec := postForm(s, fmt.Sprintf("/showing/%d/rsvp", showing.Id), form, cookies)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("Unexpected status %d", rec.Code)
+		}
+	}
+
+	rsvp()
+	if showing, err = s.data.GetShowing(showing.Id); err != nil || !showing.Attending(user.Id) {
+		t.Fatalf("RSVP wasn't saved: %v", err)
+	}
+
+	page := getPage(s, "/", "", cookies)
+	if !strings.Contains(page, "(1 attending)") || !strings.Contains(page, ">Cancel RSVP</button>") {
+		t.Errorf("Cycle page doesn't show the RSVP")
+	}
+
+	// RSVPed movies are in the private calendar without a vote
+	form := url.Values{
+		"CsrfToken": {csrfTokenFor(t, s, cookies)},
+		"Form":      {"CalendarNew"},
+	}
+	postForm(s, "/user", form, cookies)
+
+	if user, err = s.data.GetUser(user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	cal := getPage(s, privateCalendarPath(user), "", nil)
+	for _, expected := range []string{
+		"SUMMARY:Picked Movie\r\n",
+		fmt.Sprintf("UID:showing-%d@movies.example.com\r\n", showing.Id),
+		"DTSTART:" + calendarTime(start) + "\r\n",
+		"LOCATION:https://stream.example.com/watch\r\n",
+	} {
+		if !strings.Contains(cal, expected) {
+			t.Errorf("Private calendar is missing %q:\n%s", expected, cal)
+		}
+	}
+
+	// A second RSVP cancels it
+	rsvp()
+	if showing, err = s.data.GetShowing(showing.Id); err != nil || showing.Attending(user.Id) {
+		t.Fatalf("RSVP wasn't removed: %v", err)
+	}
+
+	if cal = getPage(s, privateCalendarPath(user), "", nil); strings.Contains(cal, "Picked Movie") {
+		t.Errorf("Private calendar still has the movie:\n%s", cal)
+	}
+
+	// Only admins schedule showings
+	form = url.Values{
+		"CsrfToken": {csrfTokenFor(t, s, cookies)},
+		"Form":      {"Delete"},
+		"Id":        {fmt.Sprint(showing.Id)},
+	}
+	postForm(s, "/admin/showings", form, cookies)
+
+	if _, err = s.data.GetShowing(showing.Id); err != nil {
+		t.Errorf("User deleted a showing: %v", err)
+	}
+}
+
+func Test_ShowingPurgedUser(t *testing.T) {
+	s, admin, movie := setupShowingTest(t)
+
+	postShowingForm(t, s, admin, url.Values{
+		"Form":     {"Add"},
+		"MovieId":  {fmt.Sprint(movie.Id)},
+		"Start":    {time.Now().Add(48 * time.Hour).Format(inputDateTimeLayout)},
+		"Location": {"Living room"},
+	})
+
+	showings, err := s.data.GetMovieShowings(movie.Id)
+	if err != nil || len(showings) != 1 {
+		t.Fatalf("Showing wasn't added: %v", err)
+	}
+
+	user := addTestUser(t, s, "viewer", common.PRIV_USER)
+	if err = s.data.SetRsvp(showings[0].Id, user.Id, true); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := loginCookies(t, s, admin)
+	form := url.Values{"confirm": {"yes"}, "CsrfToken": {csrfTokenFor(t, s, cookies)}}
+	rec := postForm(s, fmt.Sprintf("/admin/user/%d?action=purge", user.Id), form, cookies)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unable to purge user: %d", rec.Code)
+	}
+
+	showing, err := s.data.GetShowing(showings[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(showing.Attendees) != 0 {
+		t.Errorf("Purged user is still attending: %v", showing.Attendees)
+	}
+}
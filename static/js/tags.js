@@ -0,0 +1,42 @@
+// Suggests existing tags for inputs with a data-tags attribute.  Tags are
+// separated by commas and only the last one is completed.
+(function() {
+	if (!window.fetch) {
+		return;
+	}
+
+	document.querySelectorAll('input[data-tags]').forEach(function(input) {
+		var list = document.getElementById(input.getAttribute('list'));
+		var timer = null;
+
+		input.addEventListener('input', function() {
+			clearTimeout(timer);
+			timer = setTimeout(function() {
+				var parts = input.value.split(',');
+				var last = parts.pop().trim();
+				var before = parts.map(function(p) { return p.trim(); })
+					.filter(function(p) { return p !== ''; });
+
+				list.innerHTML = '';
+				if (last === '') {
+					return;
+				}
+
+				fetch('/tags?q=' + encodeURIComponent(last), {headers: {'Accept': 'application/json'}})
+					.then(function(resp) { return resp.json(); })
+					.then(function(names) {
+						list.innerHTML = '';
+						names.forEach(function(name) {
+							if (before.indexOf(name) !== -1) {
+								return;
+							}
+
+							var opt = document.createElement('option');
+							opt.value = before.concat([name]).join(', ');
+							list.appendChild(opt);
+						});
+					});
+			}, 200);
+		});
+	});
+})();
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores files in a directory on disk.  The server is expected to
+// serve the directory under UrlPrefix.
+type Local struct {
+	Dir       string
+	UrlPrefix string
+}
+
+func NewLocal(dir, urlPrefix string) *Local {
+	return &Local{Dir: dir, UrlPrefix: urlPrefix}
+}
+
+// path returns the file's path, refusing names that would leave the
+// directory.
+func (l *Local) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("Invalid file name %q", name)
+	}
+	return filepath.Join(l.Dir, name), nil
+}
+
+func (l *Local) Put(name string, data []byte, contentType string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(l.Dir, 0755); err != nil {
+		return fmt.Errorf("Unable to create directory: %v", err)
+	}
+
+	// Write to a temporary file first so a half written file is never
+	// served.
+	tmp, err := ioutil.TempFile(l.Dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Get(name string) (io.ReadCloser, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *Local) Exists(name string) (bool, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *Local) Url(name string) string {
+	return l.UrlPrefix + name
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type S3Config struct {
+	Endpoint  string // eg, "https://s3.eu-central-1.amazonaws.com" or "http://localhost:9000"
+	Region    string // defaults to us-east-1
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	Prefix string // prepended to every key, eg "posters/"
+
+	// Base URL the files are publicly readable under, eg a CDN.  If empty,
+	// the files are served through UrlPrefix instead.
+	PublicUrl string
+	UrlPrefix string
+}
+
+// S3 stores files in an S3 compatible bucket, like AWS S3 or MinIO.
+// Requests are signed with AWS Signature Version 4 and use path style
+// addressing (endpoint/bucket/key).
+type S3 struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("S3 endpoint and bucket are required")
+	}
+
+	if _, err := url.Parse(cfg.Endpoint); err != nil {
+		return nil, fmt.Errorf("Invalid S3 endpoint: %v", err)
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	cfg.PublicUrl = strings.TrimRight(cfg.PublicUrl, "/")
+
+	return &S3{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (s *S3) objectUrl(name string) string {
+	return s.cfg.Endpoint + "/" + s.cfg.Bucket + "/" + s.cfg.Prefix + name
+}
+
+func (s *S3) do(method, name string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectUrl(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	signV4(req, s.cfg.AccessKey, s.cfg.SecretKey, s.cfg.Region, "s3", time.Now())
+
+	return s.client.Do(req)
+}
+
+// s3Error reads the error message from an unexpected response.
+func s3Error(action string, resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("Unable to %s: %s %s", action, resp.Status, strings.TrimSpace(string(body)))
+}
+
+func (s *S3) Put(name string, data []byte, contentType string) error {
+	resp, err := s.do("PUT", name, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("store "+name, resp)
+	}
+	return nil
+}
+
+func (s *S3) Get(name string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", name, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	defer resp.Body.Close()
+	return nil, s3Error("get "+name, resp)
+}
+
+func (s *S3) Exists(name string) (bool, error) {
+	resp, err := s.do("HEAD", name, nil, "")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, s3Error("check "+name, resp)
+}
+
+func (s *S3) Url(name string) string {
+	if s.cfg.PublicUrl == "" {
+		return s.cfg.UrlPrefix + name
+	}
+	return s.cfg.PublicUrl + "/" + s.cfg.Prefix + name
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to the
+// request.  The X-Amz-Content-Sha256 header must already be set.  Every
+// header on the request is signed.
+func signV4(req *http.Request, accessKey, secretKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := &strings.Builder{}
+	for _, name := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSha256([]byte("AWS4"+secretKey), day)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, service)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, key := range keys {
+		vals := values[key]
+		sort.Strings(vals)
+		for _, val := range vals {
+			parts = append(parts, awsEscape(key)+"="+awsEscape(val))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent encodes everything but the unreserved characters.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
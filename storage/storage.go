@@ -0,0 +1,23 @@
+// Package storage holds the backends posters can be stored in.
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by Get for files that don't exist.
+var ErrNotFound = errors.New("File not found")
+
+// Storage is a flat store of files, addressed by name.
+type Storage interface {
+	// Store a file, replacing any existing file with the same name.
+	Put(name string, data []byte, contentType string) error
+	// Read a file.  Returns ErrNotFound if it doesn't exist.
+	Get(name string) (io.ReadCloser, error)
+	Exists(name string) (bool, error)
+
+	// The URL clients load the file from.  This can be relative to the
+	// site.
+	Url(name string) string
+}
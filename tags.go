@@ -0,0 +1,281 @@
+package moviepoll
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/i18n"
+)
+
+type adminTag struct {
+	*common.Tag
+	Movies int
+}
+
+func (t adminTag) AliasList() string {
+	return strings.Join(t.Aliases, ", ")
+}
+
+type dataAdminTags struct {
+	dataPageBase
+
+	Tags []adminTag
+
+	ErrorMessage   []string
+	SuccessMessage string
+}
+
+// Number of names returned by the autocomplete endpoint
+const tagSuggestLimit int = 10
+
+// parseTagInput splits a comma separated list of tag names and checks it
+// against the configured limits.  Problems are returned as messages for the
+// user in their language.
+func (s *Server) parseTagInput(text string, locale *i18n.Locale) (names []string, problems []string, err error) {
+	maxTags, err := s.data.GetCfgInt(ConfigMaxTags, DefaultMaxTags)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to get %q: %v", ConfigMaxTags, err)
+	}
+
+	maxLength, err := s.data.GetCfgInt(ConfigMaxTagLength, DefaultMaxTagLength)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to get %q: %v", ConfigMaxTagLength, err)
+	}
+
+	seen := map[string]bool{}
+	for _, name := range strings.Split(text, ",") {
+		name = strings.Join(strings.Fields(name), " ")
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		if common.GetStringLength(name) > maxLength {
+			problems = append(problems, locale.T("Tag %q too long! Max Length: %d characters", name, maxLength))
+		}
+		names = append(names, name)
+	}
+
+	if maxTags > 0 && len(names) > maxTags {
+		problems = append(problems, locale.T("Too many tags! Max: %d tags", maxTags))
+	}
+
+	return names, problems, nil
+}
+
+// addTags returns the tags with the given names, adding the ones that don't
+// exist yet.  Names matching an alias get the aliased tag.  Empty names and
+// duplicates are skipped.
+func (s *Server) addTags(names []string) ([]*common.Tag, error) {
+	tags := []*common.Tag{}
+	seen := map[int]bool{}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		id, err := s.data.FindTag(name)
+		if err != nil {
+			id, err = s.data.AddTag(&common.Tag{Name: name})
+			if err != nil {
+				return nil, fmt.Errorf("Unable to add tag %q: %v", name, err)
+			}
+		}
+
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		tags = append(tags, s.data.GetTag(id))
+	}
+
+	return tags, nil
+}
+
+// handlerTagSuggest returns the names of existing tags starting with q, for
+// autocompleting tag inputs.  Aliases match, but the tag's name is returned.
+func (s *Server) handlerTagSuggest(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+
+	tags, err := s.data.GetTags()
+	if err != nil {
+		s.l.Error("Unable to get tags: %v", err)
+		writeJson(w, http.StatusInternalServerError, []string{})
+		return
+	}
+
+	names := []string{}
+	for _, tag := range tags {
+		if len(names) >= tagSuggestLimit {
+			break
+		}
+
+		for _, name := range append([]string{tag.Name}, tag.Aliases...) {
+			if strings.HasPrefix(strings.ToLower(name), query) {
+				names = append(names, tag.Name)
+				break
+			}
+		}
+	}
+
+	writeJson(w, http.StatusOK, names)
+}
+
+// tagUsage counts the movies using each tag, including watched movies.
+func (s *Server) tagUsage() (map[int]int, error) {
+	movies, err := s.data.GetMovies()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := map[int]int{}
+	for _, movie := range movies {
+		for _, tag := range movie.Tags {
+			usage[tag.Id]++
+		}
+	}
+	return usage, nil
+}
+
+// splitTagAliases splits a comma separated list, dropping empty entries and
+// the tag's own name.
+func splitTagAliases(list, name string) []string {
+	aliases := []string{}
+	for _, alias := range strings.Split(list, ",") {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || strings.EqualFold(alias, name) {
+			continue
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases
+}
+
+func (s *Server) handlerAdminTags(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminRights(w, r) {
+		return
+	}
+
+	data := dataAdminTags{}
+
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			s.doError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Unable to parse form: %v", err),
+				w, r)
+			return
+		}
+
+		if err := s.adminEditTag(r, &data); err != nil {
+			s.doError(http.StatusInternalServerError, err.Error(), w, r)
+			return
+		}
+	}
+
+	tags, err := s.data.GetTags()
+	if err != nil {
+		s.doError(
+			http.StatusInternalServerError,
+			fmt.Sprintf("Unable to get tags: %v", err),
+			w, r)
+		return
+	}
+
+	usage, err := s.tagUsage()
+	if err != nil {
+		s.doError(
+			http.StatusInternalServerError,
+			fmt.Sprintf("Unable to get movies: %v", err),
+			w, r)
+		return
+	}
+
+	for _, tag := range tags {
+		data.Tags = append(data.Tags, adminTag{Tag: tag, Movies: usage[tag.Id]})
+	}
+
+	data.dataPageBase = s.newPageBase("Admin - Tags", w, r)
+
+	if err := s.executeTemplate(w, "adminTags", data); err != nil {
+		s.l.Error("Error rendering template: %v", err)
+	}
+}
+
+// adminEditTag handles the rename, merge and delete forms.  Mistakes in the
+// form end up in data.ErrorMessage, other errors are returned.
+func (s *Server) adminEditTag(r *http.Request, data *dataAdminTags) error {
+	id, err := strconv.Atoi(r.PostFormValue("Id"))
+	if err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, "Invalid tag ID")
+		return nil
+	}
+
+	tag := s.data.GetTag(id)
+	if tag == nil {
+		data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Tag with ID %d not found", id))
+		return nil
+	}
+
+	switch r.PostFormValue("Form") {
+	case "Update":
+		name := strings.TrimSpace(r.PostFormValue("Name"))
+		updated := &common.Tag{
+			Id:      tag.Id,
+			Name:    name,
+			Aliases: splitTagAliases(r.PostFormValue("Aliases"), name),
+		}
+
+		if err := s.data.UpdateTag(updated); err != nil {
+			data.ErrorMessage = append(data.ErrorMessage, err.Error())
+			return nil
+		}
+		data.SuccessMessage = fmt.Sprintf("Tag %q updated", updated.Name)
+
+	case "Merge":
+		into, err := strconv.Atoi(r.PostFormValue("Into"))
+		if err != nil || s.data.GetTag(into) == nil {
+			data.ErrorMessage = append(data.ErrorMessage, "Select a tag to merge into")
+			return nil
+		}
+
+		if into == tag.Id {
+			data.ErrorMessage = append(data.ErrorMessage, "Cannot merge a tag into itself")
+			return nil
+		}
+
+		name := tag.Name
+		if err := s.data.MergeTags(tag.Id, into); err != nil {
+			return fmt.Errorf("Unable to merge tags: %v", err)
+		}
+		data.SuccessMessage = fmt.Sprintf("Tag %q merged into %q", name, s.data.GetTag(into).Name)
+
+	case "Delete":
+		usage, err := s.tagUsage()
+		if err != nil {
+			return fmt.Errorf("Unable to get movies: %v", err)
+		}
+
+		if usage[tag.Id] > 0 {
+			data.ErrorMessage = append(data.ErrorMessage,
+				fmt.Sprintf("Tag %q is used by %d movies.  Merge it into another tag instead.", tag.Name, usage[tag.Id]))
+			return nil
+		}
+
+		s.data.DeleteTag(tag.Id)
+		data.SuccessMessage = fmt.Sprintf("Tag %q deleted", tag.Name)
+
+	default:
+		data.ErrorMessage = append(data.ErrorMessage, "Unknown form")
+		return nil
+	}
+
+	s.search.invalidate()
+	return nil
+}
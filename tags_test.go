@@ -0,0 +1,181 @@
+package moviepoll
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+func Test_AdminTags(t *testing.T) {
+	s := newTestServer(t)
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+	cookies := loginCookies(t, s, admin)
+	token := csrfTokenFor(t, s, cookies)
+
+	addSearchMovie(t, s, &common.Movie{Name: "Alien", Tags: []*common.Tag{&common.Tag{Name: "Sci-Fi"}, &common.Tag{Name: "Horror"}}})
+	addSearchMovie(t, s, &common.Movie{Name: "Arrival", Tags: []*common.Tag{&common.Tag{Name: "Science Fiction"}}})
+	addSearchMovie(t, s, &common.Movie{Name: "Heat", Tags: []*common.Tag{&common.Tag{Name: "sci fi"}, &common.Tag{Name: "Science Fiction"}}})
+
+	unused, err := s.data.AddTag(&common.Tag{Name: "Unused"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scifi, _ := s.data.FindTag("Sci-Fi")
+	science, _ := s.data.FindTag("Science Fiction")
+	sciSpace, _ := s.data.FindTag("sci fi")
+
+	post := func(form url.Values) string {
+		t.Helper()
+		form.Set("CsrfToken", token)
+		rec := postForm(s, "/admin/tags", form, cookies)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Unexpected status %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	body := post(url.Values{"Form": {"Merge"}, "Id": {strconv.Itoa(scifi)}, "Into": {strconv.Itoa(science)}})
+	if !strings.Contains(body, "merged into") {
+		t.Fatalf("Merge failed")
+	}
+
+	body = post(url.Values{"Form": {"Merge"}, "Id": {strconv.Itoa(sciSpace)}, "Into": {strconv.Itoa(science)}})
+	if !strings.Contains(body, "merged into") {
+		t.Fatalf("Merge failed")
+	}
+
+	// Heat had both tags, it should only have one now
+	movies, err := s.data.GetMovies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, movie := range movies {
+		count := 0
+		for _, tag := range movie.Tags {
+			if tag.Id == scifi || tag.Id == sciSpace {
+				t.Errorf("%s still has a merged tag", movie.Name)
+			}
+			if tag.Id == science {
+				count++
+			}
+		}
+
+		if count != 1 {
+			t.Errorf("%s has the merged tag %d times", movie.Name, count)
+		}
+	}
+
+	// Future autofills map onto the merged tag
+	if id, err := s.data.AddTag(&common.Tag{Name: " SCI-FI"}); err != nil || id != science {
+		t.Errorf("Alias was not used: %d %v", id, err)
+	}
+
+	body = post(url.Values{"Form": {"Update"}, "Id": {strconv.Itoa(science)}, "Name": {"Sci-Fi"}, "Aliases": {"Science Fiction, sci fi, , SF"}})
+	if !strings.Contains(body, "updated") {
+		t.Fatalf("Rename failed")
+	}
+
+	tag := s.data.GetTag(science)
+	if tag.Name != "Sci-Fi" || strings.Join(tag.Aliases, ",") != "Science Fiction,sci fi,SF" {
+		t.Errorf("Unexpected tag: %+v", tag)
+	}
+
+	if names := strings.Join(searchNames(t, s, "tag:sf"), ","); names != "Alien,Arrival,Heat" {
+		t.Errorf("Search by alias found %q", names)
+	}
+
+	body = post(url.Values{"Form": {"Update"}, "Id": {strconv.Itoa(unused)}, "Name": {"Unused"}, "Aliases": {"sf"}})
+	if !strings.Contains(body, "already used by the tag") {
+		t.Errorf("Duplicate alias was accepted")
+	}
+
+	horror, _ := s.data.FindTag("Horror")
+	body = post(url.Values{"Form": {"Delete"}, "Id": {strconv.Itoa(horror)}})
+	if !strings.Contains(body, "used by 1 movies") || s.data.GetTag(horror) == nil {
+		t.Errorf("Used tag was deleted")
+	}
+
+	post(url.Values{"Form": {"Delete"}, "Id": {strconv.Itoa(unused)}})
+	if s.data.GetTag(unused) != nil {
+		t.Errorf("Unused tag was not deleted")
+	}
+}
+
+func Test_AddMovieTags(t *testing.T) {
+	s, user, _ := setupVoteTest(t)
+	s.data.SetCfgInt(ConfigMaxTags, 3)
+	s.data.SetCfgInt(ConfigMaxTagLength, 12)
+
+	cookies := loginCookies(t, s, user)
+	token := csrfTokenFor(t, s, cookies)
+
+	if _, err := s.data.AddTag(&common.Tag{Name: "Science Fiction", Aliases: []string{"Sci-Fi"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{
+		"CsrfToken":   {token},
+		"MovieName":   {"Tagged Movie"},
+		"Description": {"Manually added"},
+		"Links":       {"https://example.com/tagged"},
+		"Tags":        {"sci-fi, Space Opera, space opera, Far Too Long Tag"},
+	}
+
+	rec := postForm(s, "/add", form, cookies)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "too long! Max Length: 12") {
+		t.Fatalf("Long tag was accepted: %d", rec.Code)
+	}
+
+	form.Set("Tags", "a, b, c, d")
+	rec = postForm(s, "/add", form, cookies)
+	if !strings.Contains(rec.Body.String(), "Too many tags! Max: 3") {
+		t.Fatalf("Too many tags were accepted")
+	}
+
+	form.Set("Tags", "sci-fi, Space  Opera, space opera")
+	rec = postForm(s, "/add", form, cookies)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Movie was not added: %d", rec.Code)
+	}
+
+	var movieId int
+	if _, err := fmt.Sscanf(rec.Header().Get("Location"), "/movie/%d", &movieId); err != nil {
+		t.Fatal(err)
+	}
+
+	movie, err := s.data.GetMovie(movieId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, tag := range movie.Tags {
+		names = append(names, tag.Name)
+	}
+
+	if strings.Join(names, ",") != "Science Fiction,Space Opera" {
+		t.Errorf("Unexpected tags: %q", names)
+	}
+
+	req := httptest.NewRequest("GET", "/tags?q=sp", nil)
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+
+	suggestions := []string{}
+	if err := json.NewDecoder(rec.Body).Decode(&suggestions); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(suggestions, ",") != "Space Opera" {
+		t.Errorf("Unexpected suggestions: %q", suggestions)
+	}
+}
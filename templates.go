@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"path"
+	"time"
 
 	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/i18n"
 )
 
 const TEMPLATE_DIR = "templates/"
@@ -26,6 +29,8 @@ var templateDefs map[string][]string = map[string][]string{
 	"history":       []string{"history.html"},
 	"auth":          []string{"auth.html"},
 	"passwordReset": []string{"password.html"},
+	"twofactor":     []string{"twofactor.html"},
+	"movieEdit":     []string{"movie-edit.html"},
 
 	"adminHome":      []string{"admin/base.html", "admin/home.html"},
 	"adminConfig":    []string{"admin/base.html", "admin/config.html"},
@@ -37,6 +42,10 @@ var templateDefs map[string][]string = map[string][]string{
 	"adminMovieEdit": []string{"admin/base.html", "admin/movie-edit.html"},
 	"adminNotice":    []string{"admin/base.html", "admin/notice.html"},
 	"adminConfirm":   []string{"admin/base.html", "admin/confirmation.html"},
+	"adminShowings":  []string{"admin/base.html", "admin/showings.html"},
+	"adminWebhooks":  []string{"admin/base.html", "admin/webhooks.html"},
+	"adminTags":      []string{"admin/base.html", "admin/tags.html"},
+	"adminBranding":  []string{"admin/base.html", "admin/branding.html"},
 }
 
 func (s *Server) registerTemplates() error {
@@ -48,7 +57,10 @@ func (s *Server) registerTemplates() error {
 			fpth = append(fpth, TEMPLATE_DIR+f)
 		}
 
-		t, err := template.ParseFiles(fpth...)
+		t, err := template.New(path.Base(fpth[0])).Funcs(template.FuncMap{
+			"posterUrl": s.posterUrl,
+			"asset":     s.assetUrl,
+		}).ParseFS(s.files, fpth...)
 		if err != nil {
 			return fmt.Errorf("Error parsing template %s: %v", fpth, err)
 		}
@@ -90,21 +102,82 @@ func (s *Server) newPageBase(title string, w http.ResponseWriter, r *http.Reques
 		s.l.Error("Unable to get notice message from database: %v", err)
 	}
 
+	user := s.getSessionUser(w, r)
+	locale := s.requestLocale(user, r)
+
 	return dataPageBase{
-		PageTitle: title,
+		PageTitle: locale.T(title),
 		Notice:    notice,
+		Branding:  s.getBranding(),
+		Locale:    locale,
+		Location:  s.userLocation(user),
 
-		User:         s.getSessionUser(w, r),
+		User:         user,
 		CurrentCycle: cycle,
+		CsrfToken:    s.csrfToken(w, r),
 	}
 }
 
 type dataPageBase struct {
 	PageTitle string
 	Notice    string
+	Branding  branding
+	Locale    *i18n.Locale
+	Location  *time.Location // time zone of the viewer
 
 	User         *common.User
 	CurrentCycle *common.Cycle
+
+	// Must be included in every POSTed form
+	CsrfToken string
+}
+
+// T translates a message into the language of the page.
+func (d dataPageBase) T(msg string, args ...interface{}) string {
+	return d.Locale.T(msg, args...)
+}
+
+// inZone returns the time in the viewer's time zone.
+func (d dataPageBase) inZone(t *time.Time) *time.Time {
+	if t == nil || d.Location == nil {
+		return t
+	}
+	local := t.In(d.Location)
+	return &local
+}
+
+// ShortDate formats a date without the year for the viewer.
+func (d dataPageBase) ShortDate(t *time.Time) string {
+	return d.Locale.ShortDate(d.inZone(t))
+}
+
+// LongDate formats a date with the year for the viewer.
+func (d dataPageBase) LongDate(t *time.Time) string {
+	return d.Locale.LongDate(d.inZone(t))
+}
+
+// DateTime formats a date with the time of day for the viewer.
+func (d dataPageBase) DateTime(t *time.Time) string {
+	return d.Locale.DateTime(d.inZone(t))
+}
+
+// Until formats the time left until t, eg "2d 4h".
+func (d dataPageBase) Until(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatWait(d.Locale, time.Until(*t))
+}
+
+// requestLocale returns the language the user picked on their account page,
+// or the best match for their browser's languages.
+func (s *Server) requestLocale(user *common.User, r *http.Request) *i18n.Locale {
+	if user != nil && user.Locale != "" {
+		if l := i18n.Get(user.Locale); l != nil {
+			return l
+		}
+	}
+	return i18n.Negotiate(r.Header.Get("Accept-Language"))
 }
 
 type dataMovieError struct {
@@ -116,6 +189,9 @@ type dataLoginForm struct {
 	dataPageBase
 	ErrorMessage string
 	Authed       bool
+
+	// Display name of the OIDC provider.  Empty if disabled.
+	OidcName string
 }
 
 type dataAddMovie struct {
@@ -129,20 +205,25 @@ type dataAddMovie struct {
 	ErrRemarks     bool
 	ErrPoster      bool
 	ErrAutofill    bool
+	ErrTags        bool
 
 	// Values for input if error
 	ValTitle       string
 	ValDescription string
 	ValLinks       string
 	ValRemarks     string
+	ValTags        string
 	//ValPoster      bool
 
 	AutofillEnabled bool
 	FormfillEnabled bool
+
+	// Reason the user cannot add a movie right now, if any.
+	RateLimited string
 }
 
 func (d dataAddMovie) isError() bool {
-	return d.ErrTitle || d.ErrDescription || d.ErrLinks || d.ErrPoster || d.ErrAutofill || d.ErrRemarks
+	return d.ErrTitle || d.ErrDescription || d.ErrLinks || d.ErrPoster || d.ErrAutofill || d.ErrRemarks || d.ErrTags
 }
 
 type dataError struct {
@@ -0,0 +1,76 @@
+package moviepoll
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // zone names work on hosts without a zoneinfo database
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// Layouts of the date and datetime-local inputs on the admin pages
+const (
+	inputDateLayout     string = "2006-01-02"
+	inputDateTimeLayout string = "2006-01-02T15:04"
+)
+
+// loadTimeZone returns the location of an IANA zone name, eg
+// "Europe/Berlin".  The name of the server's local zone isn't accepted
+// because it means something different on every host.
+func loadTimeZone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("%q is not a time zone", name)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a time zone", name)
+	}
+	return loc, nil
+}
+
+// serverLocation returns the time zone cycle dates are entered in on the
+// admin pages, and shown in for users without their own time zone.
+func (s *Server) serverLocation() *time.Location {
+	name, err := s.data.GetCfgString(ConfigTimeZone, DefaultTimeZone)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigTimeZone, err)
+		return time.UTC
+	}
+
+	loc, err := loadTimeZone(name)
+	if err != nil {
+		s.l.Error("Invalid %s: %v", ConfigTimeZone, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// userLocation returns the time zone dates are shown in for the user.  Nil
+// users get the server's time zone.
+func (s *Server) userLocation(user *common.User) *time.Location {
+	if user != nil && user.TimeZone != "" {
+		if loc, err := loadTimeZone(user.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return s.serverLocation()
+}
+
+// parseInputTime parses the value of a datetime-local or date input in the
+// given location.  Dates without a time are at midnight.
+func parseInputTime(value string, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	t, err := time.ParseInLocation(inputDateTimeLayout, value, loc)
+	if err != nil {
+		t, err = time.ParseInLocation(inputDateLayout, value, loc)
+	}
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid date %q", value)
+	}
+	return t, nil
+}
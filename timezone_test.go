@@ -0,0 +1,120 @@
+package moviepoll
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/i18n"
+)
+
+func Test_ParseInputTime(t *testing.T) {
+	berlin, err := loadTimeZone("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]time.Time{
+		"2021-03-05T20:30": time.Date(2021, time.March, 5, 19, 30, 0, 0, time.UTC),
+		"2021-07-05T20:30": time.Date(2021, time.July, 5, 18, 30, 0, 0, time.UTC),
+		" 2021-03-05 ":     time.Date(2021, time.March, 4, 23, 0, 0, 0, time.UTC),
+	}
+
+	for input, expected := range tests {
+		parsed, err := parseInputTime(input, berlin)
+		if err != nil {
+			t.Errorf("Unable to parse %q: %v", input, err)
+		} else if !parsed.Equal(expected) {
+			t.Errorf("%q parsed as %s, expected %s", input, parsed.UTC(), expected)
+		}
+	}
+
+	if _, err = parseInputTime("next friday", berlin); err == nil {
+		t.Errorf("Expected an error for an invalid date")
+	}
+
+	for _, name := range []string{"", "Local", "Mars/Olympus_Mons"} {
+		if _, err = loadTimeZone(name); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+	}
+}
+
+func Test_CycleTimeZones(t *testing.T) {
+	s := newTestServer(t)
+	s.data.SetCfgString(ConfigTimeZone, "Europe/Berlin")
+
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+	adminCookies := loginCookies(t, s, admin)
+
+	berlin, _ := loadTimeZone("Europe/Berlin")
+	end := time.Now().Add(50 * time.Hour).In(berlin).Truncate(time.Minute)
+
+	form := url.Values{
+		"CsrfToken":  {csrfTokenFor(t, s, adminCookies)},
+		"actionType": {"create"},
+		"endDate":    {end.Format(inputDateTimeLayout)},
+	}
+	if rec := postForm(s, "/admin/cyclepost", form, adminCookies); rec.Code != http.StatusSeeOther {
+		t.Fatalf("Unable to create cycle: %d", rec.Code)
+	}
+
+	cycle, err := s.data.GetCurrentCycle()
+	if err != nil || cycle == nil || cycle.PlannedEnd == nil {
+		t.Fatalf("Cycle was not created: %v", err)
+	}
+
+	if !cycle.PlannedEnd.Equal(end) {
+		t.Fatalf("Planned end is %s, expected %s", cycle.PlannedEnd.UTC(), end.UTC())
+	}
+
+	// Users see the planned end in their own time zone
+	user := addTestUser(t, s, "viewer", common.PRIV_USER)
+	cookies := loginCookies(t, s, user)
+
+	form = url.Values{
+		"CsrfToken": {csrfTokenFor(t, s, cookies)},
+		"Form":      {"TimeZone"},
+		"TimeZone":  {"America/New_York"},
+	}
+	body := postForm(s, "/user", form, cookies).Body.String()
+	if !strings.Contains(body, "Time zone changed") {
+		t.Fatalf("Time zone was not changed:\n%s", body)
+	}
+
+	newYork, _ := loadTimeZone("America/New_York")
+	for _, tst := range []struct {
+		cookies   []*http.Cookie
+		loc       *time.Location
+		language  string
+		countdown string
+	}{
+		{cookies, newYork, "", "2d 1h"},
+		{nil, berlin, "", "2d 1h"},
+		{nil, berlin, "de", "2T 1Std"},
+	} {
+		local := end.In(tst.loc)
+		expected := i18n.Negotiate(tst.language).DateTime(&local)
+
+		page := getPage(s, "/", tst.language, tst.cookies)
+		if !strings.Contains(page, expected) {
+			t.Errorf("Page in %s is missing the planned end %q", tst.loc, expected)
+		}
+
+		if !strings.Contains(page, tst.countdown) {
+			t.Errorf("Page in %s is missing the countdown %q", tst.loc, tst.countdown)
+		}
+	}
+
+	form.Set("TimeZone", "Nowhere/Special")
+	if rec := postForm(s, "/user", form, cookies); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected unknown time zone to be rejected, got %d", rec.Code)
+	}
+
+	if u, _ := s.data.GetUser(user.Id); u.TimeZone != "America/New_York" {
+		t.Errorf("Unexpected time zone %q", u.TimeZone)
+	}
+}
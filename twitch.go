@@ -0,0 +1,648 @@
+package moviepoll
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/i18n"
+)
+
+const (
+	twitchDialTimeout    time.Duration = 10 * time.Second
+	twitchReconnectDelay time.Duration = 30 * time.Second
+	// Twitch pings every five minutes, anything longer means the
+	// connection is gone.
+	twitchReadTimeout time.Duration = 6 * time.Minute
+
+	// How long a code from the account page can be used to link a Twitch
+	// account.
+	twitchLinkTimeout time.Duration = 10 * time.Minute
+
+	// Number of movies listed by !poll
+	twitchPollLength int = 5
+	// Number of suggestions waiting for autofill
+	twitchSuggestQueue int = 10
+	twitchMaxMessage   int = 450
+)
+
+// Minimum time between messages sent to chat.  Twitch silently drops
+// messages from accounts that talk too fast.
+var twitchMessageInterval time.Duration = 1500 * time.Millisecond
+
+type twitchConfig struct {
+	Server  string
+	Tls     bool
+	Nick    string
+	Token   string
+	Channel string
+}
+
+// twitchBot sits in a Twitch channel and answers chat commands:
+//
+//	!poll            current standings
+//	!vote <id>       vote for a movie
+//	!suggest <link>  add a movie using autofill
+//	!link <code>     link the Twitch account to a MoviePolls account
+//
+// Voting and suggesting require a linked account.
+type twitchBot struct {
+	s   *Server
+	cfg twitchConfig
+
+	writeLock *sync.Mutex
+	conn      net.Conn
+	lastSend  time.Time
+
+	linkLock *sync.Mutex
+	links    map[string]twitchLink
+
+	suggestions chan twitchSuggestion
+	quit        chan struct{}
+}
+
+type twitchLink struct {
+	UserId  int
+	Expires time.Time
+}
+
+// twitchSuggestion only keeps the user's ID, the account is looked up again
+// when the suggestion is processed in case it changed in the meantime.
+type twitchSuggestion struct {
+	Nick   string
+	UserId int
+	Link   string
+}
+
+// ircMessage is a single line from the server, without tags.
+type ircMessage struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// Nick returns the nickname from the prefix.
+func (m ircMessage) Nick() string {
+	nick := m.Prefix
+	if idx := strings.Index(nick, "!"); idx >= 0 {
+		nick = nick[:idx]
+	}
+	return strings.ToLower(nick)
+}
+
+func parseIrcLine(line string) ircMessage {
+	msg := ircMessage{}
+	line = strings.TrimRight(line, "\r\n")
+
+	// Tags are only sent if requested, but skip them anyway.
+	if strings.HasPrefix(line, "@") {
+		if idx := strings.Index(line, " "); idx >= 0 {
+			line = line[idx+1:]
+		}
+	}
+
+	if strings.HasPrefix(line, ":") {
+		idx := strings.Index(line, " ")
+		if idx < 0 {
+			return msg
+		}
+		msg.Prefix = line[1:idx]
+		line = line[idx+1:]
+	}
+
+	trailing := ""
+	hasTrailing := false
+	if idx := strings.Index(line, " :"); idx >= 0 {
+		trailing = line[idx+2:]
+		line = line[:idx]
+		hasTrailing = true
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		msg.Command = strings.ToUpper(fields[0])
+		msg.Params = fields[1:]
+	}
+
+	if hasTrailing {
+		msg.Params = append(msg.Params, trailing)
+	}
+	return msg
+}
+
+// startTwitch connects the chat bot if it's enabled.  Changes to the
+// settings need a restart.
+func (s *Server) startTwitch() error {
+	enabled, err := s.data.GetCfgBool(ConfigTwitchEnabled, DefaultTwitchEnabled)
+	if err != nil {
+		return fmt.Errorf("Unable to get %s: %v", ConfigTwitchEnabled, err)
+	}
+
+	if !enabled {
+		return nil
+	}
+
+	cfg := twitchConfig{}
+	if cfg.Server, err = s.data.GetCfgString(ConfigTwitchServer, DefaultTwitchServer); err != nil {
+		return fmt.Errorf("Unable to get %s: %v", ConfigTwitchServer, err)
+	}
+
+	if cfg.Tls, err = s.data.GetCfgBool(ConfigTwitchTls, DefaultTwitchTls); err != nil {
+		return fmt.Errorf("Unable to get %s: %v", ConfigTwitchTls, err)
+	}
+
+	if cfg.Nick, err = s.data.GetCfgString(ConfigTwitchNick, ""); err != nil {
+		return fmt.Errorf("Unable to get %s: %v", ConfigTwitchNick, err)
+	}
+
+	if cfg.Token, err = s.data.GetCfgString(ConfigTwitchToken, ""); err != nil {
+		return fmt.Errorf("Unable to get %s: %v", ConfigTwitchToken, err)
+	}
+
+	if cfg.Channel, err = s.data.GetCfgString(ConfigTwitchChannel, ""); err != nil {
+		return fmt.Errorf("Unable to get %s: %v", ConfigTwitchChannel, err)
+	}
+
+	if cfg.Nick == "" || cfg.Channel == "" {
+		return fmt.Errorf("The Twitch bot needs %s and %s to be set", ConfigTwitchNick, ConfigTwitchChannel)
+	}
+
+	s.twitch = newTwitchBot(s, cfg)
+	go s.twitch.run()
+	return nil
+}
+
+func newTwitchBot(s *Server, cfg twitchConfig) *twitchBot {
+	cfg.Nick = strings.ToLower(cfg.Nick)
+	cfg.Channel = "#" + strings.ToLower(strings.TrimPrefix(cfg.Channel, "#"))
+	if cfg.Token != "" && !strings.HasPrefix(cfg.Token, "oauth:") {
+		cfg.Token = "oauth:" + cfg.Token
+	}
+
+	return &twitchBot{
+		s:   s,
+		cfg: cfg,
+
+		writeLock: &sync.Mutex{},
+		linkLock:  &sync.Mutex{},
+		links:     map[string]twitchLink{},
+
+		suggestions: make(chan twitchSuggestion, twitchSuggestQueue),
+		quit:        make(chan struct{}),
+	}
+}
+
+// run keeps the bot connected until stop is called.
+func (b *twitchBot) run() {
+	go b.processSuggestions()
+
+	for {
+		err := b.connect()
+		select {
+		case <-b.quit:
+			return
+		default:
+		}
+
+		b.s.l.Error("Twitch connection lost: %v", err)
+
+		select {
+		case <-b.quit:
+			return
+		case <-time.After(twitchReconnectDelay):
+		}
+	}
+}
+
+func (b *twitchBot) stop() {
+	close(b.quit)
+
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+	if b.conn != nil {
+		b.conn.Close()
+	}
+}
+
+// connect logs in, joins the channel, and handles messages until the
+// connection is closed.
+func (b *twitchBot) connect() error {
+	dialer := &net.Dialer{Timeout: twitchDialTimeout}
+
+	var conn net.Conn
+	var err error
+	if b.cfg.Tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.cfg.Server, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", b.cfg.Server)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Unable to connect to %s: %v", b.cfg.Server, err)
+	}
+	defer conn.Close()
+
+	b.writeLock.Lock()
+	b.conn = conn
+	b.writeLock.Unlock()
+
+	if b.cfg.Token != "" {
+		if err = b.send("PASS " + b.cfg.Token); err != nil {
+			return err
+		}
+	}
+
+	if err = b.send("NICK " + b.cfg.Nick); err != nil {
+		return err
+	}
+
+	if err = b.send("JOIN " + b.cfg.Channel); err != nil {
+		return err
+	}
+
+	b.s.l.Info("Connected to Twitch chat in %s", b.cfg.Channel)
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(twitchReadTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		b.handleLine(line)
+	}
+}
+
+// send writes a raw line to the server.
+func (b *twitchBot) send(line string) error {
+	b.writeLock.Lock()
+	defer b.writeLock.Unlock()
+
+	if b.conn == nil {
+		return fmt.Errorf("Not connected")
+	}
+
+	_, err := fmt.Fprintf(b.conn, "%s\r\n", line)
+	return err
+}
+
+// truncateMessage shortens a message to at most max bytes without splitting
+// a multi-byte character.
+func truncateMessage(message string, max int) string {
+	if len(message) <= max {
+		return message
+	}
+
+	cut := max
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+	return message[:cut]
+}
+
+// say sends a message to the channel, waiting if the last one was sent too
+// recently.
+func (b *twitchBot) say(message string) {
+	message = strings.NewReplacer("\r", " ", "\n", " ").Replace(message)
+	message = truncateMessage(message, twitchMaxMessage)
+
+	b.writeLock.Lock()
+	wait := time.Until(b.lastSend.Add(twitchMessageInterval))
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	b.lastSend = time.Now()
+	b.writeLock.Unlock()
+
+	if err := b.send(fmt.Sprintf("PRIVMSG %s :%s", b.cfg.Channel, message)); err != nil {
+		b.s.l.Error("Unable to send Twitch message: %v", err)
+	}
+}
+
+func (b *twitchBot) reply(nick, message string) {
+	b.say("@" + nick + " " + message)
+}
+
+func (b *twitchBot) handleLine(line string) {
+	msg := parseIrcLine(line)
+
+	switch msg.Command {
+	case "PING":
+		pong := "PONG"
+		if len(msg.Params) > 0 {
+			pong += " :" + msg.Params[len(msg.Params)-1]
+		}
+		b.send(pong)
+
+	case "RECONNECT":
+		// Twitch is restarting the server, reconnect right away.
+		b.writeLock.Lock()
+		b.conn.Close()
+		b.writeLock.Unlock()
+
+	case "PRIVMSG":
+		if len(msg.Params) < 2 || strings.ToLower(msg.Params[0]) != b.cfg.Channel {
+			return
+		}
+
+		nick := msg.Nick()
+		if nick == "" || nick == b.cfg.Nick {
+			return
+		}
+
+		b.handleCommand(nick, msg.Params[1])
+	}
+}
+
+func (b *twitchBot) handleCommand(nick, text string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "!") {
+		return
+	}
+
+	arg := ""
+	if len(fields) > 1 {
+		arg = fields[1]
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "!poll":
+		b.cmdPoll()
+	case "!vote":
+		b.cmdVote(nick, arg)
+	case "!suggest":
+		b.cmdSuggest(nick, arg)
+	case "!link":
+		b.cmdLink(nick, arg)
+	}
+}
+
+// linkedUser returns the account linked to the Twitch user, telling them
+// how to link one if there isn't any.
+func (b *twitchBot) linkedUser(nick string) *common.User {
+	user, err := b.s.data.GetUserByTwitchName(nick)
+	if err != nil {
+		b.s.l.Error("Unable to get user for Twitch name %q: %v", nick, err)
+		b.reply(nick, "Something went wrong :C")
+		return nil
+	}
+
+	if user == nil {
+		msg := "Link your MoviePolls account first, you'll find the code on your account page"
+		if url := b.s.siteUrl("/user"); url != "" {
+			msg += ": " + url
+		}
+		b.reply(nick, msg)
+	}
+	return user
+}
+
+func (b *twitchBot) cmdPoll() {
+	standings, err := b.s.overlayStandings()
+	if err != nil {
+		b.s.l.Error("Unable to get standings: %v", err)
+		return
+	}
+
+	if len(standings.Movies) == 0 {
+		b.say("There are no movies in the poll yet")
+		return
+	}
+
+	list := []string{}
+	for i, movie := range standings.Movies {
+		if i >= twitchPollLength {
+			break
+		}
+		list = append(list, fmt.Sprintf("#%d %s (%d)", movie.MovieId, movie.Name, movie.Votes))
+	}
+
+	msg := strings.Join(list, " | ")
+	if !standings.VotingEnabled {
+		msg = "Voting is closed. " + msg
+	}
+	b.say(msg)
+}
+
+func (b *twitchBot) cmdVote(nick, arg string) {
+	movieId, err := strconv.Atoi(strings.TrimPrefix(arg, "#"))
+	if err != nil {
+		b.reply(nick, "Usage: !vote <movie id>, see !poll for the IDs")
+		return
+	}
+
+	user := b.linkedUser(nick)
+	if user == nil {
+		return
+	}
+
+	// Chat can only add votes, removing one by accident would be confusing.
+	voted, err := b.s.data.UserVotedForMovie(user.Id, movieId)
+	if err != nil {
+		b.s.l.Error("Cannot get user vote: %v", err)
+		b.reply(nick, "Something went wrong :C")
+		return
+	}
+
+	if voted {
+		b.reply(nick, "You already voted for that movie")
+		return
+	}
+
+	if _, err = b.s.toggleVote(user, movieId); err != nil {
+		if ve, ok := err.(voteError); ok {
+			b.reply(nick, ve.Error())
+		} else {
+			b.s.l.Error("Vote error: %v", err)
+			b.reply(nick, "Something went wrong :C")
+		}
+		return
+	}
+
+	movie, err := b.s.data.GetMovie(movieId)
+	if err != nil {
+		b.s.l.Error("Unable to get movie %d: %v", movieId, err)
+		return
+	}
+	b.reply(nick, fmt.Sprintf("Voted for %s (%d votes)", movie.Name, len(movie.Votes)))
+}
+
+func (b *twitchBot) cmdSuggest(nick, link string) {
+	if link == "" {
+		b.reply(nick, "Usage: !suggest <imdb or myanimelist link>")
+		return
+	}
+
+	user := b.linkedUser(nick)
+	if user == nil {
+		return
+	}
+
+	select {
+	case b.suggestions <- twitchSuggestion{Nick: nick, UserId: user.Id, Link: link}:
+	default:
+		b.reply(nick, "Too many suggestions at once, try again in a bit")
+	}
+}
+
+// processSuggestions adds queued suggestions one at a time so slow autofill
+// lookups don't hold up the chat.
+func (b *twitchBot) processSuggestions() {
+	for {
+		select {
+		case <-b.quit:
+			return
+		case sug := <-b.suggestions:
+			b.reply(sug.Nick, b.suggest(sug))
+		}
+	}
+}
+
+// suggest adds a movie the same way the add movie page does with autofill
+// and returns the reply for chat.
+func (b *twitchBot) suggest(sug twitchSuggestion) string {
+	s := b.s
+
+	cycle, err := s.data.GetCurrentCycle()
+	if err != nil {
+		s.l.Error("Unable to get current cycle: %v", err)
+		return "Something went wrong :C"
+	}
+
+	if cycle == nil {
+		return "No cycle active!"
+	}
+
+	// The account may have been unlinked, deleted, or purged while the
+	// suggestion was waiting.
+	user, err := s.data.GetUser(sug.UserId)
+	if err != nil || user == nil || user.TwitchName != sug.Nick {
+		s.l.Info("Dropping suggestion of %q, user %d is no longer linked", sug.Nick, sug.UserId)
+		return "Your account is no longer linked, the suggestion was dropped"
+	}
+
+	limited, err := s.checkMovieAddLimit(user, cycle, i18n.Default)
+	if err != nil {
+		s.l.Error("Unable to check movie add limit: %v", err)
+		return "Something went wrong :C"
+	}
+
+	if limited != "" {
+		return limited
+	}
+
+	// Chat replies are always in English
+	data := &dataAddMovie{dataPageBase: dataPageBase{Locale: i18n.Default}}
+	results, links := s.autofill(data, sug.Link, "")
+	if results == nil || links == nil {
+		if len(data.ErrorMessage) > 0 {
+			return data.ErrorMessage[0]
+		}
+		return "Could not autofill all fields"
+	}
+
+	movieId, err := s.addAutofilledMovie(user, results, links)
+	if err != nil {
+		s.l.Error("Movie could not be added. Error: %v", err)
+		return "Could not add movie, contact your server administrator"
+	}
+
+	s.movieAdded(user)
+	s.publishMovie(movieId)
+
+	approval, err := s.data.GetCfgBool(ConfigEntriesRequireApproval, DefaultEntriesRequireApproval)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigEntriesRequireApproval, err)
+	}
+
+	if approval {
+		return fmt.Sprintf("%s was suggested and is waiting for approval", results[0])
+	}
+	return fmt.Sprintf("Added %s as #%d", results[0], movieId)
+}
+
+// newLinkCode returns a code that links the Twitch account that types
+// "!link <code>" in chat to the given user.
+func (b *twitchBot) newLinkCode(userId int) string {
+	b.linkLock.Lock()
+	defer b.linkLock.Unlock()
+
+	now := time.Now()
+	for code, link := range b.links {
+		if link.Expires.Before(now) || link.UserId == userId {
+			delete(b.links, code)
+		}
+	}
+
+	code := strings.ToLower(getCryptRandKey(8))
+	b.links[code] = twitchLink{UserId: userId, Expires: now.Add(twitchLinkTimeout)}
+	return code
+}
+
+// dropLinkCodes removes the pending link codes of a user.
+func (b *twitchBot) dropLinkCodes(userId int) {
+	b.linkLock.Lock()
+	defer b.linkLock.Unlock()
+
+	for code, link := range b.links {
+		if link.UserId == userId {
+			delete(b.links, code)
+		}
+	}
+}
+
+func (b *twitchBot) cmdLink(nick, code string) {
+	b.linkLock.Lock()
+	link, ok := b.links[strings.ToLower(code)]
+	if ok {
+		delete(b.links, strings.ToLower(code))
+	}
+	b.linkLock.Unlock()
+
+	if !ok || link.Expires.Before(time.Now()) {
+		b.reply(nick, "Invalid or expired code")
+		return
+	}
+
+	user, err := b.s.data.GetUser(link.UserId)
+	if err != nil {
+		b.s.l.Error("Unable to get user %d: %v", link.UserId, err)
+		b.reply(nick, "Something went wrong :C")
+		return
+	}
+
+	// A Twitch account can only be linked to one user.
+	previous, err := b.s.data.GetUserByTwitchName(nick)
+	if err != nil {
+		b.s.l.Error("Unable to get user for Twitch name %q: %v", nick, err)
+		b.reply(nick, "Something went wrong :C")
+		return
+	}
+
+	if previous != nil && previous.Id != user.Id {
+		previous.TwitchName = ""
+		if err = b.s.data.UpdateUser(previous); err != nil {
+			b.s.l.Error("Unable to update user: %v", err)
+			b.reply(nick, "Something went wrong :C")
+			return
+		}
+	}
+
+	user.TwitchName = nick
+	if err = b.s.data.UpdateUser(user); err != nil {
+		b.s.l.Error("Unable to update user: %v", err)
+		b.reply(nick, "Something went wrong :C")
+		return
+	}
+
+	b.s.l.Info("User %s linked Twitch account %s", user.Name, nick)
+	b.reply(nick, "Linked to "+user.Name)
+}
@@ -0,0 +1,262 @@
+package moviepoll
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// fakeIrc accepts a single connection and passes lines back and forth.
+type fakeIrc struct {
+	listener net.Listener
+	conn     net.Conn
+	lines    chan string
+}
+
+func newFakeIrc(t *testing.T) *fakeIrc {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fakeIrc{listener: l, lines: make(chan string, 20)}
+}
+
+func (f *fakeIrc) accept(t *testing.T) {
+	conn, err := f.listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.conn = conn
+
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				close(f.lines)
+				return
+			}
+			f.lines <- strings.TrimRight(line, "\r\n")
+		}
+	}()
+}
+
+func (f *fakeIrc) close() {
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.listener.Close()
+}
+
+func (f *fakeIrc) send(t *testing.T, line string) {
+	t.Helper()
+	if _, err := fmt.Fprintf(f.conn, "%s\r\n", line); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f *fakeIrc) chat(t *testing.T, nick, message string) {
+	t.Helper()
+	f.send(t, fmt.Sprintf(":%s!%s@%s.tmi.twitch.tv PRIVMSG #movies :%s", nick, nick, nick, message))
+}
+
+// expect waits for a line starting with prefix and containing substr.
+func (f *fakeIrc) expect(t *testing.T, prefix, substr string) string {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-f.lines:
+			if !ok {
+				t.Fatalf("Connection closed while waiting for %q", prefix)
+			}
+
+			if strings.HasPrefix(line, prefix) {
+				if !strings.Contains(line, substr) {
+					t.Fatalf("Expected %q in %q", substr, line)
+				}
+				return line
+			}
+
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %q", prefix)
+		}
+	}
+}
+
+func Test_ParseIrcLine(t *testing.T) {
+	msg := parseIrcLine("@badge-info=;color=#FF0000 :viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #movies :!vote 3\r\n")
+	if msg.Command != "PRIVMSG" || msg.Nick() != "viewer" {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+
+	if len(msg.Params) != 2 || msg.Params[0] != "#movies" || msg.Params[1] != "!vote 3" {
+		t.Errorf("Unexpected params: %q", msg.Params)
+	}
+
+	msg = parseIrcLine("PING :tmi.twitch.tv")
+	if msg.Command != "PING" || len(msg.Params) != 1 || msg.Params[0] != "tmi.twitch.tv" {
+		t.Errorf("Unexpected ping: %+v", msg)
+	}
+}
+
+func Test_TruncateMessage(t *testing.T) {
+	for _, tc := range []struct {
+		message  string
+		max      int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long", 3, "too"},
+		{"Amélie", 3, "Am"},
+		{"Amélie", 4, "Amé"},
+		{"千と千尋", 5, "千"},
+	} {
+		got := truncateMessage(tc.message, tc.max)
+		if got != tc.expected || !utf8.ValidString(got) {
+			t.Errorf("truncateMessage(%q, %d) = %q, expected %q", tc.message, tc.max, got, tc.expected)
+		}
+	}
+}
+
+func Test_TwitchBot(t *testing.T) {
+	defer func(d time.Duration) { twitchMessageInterval = d }(twitchMessageInterval)
+	twitchMessageInterval = 0
+
+	s, user, movieId := setupVoteTest(t)
+	s.data.SetCfgInt(ConfigMaxUserVotes, 1)
+	other, err := s.data.AddMovie(&common.Movie{Name: "Second Movie", AddedBy: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	irc := newFakeIrc(t)
+	defer irc.close()
+
+	bot := newTwitchBot(s, twitchConfig{Server: irc.listener.Addr().String(), Nick: "MovieBot", Token: "abc", Channel: "Movies"})
+	go bot.run()
+	defer bot.stop()
+
+	irc.accept(t)
+	irc.expect(t, "PASS", "oauth:abc")
+	irc.expect(t, "NICK", "moviebot")
+	irc.expect(t, "JOIN", "#movies")
+
+	irc.send(t, "PING :tmi.twitch.tv")
+	irc.expect(t, "PONG", ":tmi.twitch.tv")
+
+	// Not linked yet
+	irc.chat(t, "viewer", fmt.Sprintf("!vote %d", movieId))
+	irc.expect(t, "PRIVMSG #movies", "Link your MoviePolls account")
+
+	irc.chat(t, "viewer", "!link "+bot.newLinkCode(user.Id))
+	irc.expect(t, "PRIVMSG #movies", "Linked to voter")
+
+	linked, err := s.data.GetUserByTwitchName("viewer")
+	if err != nil || linked == nil || linked.Id != user.Id {
+		t.Fatalf("Twitch account was not linked")
+	}
+
+	irc.chat(t, "viewer", fmt.Sprintf("!vote #%d", movieId))
+	irc.expect(t, "PRIVMSG #movies", "Voted for CSRF Test (1 votes)")
+
+	irc.chat(t, "viewer", fmt.Sprintf("!vote %d", movieId))
+	irc.expect(t, "PRIVMSG #movies", "already voted")
+
+	// Same limits as the website
+	irc.chat(t, "viewer", fmt.Sprintf("!vote %d", other))
+	irc.expect(t, "PRIVMSG #movies", "You don't have any more available votes!")
+
+	irc.chat(t, "someone", "!poll")
+	irc.expect(t, "PRIVMSG #movies", fmt.Sprintf("#%d CSRF Test (1)", movieId))
+
+	// Autofill is disabled, but the suggestion still goes through the queue.
+	irc.chat(t, "viewer", "!suggest https://www.imdb.com/title/tt0111161/")
+	irc.expect(t, "PRIVMSG #movies", "Tmdb API usage was not enabled")
+
+	irc.chat(t, "viewer", "!link bogus")
+	irc.expect(t, "PRIVMSG #movies", "Invalid or expired code")
+}
+
+func Test_TwitchDeletedUser(t *testing.T) {
+	s := newTestServer(t)
+	s.twitch = newTwitchBot(s, twitchConfig{Nick: "MovieBot", Channel: "Movies"})
+
+	user := addTestUser(t, s, "viewer", common.PRIV_USER)
+	user.TwitchName = "viewer"
+	if err := s.data.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	code := s.twitch.newLinkCode(user.Id)
+
+	deleteTestUser(t, s, user)
+
+	if linked, err := s.data.GetUserByTwitchName("viewer"); err != nil || linked != nil {
+		t.Errorf("Twitch name still resolves to the deleted user: %v", err)
+	}
+
+	if _, ok := s.twitch.links[code]; ok {
+		t.Errorf("Link code of the deleted user was kept")
+	}
+}
+
+func Test_TwitchSuggestionDeletedUser(t *testing.T) {
+	s, _, _ := setupVoteTest(t)
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+	bot := newTwitchBot(s, twitchConfig{Nick: "MovieBot", Channel: "Movies"})
+	s.twitch = bot
+
+	queue := func(name string) (*common.User, twitchSuggestion) {
+		user := addTestUser(t, s, name, common.PRIV_USER)
+		user.TwitchName = name
+		if err := s.data.UpdateUser(user); err != nil {
+			t.Fatal(err)
+		}
+
+		bot.cmdSuggest(name, "https://www.imdb.com/title/tt0111161/")
+		return user, <-bot.suggestions
+	}
+
+	// Deleted while the suggestion is queued
+	user, sug := queue("viewer")
+	deleteTestUser(t, s, user)
+
+	if reply := bot.suggest(sug); !strings.Contains(reply, "no longer linked") {
+		t.Errorf("Suggestion of a deleted user wasn't dropped: %q", reply)
+	}
+
+	user, err := s.data.GetUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "[deleted]" || user.TwitchName != "" {
+		t.Errorf("Deleted user was restored: %v", user)
+	}
+
+	// Purged while the suggestion is queued
+	user, sug = queue("lurker")
+	cookies := loginCookies(t, s, admin)
+	form := url.Values{"confirm": {"yes"}, "CsrfToken": {csrfTokenFor(t, s, cookies)}}
+	if code := postForm(s, fmt.Sprintf("/admin/user/%d?action=purge", user.Id), form, cookies).Code; code != http.StatusOK {
+		t.Fatalf("Unable to purge user: %d", code)
+	}
+
+	if reply := bot.suggest(sug); !strings.Contains(reply, "no longer linked") {
+		t.Errorf("Suggestion of a purged user wasn't dropped: %q", reply)
+	}
+
+	if user, err = s.data.GetUser(user.Id); err == nil && user != nil {
+		t.Errorf("Purged user was recreated")
+	}
+}
@@ -0,0 +1,355 @@
+package moviepoll
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// How long the second step of a login may take after the password has been
+// accepted.
+const twoFactorTimeout time.Duration = 5 * time.Minute
+
+// Number of recovery codes generated when enrolling.
+const recoveryCodeCount int = 10
+
+type dataTwoFactor struct {
+	dataPageBase
+	ErrorMessage string
+}
+
+// Two-factor section of the account page.
+type dataTotpSetup struct {
+	Enabled      bool
+	RecoveryLeft int
+
+	// Two-factor is mandatory for this account
+	Required bool
+
+	// Set while enrolling
+	Qr     template.URL
+	Secret string
+
+	// Only shown once, right after they are generated
+	RecoveryCodes []string
+
+	Errors  []string
+	Success string
+}
+
+// startLogin logs the user in, unless they have two-factor authentication
+// enabled.  In that case the session only remembers the user until a code is
+// entered at /user/login/2fa.  Returns the URL to redirect to.
+func (s *Server) startLogin(user *common.User, w http.ResponseWriter, r *http.Request) (string, error) {
+	if !user.TotpEnabled() {
+		return "/", s.login(user, w, r)
+	}
+
+	session, err := s.cookies.Get(r, SessionName)
+	if err != nil {
+		return "", fmt.Errorf("Unable to get session from store: %v", err)
+	}
+
+	delete(session.Values, "UserId")
+	delete(session.Values, "PassDate")
+	delete(session.Values, "TwoFactor")
+	session.Values["PendingUserId"] = user.Id
+	session.Values["PendingTime"] = time.Now().Unix()
+
+	return "/user/login/2fa", session.Save(r, w)
+}
+
+// getPendingUser returns the user that has entered their password but not
+// their second factor yet.
+func (s *Server) getPendingUser(r *http.Request) *common.User {
+	session, err := s.cookies.Get(r, SessionName)
+	if err != nil {
+		return nil
+	}
+
+	id, ok := session.Values["PendingUserId"].(int)
+	if !ok {
+		return nil
+	}
+
+	started, _ := session.Values["PendingTime"].(int64)
+	if time.Since(time.Unix(started, 0)) > twoFactorTimeout {
+		return nil
+	}
+
+	user, err := s.data.GetUser(id)
+	if err != nil {
+		s.l.Error("Unable to get pending user %d: %v", id, err)
+		return nil
+	}
+	return user
+}
+
+// markTwoFactor records in the session that the user has completed the
+// second factor.  The session still has to be saved.
+func (s *Server) markTwoFactor(user *common.User, r *http.Request) error {
+	session, err := s.cookies.Get(r, SessionName)
+	if err != nil {
+		return fmt.Errorf("Unable to get session from store: %v", err)
+	}
+
+	delete(session.Values, "PendingUserId")
+	delete(session.Values, "PendingTime")
+	session.Values["TwoFactor"] = user.Id
+	return nil
+}
+
+// hasTwoFactor returns true if the session completed the second factor for
+// the given user.
+func (s *Server) hasTwoFactor(user *common.User, r *http.Request) bool {
+	session, err := s.cookies.Get(r, SessionName)
+	if err != nil {
+		return false
+	}
+
+	id, ok := session.Values["TwoFactor"].(int)
+	return ok && id == user.Id
+}
+
+// twoFactorRequired returns true if the user has to use two-factor
+// authentication to access the admin pages.
+func (s *Server) twoFactorRequired(user *common.User) bool {
+	if user.Privilege < common.PRIV_MOD {
+		return false
+	}
+
+	required, err := s.data.GetCfgBool(ConfigRequireTwoFactor, DefaultRequireTwoFactor)
+	if err != nil {
+		s.l.Error("Unable to get %s: %v", ConfigRequireTwoFactor, err)
+		// Fail closed
+		return true
+	}
+	return required
+}
+
+// checkTwoFactorCode accepts either a TOTP code or an unused recovery code.
+// Used codes are recorded on the user so they can't be used again.
+func (s *Server) checkTwoFactorCode(user *common.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" || !user.TotpEnabled() {
+		return false, nil
+	}
+
+	if step, ok := common.ValidateTotp(user.TotpSecret, code, time.Now(), user.TotpLastStep); ok {
+		user.TotpLastStep = step
+		if err := s.data.UpdateUser(user); err != nil {
+			return false, fmt.Errorf("Unable to update user: %v", err)
+		}
+		return true, nil
+	}
+
+	hashed := s.hashPassword(strings.ToLower(code))
+	for i, rc := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(rc), []byte(hashed)) != 1 {
+			continue
+		}
+
+		user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+		if err := s.data.UpdateUser(user); err != nil {
+			return false, fmt.Errorf("Unable to update user: %v", err)
+		}
+
+		s.l.Info("User %s used a recovery code, %d left", user.Name, len(user.RecoveryCodes))
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// newRecoveryCodes replaces the user's recovery codes and returns the new
+// codes in plain text.  The user still has to be saved.
+func (s *Server) newRecoveryCodes(user *common.User) []string {
+	codes := []string{}
+	user.RecoveryCodes = []string{}
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := strings.ToLower(getCryptRandKey(10))
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		user.RecoveryCodes = append(user.RecoveryCodes, s.hashPassword(code))
+	}
+
+	return codes
+}
+
+// totpQr returns a data URL of a QR code provisioning the given secret.  The
+// issuer is shown next to the account in authenticator apps.
+func totpQr(issuer string, user *common.User, secret string) (template.URL, error) {
+	png, err := qrcode.Encode(common.TotpUrl(issuer, user.Name, secret), qrcode.Medium, 256)
+	if err != nil {
+		return "", fmt.Errorf("Unable to generate QR code: %v", err)
+	}
+
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil
+}
+
+// handlerTwoFactorLogin is the second step of a login for users with
+// two-factor authentication enabled.
+func (s *Server) handlerTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	if user := s.getSessionUser(w, r); user != nil {
+		http.Redirect(w, r, "/user", http.StatusFound)
+		return
+	}
+
+	user := s.getPendingUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/user/login", http.StatusFound)
+		return
+	}
+
+	data := dataTwoFactor{}
+	locale := s.requestLocale(user, r)
+
+	if r.Method == "POST" {
+		addr := s.clientAddr(r)
+		maxFailures, lockout := s.getLoginLimits()
+
+		if wait := s.loginLimits.check(user.Name, addr, lockout); wait > 0 {
+			data.ErrorMessage = locale.T("Too many failed login attempts. Try again in %s.", formatWait(locale, wait))
+		} else {
+			ok, err := s.checkTwoFactorCode(user, r.PostFormValue("Code"))
+			if err != nil {
+				s.l.Error("Unable to check two-factor code: %v", err)
+				s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+				return
+			}
+
+			if ok {
+				s.loginLimits.success(user.Name)
+				if err = s.markTwoFactor(user, r); err == nil {
+					err = s.login(user, w, r)
+				}
+
+				if err != nil {
+					s.l.Error("Unable to login: %v", err)
+					s.doError(http.StatusInternalServerError, "Unable to login", w, r)
+					return
+				}
+
+				http.Redirect(w, r, "/", http.StatusFound)
+				return
+			}
+
+			data.ErrorMessage = locale.T("Invalid code")
+			if s.loginLimits.fail(user.Name, addr, maxFailures, lockout) {
+				s.l.Info("Two-factor login for %q from %s locked out after repeated failures", user.Name, addr)
+			}
+		}
+	}
+
+	data.dataPageBase = s.newPageBase("Login", w, r)
+	if err := s.executeTemplate(w, "twofactor", data); err != nil {
+		s.l.Error("Error rendering template: %v", err)
+	}
+}
+
+// handleTotpForm handles the two-factor forms on the account page.
+func (s *Server) handleTotpForm(user *common.User, form string, w http.ResponseWriter, r *http.Request) (dataTotpSetup, error) {
+	data := dataTotpSetup{Required: s.twoFactorRequired(user)}
+
+	session, err := s.cookies.Get(r, SessionName)
+	if err != nil {
+		return data, fmt.Errorf("Unable to get session from store: %v", err)
+	}
+
+	switch form {
+	case "TotpStart":
+		if user.TotpEnabled() {
+			data.Errors = append(data.Errors, "Two-factor authentication is already enabled")
+			return data, nil
+		}
+
+		data.Secret, err = common.NewTotpSecret()
+		if err != nil {
+			return data, err
+		}
+
+		session.Values["TotpPending"] = data.Secret
+		if err = session.Save(r, w); err != nil {
+			return data, fmt.Errorf("Unable to save session: %v", err)
+		}
+
+		data.Qr, err = totpQr(s.siteName(), user, data.Secret)
+		return data, err
+
+	case "TotpConfirm":
+		secret, _ := session.Values["TotpPending"].(string)
+		if secret == "" || user.TotpEnabled() {
+			data.Errors = append(data.Errors, "Start the setup again")
+			return data, nil
+		}
+
+		step, ok := common.ValidateTotp(secret, r.PostFormValue("Code"), time.Now(), 0)
+		if !ok {
+			data.Errors = append(data.Errors, "Invalid code, try again")
+			data.Secret = secret
+			data.Qr, err = totpQr(s.siteName(), user, secret)
+			return data, err
+		}
+
+		user.TotpSecret = secret
+		user.TotpLastStep = step
+		data.RecoveryCodes = s.newRecoveryCodes(user)
+		if err = s.data.UpdateUser(user); err != nil {
+			return data, fmt.Errorf("Unable to update user: %v", err)
+		}
+
+		// Entering the code is as good as completing the second factor.
+		delete(session.Values, "TotpPending")
+		if err = s.markTwoFactor(user, r); err != nil {
+			return data, err
+		}
+
+		if err = session.Save(r, w); err != nil {
+			return data, fmt.Errorf("Unable to save session: %v", err)
+		}
+
+		s.l.Info("User %s enabled two-factor authentication", user.Name)
+		data.Success = "Two-factor authentication enabled"
+
+	case "TotpRecovery", "TotpDisable":
+		if form == "TotpDisable" && data.Required {
+			data.Errors = append(data.Errors, "Two-factor authentication is required for your account")
+			return data, nil
+		}
+
+		ok, err := s.checkTwoFactorCode(user, r.PostFormValue("Code"))
+		if err != nil {
+			return data, err
+		}
+
+		if !ok {
+			data.Errors = append(data.Errors, "Invalid code")
+			return data, nil
+		}
+
+		if form == "TotpRecovery" {
+			data.RecoveryCodes = s.newRecoveryCodes(user)
+			data.Success = "New recovery codes generated"
+		} else {
+			user.TotpSecret = ""
+			user.TotpLastStep = 0
+			user.RecoveryCodes = nil
+			data.Success = "Two-factor authentication disabled"
+			s.l.Info("User %s disabled two-factor authentication", user.Name)
+		}
+
+		if err = s.data.UpdateUser(user); err != nil {
+			return data, fmt.Errorf("Unable to update user: %v", err)
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,202 @@
+package moviepoll
+
+import (
+	"encoding/base32"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+// Test vectors from RFC 6238, truncated to six digits.
+func Test_TotpCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, expected := range vectors {
+		code, err := common.TotpCode(secret, common.TotpStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != expected {
+			t.Errorf("Wrong code at %d: expected %s, got %s", unix, expected, code)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	step, ok := common.ValidateTotp(secret, "005924", now, 0)
+	if !ok {
+		t.Fatalf("Valid code was rejected")
+	}
+
+	if _, ok = common.ValidateTotp(secret, "005924", now, step); ok {
+		t.Errorf("Code was accepted twice")
+	}
+}
+
+// postHandler sends a form directly to a handler and returns the response.
+func postHandler(handler http.HandlerFunc, path string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	addCookies(req, cookies)
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func addTotpUser(t *testing.T, s *Server, name string, priv common.PrivilegeLevel) (*common.User, []string) {
+	t.Helper()
+
+	user := addTestUser(t, s, name, priv)
+	secret, err := common.NewTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.TotpSecret = secret
+	codes := s.newRecoveryCodes(user)
+	if err = s.data.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user, codes
+}
+
+func currentTotp(t *testing.T, user *common.User) string {
+	t.Helper()
+
+	code, err := common.TotpCode(user.TotpSecret, common.TotpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func Test_TwoFactorLogin(t *testing.T) {
+	s := newTestServer(t)
+	user, recovery := addTotpUser(t, s, "twofactor", common.PRIV_USER)
+
+	login := func() []*http.Cookie {
+		rec := postHandler(s.handlerUserLogin, "/user/login", url.Values{"Username": {"twofactor"}, "Password": {"password"}}, nil)
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/user/login/2fa" {
+			t.Fatalf("Expected redirect to second factor, got %d %q", rec.Code, rec.Header().Get("Location"))
+		}
+		return rec.Result().Cookies()
+	}
+
+	cookies := login()
+	req := addCookies(httptest.NewRequest("GET", "/", nil), cookies)
+	if u := s.getSessionUser(httptest.NewRecorder(), req); u != nil {
+		t.Fatalf("Password alone logged the user in")
+	}
+
+	rec := postHandler(s.handlerTwoFactorLogin, "/user/login/2fa", url.Values{"Code": {"000000"}}, cookies)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Invalid code") {
+		t.Fatalf("Expected invalid code message, got %d", rec.Code)
+	}
+
+	code := currentTotp(t, user)
+	rec = postHandler(s.handlerTwoFactorLogin, "/user/login/2fa", url.Values{"Code": {code}}, cookies)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Fatalf("Expected redirect after second factor, got %d", rec.Code)
+	}
+
+	req = addCookies(httptest.NewRequest("GET", "/", nil), append(cookies, rec.Result().Cookies()...))
+	if u := s.getSessionUser(httptest.NewRecorder(), req); u == nil || u.Id != user.Id {
+		t.Fatalf("User was not logged in after second factor")
+	}
+
+	// The same code can't be used again.
+	cookies = login()
+	rec = postHandler(s.handlerTwoFactorLogin, "/user/login/2fa", url.Values{"Code": {code}}, cookies)
+	if rec.Code == http.StatusFound {
+		t.Errorf("TOTP code was accepted twice")
+	}
+
+	// Recovery codes work exactly once.
+	rec = postHandler(s.handlerTwoFactorLogin, "/user/login/2fa", url.Values{"Code": {recovery[0]}}, cookies)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Recovery code was rejected, got %d", rec.Code)
+	}
+
+	cookies = login()
+	rec = postHandler(s.handlerTwoFactorLogin, "/user/login/2fa", url.Values{"Code": {recovery[0]}}, cookies)
+	if rec.Code == http.StatusFound {
+		t.Errorf("Recovery code was accepted twice")
+	}
+}
+
+func Test_TwoFactorRequired(t *testing.T) {
+	s := newTestServer(t)
+	s.data.SetCfgBool(ConfigRequireTwoFactor, true)
+
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+	get := func(cookies []*http.Cookie) int {
+		rec := httptest.NewRecorder()
+		s.handlerAdmin(rec, addCookies(httptest.NewRequest("GET", "/admin/", nil), cookies))
+		return rec.Code
+	}
+
+	cookies := loginCookies(t, s, admin)
+	if code := get(cookies); code != http.StatusForbidden {
+		t.Fatalf("Expected admin without two-factor to be refused, got %d", code)
+	}
+
+	// Enroll through the account page
+	rec := postHandler(s.handlerUser, "/user", url.Values{"Form": {"TotpStart"}}, cookies)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "data:image/png;base64,") {
+		t.Fatalf("Expected QR code on account page, got %d", rec.Code)
+	}
+	cookies = append(cookies, rec.Result().Cookies()...)
+
+	session, err := s.cookies.Get(addCookies(httptest.NewRequest("GET", "/", nil), cookies), SessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := session.Values["TotpPending"].(string)
+
+	code, err := common.TotpCode(secret, common.TotpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = postHandler(s.handlerUser, "/user", url.Values{"Form": {"TotpConfirm"}, "Code": {code}}, cookies)
+	if !strings.Contains(rec.Body.String(), "Two-factor authentication enabled") {
+		t.Fatalf("Enrollment failed")
+	}
+	cookies = append(cookies, rec.Result().Cookies()...)
+
+	if code := get(cookies); code != http.StatusOK {
+		t.Errorf("Expected access after enrollment, got %d", code)
+	}
+
+	// A session that only used the password is still refused.
+	if code := get(loginCookies(t, s, admin)); code != http.StatusForbidden {
+		t.Errorf("Expected password only session to be refused, got %d", code)
+	}
+}
+
+func Test_DeleteUserTwoFactor(t *testing.T) {
+	s := newTestServer(t)
+	user, _ := addTotpUser(t, s, "twofactor", common.PRIV_USER)
+	user.TotpLastStep = common.TotpStep(time.Now())
+	if err := s.data.UpdateUser(user); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := deleteTestUser(t, s, user)
+	if deleted.TotpSecret != "" || deleted.TotpLastStep != 0 || len(deleted.RecoveryCodes) != 0 {
+		t.Errorf("Two-factor secrets were kept on the deleted account")
+	}
+}
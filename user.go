@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/zorchenhimer/MoviePolls/common"
+	"github.com/zorchenhimer/MoviePolls/i18n"
 )
 
 // Returns current active votes and votes for watched movies
@@ -72,6 +73,7 @@ func (s *Server) handlerUser(w http.ResponseWriter, r *http.Request) {
 		ActiveVotes    []*common.Movie
 		WatchedVotes   []*common.Movie
 		AddedMovies    []*common.Movie
+		Reviews        []reviewView
 		SuccessMessage string
 
 		PassError   []string
@@ -81,6 +83,27 @@ func (s *Server) handlerUser(w http.ResponseWriter, r *http.Request) {
 		ErrCurrentPass bool
 		ErrNewPass     bool
 		ErrEmail       bool
+
+		OidcName string
+		Totp     dataTotpSetup
+
+		Sessions       []*common.Session
+		CurrentSession string
+
+		// Chat bot account linking
+		TwitchEnabled  bool
+		TwitchChannel  string
+		TwitchLinkCode string
+
+		Locales []*i18n.Locale
+
+		// Name of the server's time zone
+		ServerTimeZone string
+
+		// Absolute URLs of the calendar feeds.  Private is empty if the
+		// user doesn't have a private feed.
+		PublicCalendar  string
+		PrivateCalendar string
 	}{
 		dataPageBase: s.newPageBase("Account", w, r),
 
@@ -91,6 +114,13 @@ func (s *Server) handlerUser(w http.ResponseWriter, r *http.Request) {
 		ActiveVotes:  activeVotes,
 		WatchedVotes: watchedVotes,
 		AddedMovies:  addedMovies,
+
+		OidcName: s.oidcName(),
+		Totp:     dataTotpSetup{Required: s.twoFactorRequired(user)},
+
+		Locales: i18n.Locales(),
+
+		ServerTimeZone: s.serverLocation().String(),
 	}
 
 	if r.Method == "POST" {
@@ -110,27 +140,32 @@ func (s *Server) handlerUser(w http.ResponseWriter, r *http.Request) {
 
 			if currentPass != user.Password {
 				data.ErrCurrentPass = true
-				data.PassError = append(data.PassError, "Invalid current password")
+				data.PassError = append(data.PassError, data.T("Invalid current password"))
 			}
 
 			if newPass1_raw == "" {
 				data.ErrNewPass = true
-				data.PassError = append(data.PassError, "New password cannot be blank")
+				data.PassError = append(data.PassError, data.T("New password cannot be blank"))
 			}
 
 			if newPass1_raw != newPass2_raw {
 				data.ErrNewPass = true
-				data.PassError = append(data.PassError, "Passwords do not match")
+				data.PassError = append(data.PassError, data.T("Passwords do not match"))
 			}
 
 			if !(data.ErrCurrentPass || data.ErrNewPass || data.ErrEmail) {
 				// Change pass
-				data.SuccessMessage = "Password successfully changed"
+				data.SuccessMessage = data.T("Password successfully changed")
 				user.Password = s.hashPassword(newPass1_raw)
 				user.PassDate = time.Now()
 
 				s.l.Info("new PassDate: %s", user.PassDate)
 
+				// Sessions on other devices are invalid now anyway.
+				if err = s.data.DeleteUserSessions(user.Id); err != nil {
+					s.l.Error("Unable to delete sessions: %v", err)
+				}
+
 				err = s.login(user, w, r)
 				if err != nil {
 					s.l.Error("Unable to login to session:", err)
@@ -147,9 +182,110 @@ func (s *Server) handlerUser(w http.ResponseWriter, r *http.Request) {
 
 		} else if formVal == "Notifications" {
 			// Update notifications
+		} else if formVal == "RevokeSession" || formVal == "RevokeOtherSessions" {
+			if err = s.revokeSessions(user, formVal == "RevokeOtherSessions", r.PostFormValue("SessionId"), r); err != nil {
+				s.l.Error("Unable to revoke sessions: %v", err)
+				s.doError(http.StatusInternalServerError, "Unable to revoke sessions", w, r)
+				return
+			}
+			data.SuccessMessage = data.T("Sessions revoked")
+
+		} else if formVal == "TwitchLink" && s.twitch != nil {
+			data.TwitchLinkCode = s.twitch.newLinkCode(user.Id)
+
+		} else if formVal == "TwitchUnlink" {
+			user.TwitchName = ""
+			if err = s.data.UpdateUser(user); err != nil {
+				s.l.Error("Unable to update user: %v", err)
+				s.doError(http.StatusInternalServerError, "Unable to unlink Twitch account", w, r)
+				return
+			}
+			data.SuccessMessage = data.T("Twitch account unlinked")
+
+		} else if formVal == "Language" {
+			tag := r.PostFormValue("Locale")
+			if tag != "" && i18n.Get(tag) == nil {
+				s.doError(http.StatusBadRequest, "Unknown language", w, r)
+				return
+			}
+
+			user.Locale = tag
+			if err = s.data.UpdateUser(user); err != nil {
+				s.l.Error("Unable to update user: %v", err)
+				s.doError(http.StatusInternalServerError, "Unable to change the language", w, r)
+				return
+			}
+
+			// Render the rest of the page in the new language
+			data.dataPageBase = s.newPageBase("Account", w, r)
+			data.SuccessMessage = data.T("Language changed")
+
+		} else if formVal == "TimeZone" {
+			zone := strings.TrimSpace(r.PostFormValue("TimeZone"))
+			if zone != "" {
+				if _, err = loadTimeZone(zone); err != nil {
+					s.doError(http.StatusBadRequest, "Unknown time zone", w, r)
+					return
+				}
+			}
+
+			user.TimeZone = zone
+			if err = s.data.UpdateUser(user); err != nil {
+				s.l.Error("Unable to update user: %v", err)
+				s.doError(http.StatusInternalServerError, "Unable to change the time zone", w, r)
+				return
+			}
+
+			data.dataPageBase = s.newPageBase("Account", w, r)
+			data.SuccessMessage = data.T("Time zone changed")
+
+		} else if formVal == "CalendarNew" || formVal == "CalendarRemove" {
+			// A new token makes the old URL stop working
+			user.CalendarToken = ""
+			if formVal == "CalendarNew" {
+				user.CalendarToken = strings.ToLower(getCryptRandKey(calendarTokenLength))
+			}
+
+			if err = s.data.UpdateUser(user); err != nil {
+				s.l.Error("Unable to update user: %v", err)
+				s.doError(http.StatusInternalServerError, "Unable to change the calendar link", w, r)
+				return
+			}
+
+		} else if strings.HasPrefix(formVal, "Totp") {
+			data.Totp, err = s.handleTotpForm(user, formVal, w, r)
+			if err != nil {
+				s.l.Error("Two-factor setup error: %v", err)
+				s.doError(http.StatusInternalServerError, "Something went wrong :C", w, r)
+				return
+			}
 		}
 	}
 
+	if s.twitch != nil {
+		data.TwitchEnabled = true
+		data.TwitchChannel = s.twitch.cfg.Channel
+	}
+
+	data.Reviews, err = s.userReviews(user)
+	if err != nil {
+		s.l.Error("%v", err)
+	}
+
+	data.PublicCalendar = s.calendarUrl(r, "/calendar.ics")
+	if path := privateCalendarPath(user); path != "" {
+		data.PrivateCalendar = s.calendarUrl(r, path)
+	}
+
+	data.Totp.Enabled = user.TotpEnabled()
+	data.Totp.RecoveryLeft = len(user.RecoveryCodes)
+
+	data.CurrentSession = s.currentSessionId(r)
+	data.Sessions, err = s.getUserSessions(user.Id)
+	if err != nil {
+		s.l.Error("%v", err)
+	}
+
 	if err := s.executeTemplate(w, "account", data); err != nil {
 		s.l.Error("Error rendering template: %v", err)
 	}
@@ -166,19 +302,33 @@ func (s *Server) handlerUserLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := dataLoginForm{}
-	doRedirect := false
+	data := dataLoginForm{
+		OidcName: s.oidcName(),
+	}
+	redirect := ""
+	locale := s.requestLocale(nil, r)
 
 	if r.Method == "POST" {
 		// do login
 
 		un := r.PostFormValue("Username")
 		pw := r.PostFormValue("Password")
-		user, err = s.data.UserLogin(un, s.hashPassword(pw))
-		if err != nil {
-			data.ErrorMessage = err.Error()
+		addr := s.clientAddr(r)
+		maxFailures, lockout := s.getLoginLimits()
+
+		if wait := s.loginLimits.check(un, addr, lockout); wait > 0 {
+			s.l.Info("Login for %q from %s rejected, wait %s", un, addr, wait)
+			data.ErrorMessage = locale.T("Too many failed login attempts. Try again in %s.", formatWait(locale, wait))
 		} else {
-			doRedirect = true
+			user, err = s.data.UserLogin(un, s.hashPassword(pw))
+			if err != nil {
+				data.ErrorMessage = locale.T(err.Error())
+				if s.loginLimits.fail(un, addr, maxFailures, lockout) {
+					s.l.Info("Login for %q from %s locked out after repeated failures", un, addr)
+				}
+			} else {
+				s.loginLimits.success(un)
+			}
 		}
 
 	} else {
@@ -186,7 +336,7 @@ func (s *Server) handlerUserLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if user != nil {
-		err = s.login(user, w, r)
+		redirect, err = s.startLogin(user, w, r)
 		if err != nil {
 			s.l.Error("Unable to login: %v", err)
 			s.doError(http.StatusInternalServerError, "Unable to login", w, r)
@@ -194,9 +344,9 @@ func (s *Server) handlerUserLogin(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Redirect to base page on successful login
-	if doRedirect {
-		http.Redirect(w, r, "/", http.StatusFound)
+	// Redirect to base page on successful login, or to the second factor
+	if redirect != "" {
+		http.Redirect(w, r, redirect, http.StatusFound)
 		return
 	}
 
@@ -208,6 +358,11 @@ func (s *Server) handlerUserLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handlerUserLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.doError(http.StatusMethodNotAllowed, "Logout must be POSTed", w, r)
+		return
+	}
+
 	err := s.logout(w, r)
 	if err != nil {
 		s.l.Error("Error logging out: %v", err)
@@ -258,7 +413,7 @@ func (s *Server) handlerUserNew(w http.ResponseWriter, r *http.Request) {
 		data.ValName = un
 
 		if un == "" {
-			data.ErrorMessage = append(data.ErrorMessage, "Username cannot be blank!")
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Username cannot be blank!"))
 			data.ErrName = true
 		}
 
@@ -279,21 +434,21 @@ func (s *Server) handlerUserNew(w http.ResponseWriter, r *http.Request) {
 		s.l.Debug("New user: %s (%d) maxlen: %d", un, len(un), maxlen)
 
 		if len(un) > maxlen {
-			data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Username cannot be longer than %d characters", maxlen))
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Username cannot be longer than %d characters", maxlen))
 			data.ErrName = true
 		}
 
 		if len(un) < minlen {
-			data.ErrorMessage = append(data.ErrorMessage, fmt.Sprintf("Username cannot be shorter than %d characters", minlen))
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Username cannot be shorter than %d characters", minlen))
 			data.ErrName = true
 		}
 
 		if pw1 != pw2 {
-			data.ErrorMessage = append(data.ErrorMessage, "Passwords do not match!")
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Passwords do not match!"))
 			data.ErrPass = true
 
 		} else if pw1 == "" {
-			data.ErrorMessage = append(data.ErrorMessage, "Password cannot be blank!")
+			data.ErrorMessage = append(data.ErrorMessage, data.T("Password cannot be blank!"))
 			data.ErrPass = true
 		}
 
@@ -3,8 +3,16 @@ package moviepoll
 import (
 	"crypto/rand"
 	"crypto/sha512"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/i18n"
 )
 
 func getCryptRandKey(size int) string {
@@ -41,3 +49,48 @@ func generatePass() (string, error) {
 	}
 	return out, nil
 }
+
+// formatWait formats a duration for display, eg "2d 4h", "3h 12m", or "5m".
+func formatWait(locale *i18n.Locale, d time.Duration) string {
+	if d < time.Minute {
+		return locale.T("less than a minute")
+	}
+
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+
+	if days > 0 {
+		return locale.T("%dd %dh", days, hours)
+	}
+
+	if hours > 0 {
+		return locale.T("%dh %dm", hours, minutes)
+	}
+
+	return locale.T("%dm", minutes)
+}
+
+// wantsJson returns true if the request prefers a JSON response over HTML.
+func wantsJson(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func writeJson(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(data)
+}
+
+// readUpload reads at most max bytes from r.  Larger files are refused.
+func readUpload(r io.Reader, max int64) ([]byte, error) {
+	raw, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(raw)) > max {
+		return nil, fmt.Errorf("File is too large.  Max size is %d MB.", max>>20)
+	}
+	return raw, nil
+}
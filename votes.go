@@ -3,16 +3,29 @@ package moviepoll
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/zorchenhimer/MoviePolls/common"
 )
 
-// Toggles votes
-func (s *Server) handlerVote(w http.ResponseWriter, r *http.Request) {
-	user := s.getSessionUser(w, r)
-	if user == nil {
-		http.Redirect(w, r, "/login", http.StatusFound)
-		return
-	}
+// voteError is a problem with a vote that can be shown to the user.
+type voteError string
+
+func (e voteError) Error() string {
+	return string(e)
+}
+
+// Response to a vote requested as JSON
+type voteResponse struct {
+	MovieId int
+	Votes   int
+	Voted   bool
+	Error   string `json:",omitempty"`
+}
 
+// toggleVote adds the user's vote to a movie, or removes it if they already
+// voted for it.  Returns whether the user has a vote on the movie afterwards.
+// Errors of type voteError can be shown to the user.
+func (s *Server) toggleVote(user *common.User, movieId int) (bool, error) {
 	enabled, err := s.data.GetCfgBool("VotingEnabled", DefaultVotingEnabled)
 	if err != nil {
 		s.l.Error("Unable to get config value for VotingEnabled: %s", err)
@@ -20,100 +33,126 @@ func (s *Server) handlerVote(w http.ResponseWriter, r *http.Request) {
 
 	// this should be false if an error was returned
 	if !enabled {
-		s.doError(
-			http.StatusBadRequest,
-			"Voting is not enabled",
-			w, r)
-		return
-	}
-
-	var movieId int
-	if _, err := fmt.Sscanf(r.URL.Path, "/vote/%d", &movieId); err != nil {
-		s.doError(http.StatusBadRequest, "Invalid movie ID", w, r)
-		s.l.Info("invalid vote URL: %q", r.URL.Path)
-		return
+		return false, voteError("Voting is not enabled")
 	}
 
 	movie, err := s.data.GetMovie(movieId)
 	if err != nil {
-		s.doError(http.StatusBadRequest, "Invalid movie ID", w, r)
 		s.l.Info("Movie with ID %d doesn't exist", movieId)
-		return
+		return false, voteError("Invalid movie ID")
 	}
+
 	if movie.CycleWatched != nil {
-		s.doError(http.StatusBadRequest, "Movie already watched", w, r)
 		s.l.Error("Attempted to vote on watched movie ID %d", movieId)
-		return
+		return false, voteError("Movie already watched")
 	}
 
 	userVoted, err := s.data.UserVotedForMovie(user.Id, movieId)
 	if err != nil {
-		s.doError(http.StatusBadRequest, "Something went wrong :c", w, r)
-		s.l.Error("Cannot get user vote: %v", err)
-		return
+		return false, fmt.Errorf("Cannot get user vote: %v", err)
 	}
 
 	if userVoted {
-		//s.doError(http.StatusBadRequest, "You already voted for that movie!", w, r)
 		if err := s.data.DeleteVote(user.Id, movieId); err != nil {
-			s.doError(http.StatusBadRequest, "Something went wrong :c", w, r)
-			s.l.Error("Unable to remove vote: %v", err)
-			return
+			return false, fmt.Errorf("Unable to remove vote: %v", err)
 		}
-	} else {
 
-		unlimited, err := s.data.GetCfgBool(ConfigUnlimitedVotes, DefaultUnlimitedVotes)
+		s.publishVotes(movieId)
+		return false, nil
+	}
+
+	unlimited, err := s.data.GetCfgBool(ConfigUnlimitedVotes, DefaultUnlimitedVotes)
+	if err != nil {
+		return false, fmt.Errorf("Cannot get unlimited vote setting: %v", err)
+	}
+
+	if !unlimited {
+		// TODO: implement this on the data layer
+		votedMovies, err := s.data.GetUserVotes(user.Id)
 		if err != nil {
-			s.doError(
-				http.StatusBadRequest,
-				fmt.Sprintf("Cannot get unlimited vote setting: %v", err),
-				w, r)
-			return
+			return false, fmt.Errorf("Cannot get user votes: %v", err)
 		}
 
-		if !unlimited {
-			// TODO: implement this on the data layer
-			votedMovies, err := s.data.GetUserVotes(user.Id)
-			if err != nil {
-				s.doError(
-					http.StatusBadRequest,
-					fmt.Sprintf("Cannot get user votes: %v", err),
-					w, r)
-				return
+		count := 0
+		for _, movie := range votedMovies {
+			// Only count active movies
+			if movie.CycleWatched == nil && movie.Removed == false {
+				count++
 			}
+		}
 
-			count := 0
-			for _, movie := range votedMovies {
-				// Only count active movies
-				if movie.CycleWatched == nil && movie.Removed == false {
-					count++
-				}
-			}
+		maxVotes, err := s.data.GetCfgInt("MaxUserVotes", DefaultMaxUserVotes)
+		if err != nil {
+			s.l.Error("Error getting MaxUserVotes config setting: %v", err)
+			maxVotes = DefaultMaxUserVotes
+		}
 
-			maxVotes, err := s.data.GetCfgInt("MaxUserVotes", DefaultMaxUserVotes)
-			if err != nil {
-				s.l.Error("Error getting MaxUserVotes config setting: %v", err)
-				maxVotes = DefaultMaxUserVotes
-			}
+		if count >= maxVotes {
+			return false, voteError("You don't have any more available votes!")
+		}
+	}
 
-			if count >= maxVotes {
-				s.doError(http.StatusBadRequest,
-					"You don't have any more available votes!",
-					w, r)
-				return
-			}
+	if err := s.data.AddVote(user.Id, movieId); err != nil {
+		return false, fmt.Errorf("Unable to cast vote: %v", err)
+	}
+
+	s.publishVotes(movieId)
+	return true, nil
+}
+
+// Toggles votes
+func (s *Server) handlerVote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		s.doError(http.StatusMethodNotAllowed, "Votes must be POSTed", w, r)
+		return
+	}
+
+	user := s.getSessionUser(w, r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	var movieId int
+	if _, err := fmt.Sscanf(r.URL.Path, "/vote/%d", &movieId); err != nil {
+		s.doError(http.StatusBadRequest, "Invalid movie ID", w, r)
+		s.l.Info("invalid vote URL: %q", r.URL.Path)
+		return
+	}
+
+	voted, err := s.toggleVote(user, movieId)
+	if err != nil {
+		message := "Something went wrong :c"
+		if ve, ok := err.(voteError); ok {
+			message = ve.Error()
+		} else {
+			s.l.Error("Vote error: %v", err)
 		}
 
-		if err := s.data.AddVote(user.Id, movieId); err != nil {
-			s.doError(http.StatusBadRequest, "Something went wrong :c", w, r)
-			s.l.Error("Unable to cast vote: %v", err)
+		if wantsJson(r) {
+			writeJson(w, http.StatusBadRequest, voteResponse{MovieId: movieId, Error: s.requestLocale(user, r).T(message)})
 			return
 		}
+
+		s.doError(http.StatusBadRequest, message, w, r)
+		return
+	}
+
+	// Scripts on the cycle page vote without reloading
+	if wantsJson(r) {
+		resp := voteResponse{MovieId: movieId, Voted: voted}
+		if movie, err := s.data.GetMovie(movieId); err == nil {
+			resp.Votes = len(movie.Votes)
+		}
+
+		writeJson(w, http.StatusOK, resp)
+		return
 	}
 
 	ref := r.Header.Get("Referer")
 	if ref == "" {
 		http.Redirect(w, r, "/", http.StatusFound)
+		return
 	}
 	http.Redirect(w, r, ref, http.StatusFound)
 }
@@ -0,0 +1,363 @@
+package moviepoll
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+const (
+	webhookMaxAttempts   int           = 5
+	webhookTimeout       time.Duration = 10 * time.Second
+	webhookMaxDeliveries int           = 100
+)
+
+// Delay before the first retry.  It doubles with each retry after that.
+var webhookRetryDelay time.Duration = 30 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// Body of every webhook request
+type webhookPayload struct {
+	Event    string
+	Delivery string
+	Time     time.Time
+	Data     interface{}
+}
+
+// webhookDelivery is the state of a single payload sent to a single webhook.
+type webhookDelivery struct {
+	Id        string
+	WebhookId int
+	Url       string
+	Event     string
+	Created   time.Time
+
+	Attempts    int
+	LastAttempt time.Time
+	Status      int // HTTP status of the last attempt
+	Error       string
+
+	Delivered bool
+	Failed    bool // gave up after the last attempt
+}
+
+// webhookLog keeps the most recent deliveries in memory for the admin page.
+type webhookLog struct {
+	lock       *sync.Mutex
+	deliveries []*webhookDelivery
+}
+
+func newWebhookLog() *webhookLog {
+	return &webhookLog{lock: &sync.Mutex{}}
+}
+
+func (l *webhookLog) add(d *webhookDelivery) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.deliveries = append(l.deliveries, d)
+	if len(l.deliveries) > webhookMaxDeliveries {
+		l.deliveries = l.deliveries[len(l.deliveries)-webhookMaxDeliveries:]
+	}
+}
+
+// update runs fn with the lock held so readers never see a half updated
+// delivery.
+func (l *webhookLog) update(fn func()) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	fn()
+}
+
+// list returns copies of the deliveries, newest first.
+func (l *webhookLog) list() []webhookDelivery {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	list := []webhookDelivery{}
+	for i := len(l.deliveries) - 1; i >= 0; i-- {
+		list = append(list, *l.deliveries[i])
+	}
+	return list
+}
+
+// webhookEventName maps an event from the hub to the name used for webhooks.
+// Returns an empty string for events that aren't sent to webhooks.
+func webhookEventName(ev event) string {
+	switch ev.Type {
+	case EventMovie:
+		return common.WebhookMovieAdded
+	case EventApproved:
+		return common.WebhookMovieApproved
+	case EventRemoved:
+		return common.WebhookMovieRemoved
+	case EventCycle:
+		cycle, ok := ev.Data.(cycleEvent)
+		if !ok {
+			return ""
+		}
+
+		switch cycle.State {
+		case CycleStarted:
+			return common.WebhookCycleStarted
+		case CycleEnding:
+			return common.WebhookVotingClosed
+		case CycleEnded:
+			return common.WebhookCycleEnded
+		}
+	}
+	return ""
+}
+
+// startWebhooks subscribes to the event hub and sends events to the
+// configured webhooks until the server exits.
+func (s *Server) startWebhooks() {
+	// Votes are never sent to webhooks
+	ch := s.events.subscribeQueued(EventMovie, EventApproved, EventRemoved, EventCycle)
+	go func() {
+		for ev := range ch {
+			s.dispatchWebhooks(ev)
+		}
+	}()
+}
+
+func (s *Server) dispatchWebhooks(ev event) {
+	name := webhookEventName(ev)
+	if name == "" {
+		return
+	}
+
+	hooks, err := s.data.GetWebhooks()
+	if err != nil {
+		s.l.Error("Unable to get webhooks: %v", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if !hook.Enabled || !hook.Wants(name) {
+			continue
+		}
+
+		d := &webhookDelivery{
+			Id:        strings.ToLower(getCryptRandKey(16)),
+			WebhookId: hook.Id,
+			Url:       hook.Url,
+			Event:     name,
+			Created:   time.Now(),
+		}
+
+		payload, err := json.Marshal(webhookPayload{
+			Event:    name,
+			Delivery: d.Id,
+			Time:     d.Created,
+			Data:     ev.Data,
+		})
+		if err != nil {
+			s.l.Error("Unable to marshal webhook payload: %v", err)
+			return
+		}
+
+		s.webhookLog.add(d)
+		go s.deliverWebhook(hook, d, payload)
+	}
+}
+
+// deliverWebhook sends the payload, retrying with an increasing delay until
+// the webhook accepts it or the attempts run out.
+func (s *Server) deliverWebhook(hook *common.Webhook, d *webhookDelivery, payload []byte) {
+	delay := webhookRetryDelay
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		status, err := postWebhook(hook, d, payload)
+
+		s.webhookLog.update(func() {
+			d.Attempts = attempt
+			d.LastAttempt = time.Now()
+			d.Status = status
+			d.Error = ""
+			if err != nil {
+				d.Error = err.Error()
+			}
+
+			d.Delivered = err == nil
+			d.Failed = err != nil && attempt == webhookMaxAttempts
+		})
+
+		if err == nil {
+			return
+		}
+
+		s.l.Info("Webhook delivery %s to %s failed (attempt %d): %v", d.Id, hook.Url, attempt, err)
+		if attempt < webhookMaxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// postWebhook sends a single request.  Any 2xx response counts as delivered.
+func postWebhook(hook *common.Webhook, d *webhookDelivery, payload []byte) (int, error) {
+	req, err := http.NewRequest("POST", hook.Url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "MoviePolls-Webhook")
+	req.Header.Set("X-MoviePolls-Event", d.Event)
+	req.Header.Set("X-MoviePolls-Delivery", d.Id)
+	req.Header.Set("X-MoviePolls-Signature", "sha256="+hook.Sign(payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+type dataAdminWebhooks struct {
+	dataPageBase
+
+	Webhooks   []*common.Webhook
+	Events     []string
+	Deliveries []webhookDelivery
+
+	ErrorMessage []string
+}
+
+func (s *Server) handlerAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminRights(w, r) {
+		return
+	}
+
+	data := dataAdminWebhooks{Events: common.WebhookEvents}
+
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			s.doError(
+				http.StatusInternalServerError,
+				fmt.Sprintf("Unable to parse form: %v", err),
+				w, r)
+			return
+		}
+
+		var err error
+		switch r.PostFormValue("Form") {
+		case "Add":
+			err = s.adminAddWebhook(r, &data)
+		case "Update", "Delete":
+			err = s.adminEditWebhook(r, &data)
+		}
+
+		if err != nil {
+			s.doError(http.StatusInternalServerError, err.Error(), w, r)
+			return
+		}
+	}
+
+	var err error
+	data.Webhooks, err = s.data.GetWebhooks()
+	if err != nil {
+		s.doError(
+			http.StatusInternalServerError,
+			fmt.Sprintf("Unable to get webhooks: %v", err),
+			w, r)
+		return
+	}
+
+	data.Deliveries = s.webhookLog.list()
+	data.dataPageBase = s.newPageBase("Admin - Webhooks", w, r)
+
+	if err := s.executeTemplate(w, "adminWebhooks", data); err != nil {
+		s.l.Error("Error rendering template: %v", err)
+	}
+}
+
+// webhookFormEvents returns the known events checked in the form.
+func webhookFormEvents(r *http.Request) []string {
+	events := []string{}
+	for _, e := range r.PostForm["Events"] {
+		for _, known := range common.WebhookEvents {
+			if e == known {
+				events = append(events, e)
+			}
+		}
+	}
+	return events
+}
+
+func (s *Server) adminAddWebhook(r *http.Request, data *dataAdminWebhooks) error {
+	hook := &common.Webhook{
+		Url:     strings.TrimSpace(r.PostFormValue("Url")),
+		Secret:  strings.TrimSpace(r.PostFormValue("Secret")),
+		Events:  webhookFormEvents(r),
+		Enabled: true,
+	}
+
+	if err := common.ValidateWebhookUrl(hook.Url); err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, err.Error())
+		return nil
+	}
+
+	if hook.Secret == "" {
+		hook.Secret = getCryptRandKey(32)
+	}
+
+	if _, err := s.data.AddWebhook(hook); err != nil {
+		return fmt.Errorf("Unable to add webhook: %v", err)
+	}
+
+	s.l.Info("Webhook added for %s", hook.Url)
+	return nil
+}
+
+func (s *Server) adminEditWebhook(r *http.Request, data *dataAdminWebhooks) error {
+	id, err := strconv.Atoi(r.PostFormValue("Id"))
+	if err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, "Invalid webhook ID")
+		return nil
+	}
+
+	hook, err := s.data.GetWebhook(id)
+	if err != nil {
+		data.ErrorMessage = append(data.ErrorMessage, err.Error())
+		return nil
+	}
+
+	if r.PostFormValue("Form") == "Delete" {
+		if err = s.data.DeleteWebhook(id); err != nil {
+			return fmt.Errorf("Unable to delete webhook: %v", err)
+		}
+
+		s.l.Info("Webhook for %s deleted", hook.Url)
+		return nil
+	}
+
+	hook.Enabled = r.PostFormValue("Enabled") != ""
+	hook.Events = webhookFormEvents(r)
+	if r.PostFormValue("NewSecret") != "" {
+		hook.Secret = getCryptRandKey(32)
+	}
+
+	if err = s.data.UpdateWebhook(hook); err != nil {
+		return fmt.Errorf("Unable to update webhook: %v", err)
+	}
+	return nil
+}
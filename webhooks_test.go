@@ -0,0 +1,159 @@
+package moviepoll
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zorchenhimer/MoviePolls/common"
+)
+
+type receivedHook struct {
+	Event     string
+	Signature string
+	Body      []byte
+}
+
+// webhookReceiver fails the first `failures` requests, then accepts
+// everything.
+func webhookReceiver(failures int) (*httptest.Server, chan receivedHook) {
+	lock := &sync.Mutex{}
+	received := make(chan receivedHook, 10)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- receivedHook{
+			Event:     r.Header.Get("X-MoviePolls-Event"),
+			Signature: r.Header.Get("X-MoviePolls-Signature"),
+			Body:      body,
+		}
+	}))
+
+	return ts, received
+}
+
+func waitForHook(t *testing.T, received chan receivedHook) receivedHook {
+	t.Helper()
+
+	select {
+	case hook := <-received:
+		return hook
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for webhook")
+	}
+	return receivedHook{}
+}
+
+func Test_WebhookDelivery(t *testing.T) {
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+
+	ts, received := webhookReceiver(2)
+	defer ts.Close()
+
+	s := newTestServer(t)
+	s.startWebhooks()
+
+	hook := &common.Webhook{Url: ts.URL, Secret: "secret", Enabled: true, Events: []string{common.WebhookCycleEnded}}
+	if _, err := s.data.AddWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+
+	// Filtered out
+	s.publishCycle(&common.Cycle{Id: 1}, CycleStarted, nil)
+	s.publishCycle(&common.Cycle{Id: 1}, CycleEnded, []*common.Movie{&common.Movie{Id: 3, Name: "Winner"}})
+
+	got := waitForHook(t, received)
+	if got.Event != common.WebhookCycleEnded {
+		t.Fatalf("Expected %q event, got %q", common.WebhookCycleEnded, got.Event)
+	}
+
+	if got.Signature != "sha256="+hook.Sign(got.Body) {
+		t.Errorf("Invalid signature %q", got.Signature)
+	}
+
+	payload := struct {
+		Event string
+		Data  cycleEvent
+	}{}
+	if err := json.Unmarshal(got.Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(payload.Data.Winners) != 1 || payload.Data.Winners[0].Name != "Winner" {
+		t.Errorf("Unexpected payload: %s", got.Body)
+	}
+
+	deliveries := s.webhookLog.list()
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected one delivery in the log, got %d", len(deliveries))
+	}
+
+	// The log is updated after the request returns.
+	for i := 0; i < 100 && !s.webhookLog.list()[0].Delivered; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if d := s.webhookLog.list()[0]; !d.Delivered || d.Attempts != 3 {
+		t.Errorf("Expected delivery after three attempts, got %+v", d)
+	}
+}
+
+func Test_WebhookAdmin(t *testing.T) {
+	s := newTestServer(t)
+	admin := addTestUser(t, s, "admin", common.PRIV_ADMIN)
+	cookies := loginCookies(t, s, admin)
+	token := csrfTokenFor(t, s, cookies)
+
+	rec := postForm(s, "/admin/webhooks", url.Values{
+		"CsrfToken": {token},
+		"Form":      {"Add"},
+		"Url":       {"ftp://example.com"},
+	}, cookies)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "http or https") {
+		t.Fatalf("Expected invalid URL to be refused, got %d", rec.Code)
+	}
+
+	rec = postForm(s, "/admin/webhooks", url.Values{
+		"CsrfToken": {token},
+		"Form":      {"Add"},
+		"Url":       {"https://example.com/hook"},
+		"Events":    {common.WebhookMovieAdded, "bogus"},
+	}, cookies)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unable to add webhook: %d", rec.Code)
+	}
+
+	hooks, err := s.data.GetWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hooks) != 1 || hooks[0].Secret == "" || len(hooks[0].Events) != 1 || !hooks[0].Enabled {
+		t.Fatalf("Unexpected webhooks: %+v", hooks)
+	}
+
+	rec = postForm(s, "/admin/webhooks", url.Values{
+		"CsrfToken": {token},
+		"Form":      {"Delete"},
+		"Id":        {"1"},
+	}, cookies)
+	if hooks, _ = s.data.GetWebhooks(); len(hooks) != 0 {
+		t.Errorf("Webhook was not deleted")
+	}
+}